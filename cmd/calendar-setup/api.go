@@ -0,0 +1,150 @@
+//go:build !js
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackdorland/www/internal/crypto"
+	"github.com/jackdorland/www/internal/model"
+	"github.com/jackdorland/www/internal/output"
+)
+
+// api serves /api/events: the last refresh's events, filtered by the
+// query and rendered for this request, so several widgets can share one
+// server. The parameters are all optional:
+//
+//	from, to  RFC 3339 times or YYYY-MM-DD dates (in -timezone); events
+//	          overlapping [from, to) are kept
+//	calendar  comma-separated feed numbers, like 1,3
+//	tier      the tier to encrypt for, when the config has tiers; by
+//	          default the one that shows least (see output.LeastTier)
+//	format    an encrypted format (default -format), or json with
+//	          -plaintext, or ics with -ics (and its token)
+func (s *server) api(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s.mu.RLock()
+	events, sources, sum, modTime := s.events, s.sources, s.sum, s.lastGenerated
+	s.mu.RUnlock()
+	if events == nil {
+		http.Error(w, "no events yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	events, err := s.filter(events, q.Get("from"), q.Get("to"), q.Get("calendar"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	keys := s.cfg.Crypto()
+	if cfg := s.cfg; cfg != nil && len(cfg.Tiers) > 0 {
+		tier := output.LeastTier(cfg.Tiers)
+		if name := q.Get("tier"); name != "" {
+			i := slices.IndexFunc(cfg.Tiers, func(t crypto.TierConfig) bool { return t.Name == name })
+			if i < 0 {
+				http.Error(w, "unknown tier", http.StatusBadRequest)
+				return
+			}
+			tier = cfg.Tiers[i]
+		}
+		keys, events = cfg.ForTier(tier), output.TierEvents(tier, events)
+	}
+
+	h := w.Header()
+	h.Set("Cache-Control", "private, no-cache")
+	var data []byte
+	switch format := q.Get("format"); format {
+	case "json":
+		if !s.plaintext {
+			http.Error(w, "json needs -plaintext", http.StatusForbidden)
+			return
+		}
+		data, err = output.Marshal(events, sources)
+		h.Set("Content-Type", "application/json")
+	case "ics":
+		if !s.ics || s.icsToken != "" && subtle.ConstantTimeCompare([]byte(q.Get("token")), []byte(s.icsToken)) != 1 {
+			http.NotFound(w, r)
+			return
+		}
+		data = output.ICS(events, s.interval)
+		h.Set("Content-Type", "text/calendar; charset=utf-8")
+	default:
+		if format == "" {
+			format = s.enc.format
+		}
+		if strings.HasPrefix(format, output.TemplatePrefix) {
+			http.Error(w, "format must be an encrypted format", http.StatusBadRequest)
+			return
+		}
+		data, err = output.Encrypt(keys, format, s.enc.passphrase, events, sources)
+		h.Set("Content-Type", "application/octet-stream")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// every render differs (a new timestamp or nonce) but means the same
+	// until the events change, so the ETag is weak, from the events and
+	// the query, and polling clients get 304s
+	tag := sha256.Sum256(append(sum[:], q.Encode()...))
+	h.Set("ETag", `W/"`+hex.EncodeToString(tag[:])+`"`)
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+}
+
+// filter keeps the events in [from, to) from the given calendars.
+func (s *server) filter(events []model.Event, from, to, calendars string) ([]model.Event, error) {
+	loc, err := time.LoadLocation(s.render.timezone)
+	if err != nil {
+		return nil, err
+	}
+	var start, end time.Time
+	if from != "" {
+		if start, err = parseAPITime(from, loc); err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+	}
+	if to != "" {
+		if end, err = parseAPITime(to, loc); err != nil {
+			return nil, fmt.Errorf("to: %w", err)
+		}
+	}
+	var want []int
+	if calendars != "" {
+		for _, c := range strings.Split(calendars, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(c))
+			if err != nil {
+				return nil, fmt.Errorf("calendar: %w", err)
+			}
+			want = append(want, n)
+		}
+	}
+
+	kept := []model.Event{}
+	for _, e := range events {
+		if !start.IsZero() && !e.End.After(start) || !end.IsZero() && !e.Start.Before(end) {
+			continue
+		}
+		if want != nil && !slices.Contains(want, e.Calendar) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	return kept, nil
+}
+
+// parseAPITime parses an RFC 3339 time, or a date as its midnight in loc.
+func parseAPITime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, s, loc); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/jackdorland/www/internal/crypto"
+)
+
+// runEncryptConfig implements the encrypt-config command: seal a JSON
+// config with CAL_CONFIG_KEY so it can be committed alongside the code.
+// The decrypt command reads it back with CAL_KEY set to the same key.
+func runEncryptConfig(args []string) error {
+	fs := flag.NewFlagSet("encrypt-config", flag.ExitOnError)
+	out := fs.String("o", "", "output path (default <file>.enc)")
+	registerLogging(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: encrypt-config [-o output] config.json")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := fs.Arg(0)
+	if *out == "" {
+		*out = path + ".enc"
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sealed, err := crypto.EncryptConfig(data)
+	if err != nil {
+		return withCode(exitConfig, fmt.Errorf("encrypting %s: %w", path, err))
+	}
+	if err := os.WriteFile(*out, sealed, 0600); err != nil {
+		return withCode(exitWrite, err)
+	}
+	slog.Info("Encrypted config", "path", path, "output", *out)
+	return nil
+}
@@ -0,0 +1,36 @@
+//go:build !js
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// registerTimeout adds -timeout to fs, for commands that fetch.
+func registerTimeout(fs *flag.FlagSet) *time.Duration {
+	return durationFlag(fs, "timeout", "CAL_TIMEOUT", 5*time.Minute, "give up on a run after this long (0 for no limit)")
+}
+
+// withTimeout bounds ctx by timeout, if it's positive.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// commandContext is the context a one-shot command runs under: cancelled
+// by SIGINT or SIGTERM, or once timeout passes.
+func commandContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := withTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
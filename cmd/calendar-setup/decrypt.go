@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/jackdorland/www/internal/crypto"
+)
+
+// runDecrypt implements the decrypt command: read an output file in any
+// format this tool has written and print the JSON inside.
+func runDecrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	var conf configOptions
+	conf.register(fs)
+	passphrase := fs.Bool("passphrase", false, "treat CAL_KEY as a passphrase")
+	pretty := fs.Bool("pretty", false, "indent the printed JSON")
+	registerLogging(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: decrypt [flags] [file (default the profile's output, $CAL_OUTPUT or docs/cal.aes)]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	cfg, err := conf.load()
+	if err != nil {
+		return err
+	}
+	path := envDefault("CAL_OUTPUT", "docs/cal.aes")
+	if cfg != nil && cfg.Output != "" {
+		path = cfg.Output
+	}
+	if fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if ok, err := crypto.VerifySignature(path, data); err != nil {
+		return fmt.Errorf("verifying signature: %w", err)
+	} else if ok {
+		slog.Info("Signature OK", "path", path)
+	}
+
+	plaintext, err := crypto.DecryptCalendar(cfg.Crypto(), *passphrase, data)
+	if err != nil {
+		return fmt.Errorf("decrypting %s: %w", path, err)
+	}
+
+	if *pretty {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, plaintext, "", "  "); err != nil {
+			return fmt.Errorf("decrypted payload is not JSON: %w", err)
+		}
+		crypto.Wipe(plaintext)
+		plaintext = buf.Bytes()
+	}
+	os.Stdout.Write(plaintext)
+	fmt.Println()
+	crypto.Wipe(plaintext)
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+)
+
+// Exit codes, so the scheduler can tell a bad config from a flaky feed.
+// The flag package already exits with 2 for usage errors.
+const (
+	exitFailure       = 1 // anything not covered below
+	exitConfig        = 3 // flags, config file or keys are invalid
+	exitSourcesFailed = 4 // every feed failed; nothing was written
+	exitPartial       = 5 // some feeds failed; the rest were published
+	exitWrite         = 6 // an output file couldn't be written
+	exitLocked        = 7 // another run holds -lock
+)
+
+// codedError attaches an exit code to err.
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+// withCode returns err with the given exit code, or nil if err is nil.
+func withCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{code, err}
+}
+
+// stopProfiling finishes the -pprof profiles, if any; see registerPprof.
+var stopProfiling = func() {}
+
+// exit finishes any profiles, logs every error joined into err and exits
+// with its exitCode. It returns if err is nil.
+func exit(err error) {
+	stopProfiling()
+	if err == nil {
+		return
+	}
+	logErrors(err)
+	os.Exit(exitCode(err))
+}
+
+// exitCode is the code exit would use for err: 0 if it's nil, otherwise
+// that of the first error joined into it that has one.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ce *codedError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	return exitFailure
+}
+
+// logErrors logs every error joined into err.
+func logErrors(err error) {
+	for _, e := range flatten(err) {
+		slog.Error("Failed", "err", e)
+	}
+}
+
+// flatten splits errors.Join trees into their leaves.
+func flatten(err error) []error {
+	if ce, ok := err.(*codedError); ok {
+		return flatten(ce.err)
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []error
+		for _, e := range joined.Unwrap() {
+			errs = append(errs, flatten(e)...)
+		}
+		return errs
+	}
+	return []error{err}
+}
@@ -0,0 +1,139 @@
+//go:build !js
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jackdorland/www/internal/clock"
+)
+
+// feedState is the -feed-state file: each feed's hash as of the last run
+// that fetched it, when it last changed, and how many events it had,
+// keyed like feedCache. A feed that normally changes every day but hasn't
+// for -unchanged-days has usually had its secret URL rotated, leaving the
+// old one serving a frozen copy. One that usually has events but suddenly
+// parses to none has usually changed its format in a way the parser
+// silently skips.
+type feedState map[string]feedSeen
+
+type feedSeen struct {
+	SHA256  string    `json:"sha256"`
+	Changed time.Time `json:"changed"`
+	// Reported is set once the feed has been found unchanged for too
+	// long, so "unchanged" notifiers are only told once until it changes.
+	Reported bool `json:"reported,omitempty"`
+	// Events are the feed's event counts as of its last changes, oldest
+	// first, up to eventHistory of them.
+	Events []int `json:"events,omitempty"`
+	// Empty is set once the feed has been found empty when it usually
+	// isn't, so "empty" notifiers are only told once until it has events
+	// again.
+	Empty bool `json:"empty,omitempty"`
+}
+
+// eventHistory is how many of a feed's event counts are kept, and
+// emptyHistory how many it needs before an empty feed is unusual.
+const (
+	eventHistory = 10
+	emptyHistory = 3
+)
+
+// feedSum is a feed's hash, and how many events it had, as fetched by the
+// run.
+type feedSum struct {
+	calendar int
+	name     string
+	sum      [sha256.Size]byte
+	events   int
+}
+
+// hashed records the hash and event count of a feed the run fetched.
+func (r *runReport) hashed(calendar int, name string, sum [sha256.Size]byte, events int) {
+	if r == nil {
+		return
+	}
+	r.sums = append(r.sums, feedSum{calendar, name, sum, events})
+}
+
+// checkFeeds updates -feed-state with the feeds the run fetched, and
+// records those unchanged for -unchanged-days and those that are empty
+// when they usually aren't. Errors are coded exitWrite.
+func (r *runReport) checkFeeds() error {
+	if r.mon.feedState == "" {
+		return nil
+	}
+	state := make(feedState)
+	data, err := os.ReadFile(r.mon.feedState)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return withCode(exitWrite, fmt.Errorf("reading feed state: %w", err))
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return withCode(exitWrite, fmt.Errorf("reading feed state: %w", err))
+		}
+	}
+
+	now := clock.Now()
+	limit := time.Duration(r.mon.unchangedDays) * 24 * time.Hour
+	for _, f := range r.sums {
+		key := strconv.Itoa(f.calendar) + " " + f.name
+		sum := hex.EncodeToString(f.sum[:])
+		seen, ok := state[key]
+		if !ok || seen.SHA256 != sum {
+			empty := f.events == 0 && len(seen.Events) >= emptyHistory && median(seen.Events) > 0
+			events := append(seen.Events, f.events)
+			state[key] = feedSeen{SHA256: sum, Changed: now, Events: events[max(len(events)-eventHistory, 0):], Empty: empty}
+			// a feed still empty may change, with a new DTSTAMP say
+			if empty && !seen.Empty {
+				r.newlyEmpty = append(r.newlyEmpty, f)
+			}
+		}
+		if seen = state[key]; seen.Empty {
+			slog.Warn("Feed parsed to no events though it usually has some; has its format changed?", "calendar", f.calendar, "name", f.name)
+			for i := range r.Calendars {
+				if c := &r.Calendars[i]; c.Calendar == f.calendar {
+					c.Empty = true
+				}
+			}
+		}
+		if limit <= 0 || now.Sub(seen.Changed) < limit {
+			continue
+		}
+		slog.Warn("Feed hasn't changed in days; has its URL been rotated?", "calendar", f.calendar, "name", f.name, "since", seen.Changed.Format(time.RFC3339))
+		for i := range r.Calendars {
+			if c := &r.Calendars[i]; c.Calendar == f.calendar && (c.UnchangedSince.IsZero() || seen.Changed.Before(c.UnchangedSince)) {
+				c.UnchangedSince = seen.Changed
+			}
+		}
+		if !seen.Reported {
+			r.newlyUnchanged = append(r.newlyUnchanged, f)
+			seen.Reported = true
+			state[key] = seen
+		}
+	}
+
+	if data, err = json.MarshalIndent(state, "", "  "); err == nil {
+		err = writeLocal(r.mon.feedState, append(data, '\n'))
+	}
+	if err != nil {
+		return withCode(exitWrite, fmt.Errorf("writing feed state: %w", err))
+	}
+	return nil
+}
+
+// median is the median of counts, the upper one of an even number.
+func median(counts []int) int {
+	sorted := slices.Sorted(slices.Values(counts))
+	return sorted[len(sorted)/2]
+}
@@ -0,0 +1,664 @@
+//go:build !js
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+
+	"github.com/jackdorland/www/internal/clock"
+	"github.com/jackdorland/www/internal/config"
+	"github.com/jackdorland/www/internal/model"
+	"github.com/jackdorland/www/internal/recur"
+	"github.com/jackdorland/www/internal/source"
+	"github.com/jackdorland/www/internal/store"
+	"github.com/jackdorland/www/internal/timing"
+	"github.com/jackdorland/www/internal/tracing"
+)
+
+// fetchWorkers is how many feeds are downloaded at once, fetchRetries
+// how many times a transient failure is retried, and breakerThreshold how
+// many failures in a row stop a run fetching from a host; see
+// registerFetchWorkers.
+var (
+	fetchWorkers     = 8
+	fetchRetries     = 2
+	breakerThreshold = 5
+)
+
+// registerFetchWorkers defines -fetch-workers, and -fetch-retries and
+// -breaker-threshold, which also decide how feeds are fetched.
+func registerFetchWorkers(fs *flag.FlagSet) {
+	if v := os.Getenv("CAL_FETCH_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			fatal("Invalid CAL_FETCH_WORKERS", "value", v)
+		}
+		fetchWorkers = n
+	}
+	fs.IntVar(&fetchWorkers, "fetch-workers", fetchWorkers, "how many feeds to fetch at once, each worker with its own HTTP connections (env CAL_FETCH_WORKERS)")
+	intVarFlag(fs, &fetchRetries, "fetch-retries", "CAL_FETCH_RETRIES", fetchRetries, "how many times to retry a feed after a network error, 5xx or 429, with a growing, random backoff")
+	intVarFlag(fs, &breakerThreshold, "breaker-threshold", "CAL_BREAKER_THRESHOLD", breakerThreshold, "stop fetching from a host for the rest of the run once it has failed this many times in a row, retries included (0 never stops)")
+}
+
+// workerClients are the fetch workers' HTTP clients, kept between runs so
+// that their connections are reused.
+var workerClients struct {
+	sync.Mutex
+	clients []*http.Client
+}
+
+// workerClient returns the w'th fetch worker's HTTP client.
+func workerClient(w int) *http.Client {
+	workerClients.Lock()
+	defer workerClients.Unlock()
+	for len(workerClients.clients) <= w {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		workerClients.clients = append(workerClients.clients, &http.Client{Transport: transport})
+	}
+	return workerClients.clients[w]
+}
+
+// feed is one parsed calendar and where it came from.
+type feed struct {
+	// calendar is the position of the spec it came from, counting from
+	// 1 like CALENDAR_<n>.
+	calendar int
+	// raw has no Data if the feed was streamed; see fetchSpec.
+	raw source.RawCalendar
+	// cal is nil if the feed is unchanged since the last run, which
+	// leaves nothing to parse; see fetchEvents.
+	cal *ics.Calendar
+	// events is how many events the feed had, which is more than cal
+	// has if it was streamed.
+	events int
+
+	// sum is the SHA-256 of the feed's body. expanded is its events
+	// across the span and skipped how many of them couldn't be read,
+	// from feedCache if it's unchanged. fetchEvents sets them.
+	sum      [sha256.Size]byte
+	expanded []model.Event
+	skipped  int
+	// truncated lists the limits the feed was cut short by, named by
+	// their flags.
+	truncated []string
+	// stale is set if the feed couldn't be fetched, and its events are
+	// from the last run that did, at that time; see lastGood.
+	stale time.Time
+}
+
+// truncate records that f was cut short by limit.
+func (f *feed) truncate(limit string) {
+	if !slices.Contains(f.truncated, limit) {
+		f.truncated = append(f.truncated, limit)
+	}
+}
+
+// limits cap what a run reads and keeps, so one pathological feed can't
+// exhaust a small machine's memory. Zero means no limit. A feed over one
+// is cut short, with a warning, rather than failing.
+type limits struct {
+	feedSize    int64
+	events      int
+	occurrences int
+}
+
+// span is the stretch of time fetchEvents expands feeds over: the window
+// and the day before it, for ongoing, widened to whole UTC days so that
+// it's the same from run to run through the day. It carries the limits
+// too, which also decide what's read, and the -store if one's open.
+type span struct {
+	from, to time.Time
+	loc      *time.Location
+	limits   limits
+	store    *store.Store
+	// profile is the config profile the feeds are published for, which
+	// keeps each profile's apart in feedCache.
+	profile string
+}
+
+// params is what, besides the span itself, decides a feed's events, for
+// the store to check.
+func (sp *span) params() string {
+	return fmt.Sprintf("%s %+v", sp.loc, sp.limits)
+}
+
+// feedCache holds each feed's events from the last run, so that one whose
+// body hasn't changed isn't parsed or expanded again. It's keyed by the
+// feed's profile, calendar and name.
+var feedCache = struct {
+	sync.Mutex
+	feeds map[string]cachedFeed
+}{feeds: make(map[string]cachedFeed)}
+
+type cachedFeed struct {
+	sum       [sha256.Size]byte
+	span      span
+	events    int
+	expanded  []model.Event
+	skipped   int
+	truncated []string
+	fetched   time.Time
+}
+
+func (f *feed) cacheKey(sp *span) string {
+	return fmt.Sprintf("%s %d %s", sp.profile, f.calendar, f.raw.Name)
+}
+
+// reuse fills in f's events from feedCache, or failing that the store, if
+// its body is unchanged since it was expanded over sp, and reports whether
+// it did.
+func (f *feed) reuse(sp *span) bool {
+	feedCache.Lock()
+	c, ok := feedCache.feeds[f.cacheKey(sp)]
+	feedCache.Unlock()
+	if !ok || c.sum != f.sum || !c.span.from.Equal(sp.from) || !c.span.to.Equal(sp.to) || c.span.loc.String() != sp.loc.String() || c.span.limits != sp.limits {
+		return sp.store != nil && f.reuseStored(sp)
+	}
+	slog.Debug("Feed unchanged; reusing its events", "calendar", f.calendar, "name", f.raw.Name)
+	f.events, f.expanded, f.skipped = c.events, c.expanded, c.skipped
+	for _, limit := range c.truncated {
+		f.truncate(limit)
+	}
+	f.cache(sp)
+	if sp.store != nil {
+		if err := sp.store.Touch(f.calendar, f.raw.Name, clock.Now()); err != nil {
+			slog.Warn("Couldn't update the feed in the store", "calendar", f.calendar, "name", f.raw.Name, "err", err)
+		}
+	}
+	return true
+}
+
+// reuseStored is reuse for the store, which outlasts the process, so a run
+// from cron needn't reparse an unchanged feed either.
+func (f *feed) reuseStored(sp *span) bool {
+	stored, events, ok, err := sp.store.Feed(f.calendar, f.raw.Name)
+	if err != nil {
+		slog.Warn("Couldn't read the feed from the store", "calendar", f.calendar, "name", f.raw.Name, "err", err)
+		return false
+	}
+	if !ok || stored.SHA256 != hex.EncodeToString(f.sum[:]) || !stored.From.Equal(sp.from) || !stored.To.Equal(sp.to) || stored.Params != sp.params() {
+		return false
+	}
+	slog.Debug("Feed unchanged; reusing its stored events", "calendar", f.calendar, "name", f.raw.Name)
+	f.events, f.expanded, f.skipped = stored.Events, events, stored.Skipped
+	for _, limit := range stored.Truncated {
+		f.truncate(limit)
+	}
+	f.cache(sp)
+	if err := sp.store.Touch(f.calendar, f.raw.Name, clock.Now()); err != nil {
+		slog.Warn("Couldn't update the feed in the store", "calendar", f.calendar, "name", f.raw.Name, "err", err)
+	}
+	return true
+}
+
+// remember stores f's events in feedCache, and the store if there is one,
+// for the next run.
+func (f *feed) remember(sp *span) {
+	f.cache(sp)
+	if sp.store == nil {
+		return
+	}
+	stored := store.Feed{
+		Calendar:  f.calendar,
+		Name:      f.raw.Name,
+		SHA256:    hex.EncodeToString(f.sum[:]),
+		From:      sp.from,
+		To:        sp.to,
+		Params:    sp.params(),
+		Events:    f.events,
+		Skipped:   f.skipped,
+		Truncated: f.truncated,
+		Updated:   clock.Now(),
+	}
+	if err := sp.store.Put(stored, f.expanded); err != nil {
+		slog.Warn("Couldn't store the feed", "calendar", f.calendar, "name", f.raw.Name, "err", err)
+	}
+}
+
+// cache stores f's events in feedCache, as fetched now.
+func (f *feed) cache(sp *span) {
+	feedCache.Lock()
+	feedCache.feeds[f.cacheKey(sp)] = cachedFeed{f.sum, *sp, f.events, f.expanded, f.skipped, f.truncated, clock.Now()}
+	feedCache.Unlock()
+}
+
+// lastGood returns the calendar'th spec's feeds as of the last run that
+// fetched them, from feedCache or failing that the store, for when it
+// can't be fetched now. Their events are whatever of the window that run
+// expanded.
+func lastGood(calendar int, sp *span) []feed {
+	var feeds []feed
+	prefix := sp.profile + " " + strconv.Itoa(calendar) + " "
+	feedCache.Lock()
+	for key, c := range feedCache.feeds {
+		if name, ok := strings.CutPrefix(key, prefix); ok {
+			feeds = append(feeds, feed{calendar: calendar, raw: source.RawCalendar{Name: name}, events: c.events, expanded: c.expanded, skipped: c.skipped, truncated: c.truncated, stale: c.fetched})
+		}
+	}
+	feedCache.Unlock()
+	if len(feeds) > 0 || sp.store == nil {
+		slices.SortFunc(feeds, func(a, b feed) int { return strings.Compare(a.raw.Name, b.raw.Name) })
+		return feeds
+	}
+	stored, events, err := sp.store.Calendar(calendar)
+	if err != nil {
+		slog.Warn("Couldn't read the calendar from the store", "calendar", calendar, "err", err)
+		return nil
+	}
+	for i, f := range stored {
+		feeds = append(feeds, feed{calendar: calendar, raw: source.RawCalendar{Name: f.Name}, events: f.Events, expanded: events[i], skipped: f.Skipped, truncated: f.Truncated, stale: f.Updated})
+	}
+	return feeds
+}
+
+// fetchAll fetches every configured feed; see fetchSpecs.
+func fetchAll(ctx context.Context, cfg *config.Config, rep *runReport) ([]feed, error) {
+	return fetchSpecs(ctx, feedSpecs(cfg), rep)
+}
+
+// feedSpecs returns the config's feeds, or CALENDAR_1 to CALENDAR_3 if it
+// lists none.
+func feedSpecs(cfg *config.Config) []string {
+	if cfg != nil && len(cfg.Feeds) > 0 {
+		return cfg.Feeds
+	}
+	return source.URLs()
+}
+
+// fetchSpecs fetches and parses the feeds named by specs, skipping those
+// that fail, and records each in rep. The failures are joined into an
+// error coded exitPartial, or exitSourcesFailed (with no feeds) if all of
+// them failed.
+func fetchSpecs(ctx context.Context, specs []string, rep *runReport) ([]feed, error) {
+	breaker := source.NewBreaker(breakerThreshold)
+	fetched := make([]fetchResult, len(specs))
+	for r := range fetchStream(source.WithBreaker(ctx, breaker), specs, nil) {
+		fetched[r.index] = r
+	}
+	rep.openCircuits(breaker.Open())
+	return collectFeeds(fetched, rep)
+}
+
+// fetchResult is one spec's feeds, from fetchStream.
+type fetchResult struct {
+	// index is the spec's position, counting from 0.
+	index int
+	feeds []feed
+	took  time.Duration
+	err   error
+}
+
+// fetchStream fetches and parses the feeds named by specs with a pool of
+// -fetch-workers workers, each with its own HTTP client, and sends each
+// spec's result as it finishes. The channel is closed once all of them
+// have. Failures are retried -fetch-retries times, sharing any
+// source.Breaker in ctx. See fetchSpec for sp.
+func fetchStream(ctx context.Context, specs []string, sp *span) <-chan fetchResult {
+	results := make(chan fetchResult)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	ctx = source.WithRetries(ctx, fetchRetries)
+	for w := range min(max(fetchWorkers, 1), len(specs)) {
+		ctx := source.WithClient(ctx, workerClient(w))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer reportPanics()
+			for i := range jobs {
+				start := time.Now()
+				specCtx, span := tracing.Start(ctx, "fetch", "calendar", i+1)
+				if u, err := url.Parse(specs[i]); err == nil && u.Host != "" {
+					// only the host: feed URLs are often secret
+					span.SetAttr("host", u.Host)
+				}
+				feeds, err := fetchSpec(specCtx, i+1, specs[i], sp)
+				span.SetAttr("feeds", len(feeds))
+				span.End(err)
+				results <- fetchResult{index: i, feeds: feeds, took: time.Since(start), err: err}
+			}
+		}()
+	}
+	go func() {
+		for _, i := range fairOrder(specs) {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// fairOrder returns the indexes of specs taking each host in turn, so a
+// host with many feeds doesn't tie up every worker while the others wait.
+func fairOrder(specs []string) []int {
+	var hosts []string
+	byHost := make(map[string][]int)
+	for i, spec := range specs {
+		var host string
+		if u, err := url.Parse(spec); err == nil {
+			host = u.Host
+		}
+		if _, ok := byHost[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], i)
+	}
+	order := make([]int, 0, len(specs))
+	for len(order) < len(specs) {
+		for _, host := range hosts {
+			if queue := byHost[host]; len(queue) > 0 {
+				order = append(order, queue[0])
+				byHost[host] = queue[1:]
+			}
+		}
+	}
+	return order
+}
+
+// collectFeeds records each of fetched in rep, in spec order, and joins
+// their feeds and errors as fetchSpecs returns them.
+func collectFeeds(fetched []fetchResult, rep *runReport) ([]feed, error) {
+	var feeds []feed
+	var errs []error
+	for i, r := range fetched {
+		events := 0
+		for _, f := range r.feeds {
+			events += f.events
+		}
+		rep.fetched(i+1, r.took, events, r.err)
+		feeds = append(feeds, r.feeds...)
+		if r.err != nil {
+			errs = append(errs, fmt.Errorf("calendar %d: %w", i+1, r.err))
+		}
+	}
+	switch {
+	case len(errs) == 0:
+		return feeds, nil
+	case len(feeds) == 0:
+		return nil, withCode(exitSourcesFailed, errors.Join(errs...))
+	default:
+		return feeds, withCode(exitPartial, errors.Join(errs...))
+	}
+}
+
+// fetchSpec fetches and parses the feeds named by one spec, the calendar'th.
+// It returns those that parsed even if others didn't. If sp is set, each
+// feed is filtered as it's read to the events that could fall in sp (see
+// source.FilterWindow), streamed if the source allows, held to sp's
+// limits, and hashed, and not parsed at all if it's unchanged since the
+// last run.
+func fetchSpec(ctx context.Context, calendar int, spec string, sp *span) ([]feed, error) {
+	src, err := source.Open(spec)
+	if err != nil {
+		return nil, err
+	}
+	var feeds []feed
+	var errs []error
+	// parsing is timed on its own, and left out of fetching
+	start := time.Now()
+	var parsing time.Duration
+	defer func() {
+		timing.Add(timing.Fetch, time.Since(start)-parsing)
+		timing.Add(timing.Parse, parsing)
+	}()
+	add := func(f feed) {
+		slog.Info("Fetched calendar", "calendar", calendar, "name", f.raw.Name, "events", f.events)
+		feeds = append(feeds, f)
+	}
+
+	if sp != nil {
+		read := func(name string, r io.Reader) error {
+			f := feed{calendar: calendar, raw: source.RawCalendar{Name: name}}
+			body := r
+			if sp.limits.feedSize > 0 {
+				body = io.LimitReader(r, sp.limits.feedSize)
+			}
+			h := sha256.New()
+			var kept bytes.Buffer
+			events, truncated, err := source.FilterWindow(&kept, io.TeeReader(body, h), sp.from, sp.to, sp.limits.events)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("reading %s: %w", name, err))
+				return nil
+			}
+			if truncated {
+				f.truncate("max-events")
+			}
+			if sp.limits.feedSize > 0 {
+				// anything left over was cut off
+				if n, _ := io.ReadFull(r, make([]byte, 1)); n > 0 {
+					f.truncate("max-feed-size")
+				}
+			}
+			h.Sum(f.sum[:0])
+			if !f.reuse(sp) {
+				parseStart := time.Now()
+				_, span := tracing.Start(ctx, "parse", "calendar", calendar, "name", name, "bytes", kept.Len())
+				f.cal, err = ics.ParseCalendar(&kept)
+				span.End(err)
+				parsing += time.Since(parseStart)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("parsing %s: %w", name, err))
+					return nil
+				}
+				f.events = events
+			}
+			add(f)
+			return nil
+		}
+		if st, ok := src.(source.Streamer); ok {
+			err = st.Stream(ctx, read)
+		} else {
+			var raws []source.RawCalendar
+			raws, err = src.Fetch(ctx)
+			for _, raw := range raws {
+				read(raw.Name, bytes.NewReader(raw.Data))
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return feeds, errors.Join(errs...)
+	}
+
+	raws, err := src.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, raw := range raws {
+		parseStart := time.Now()
+		_, span := tracing.Start(ctx, "parse", "calendar", calendar, "name", raw.Name, "bytes", len(raw.Data))
+		cal, err := raw.Parse()
+		span.End(err)
+		parsing += time.Since(parseStart)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		add(feed{calendar: calendar, raw: raw, cal: cal, events: len(cal.Events())})
+	}
+	return feeds, errors.Join(errs...)
+}
+
+// fetchEvents fetches the feeds named by specs and expands them into the
+// events inside the window, as a pipeline: feeds are streamed and filtered
+// as they download where the source allows, each is expanded as soon as
+// it's parsed, while slower ones are still downloading, and every feed
+// in its own goroutine, since long RRULEs are CPU-bound. A feed unchanged
+// since the last run, or kept in -store, isn't parsed or expanded at all.
+// The results are put
+// back in spec order, so the events, and their hash, don't depend on
+// which feed finished first. The window includes its start but not its
+// end, for single and recurring events alike.
+//
+// A calendar that fails falls back on its events from the last run that
+// fetched it (see lastGood), unless every calendar failed. sources says
+// how each came out.
+//
+// fetchErr is fetchSpecs' error, for the feeds that failed; err is for a
+// bad window or time zone, or an expansion cut short by ctx, after which
+// nothing else is returned. Each feed is recorded in rep.
+func (o *renderOptions) fetchEvents(ctx context.Context, specs []string, rep *runReport) (feeds []feed, events []model.Event, sources []model.Source, fetchErr, err error) {
+	windowStart, windowEnd, loc, err := o.bounds()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	slog.Debug("Publishing window", "start", windowStart.Format(time.RFC3339), "end", windowEnd.Format(time.RFC3339), "timezone", loc.String())
+	sp := &span{
+		from:    windowStart.Add(-24 * time.Hour).UTC().Truncate(24 * time.Hour),
+		to:      windowEnd.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour),
+		loc:     loc,
+		limits:  o.limits,
+		profile: o.profile,
+	}
+	if o.store != "" {
+		st, err := store.Open(o.store)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		defer st.Close()
+		sp.store = st
+	}
+
+	breaker := source.NewBreaker(breakerThreshold)
+	fetched := make([]fetchResult, len(specs))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var expandErr error
+	for r := range fetchStream(source.WithBreaker(ctx, breaker), specs, sp) {
+		fetched[r.index] = r
+		for j := range r.feeds {
+			f := &r.feeds[j]
+			if f.cal == nil {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer reportPanics()
+				var err error
+				start := time.Now()
+				_, span := tracing.Start(ctx, "expand", "calendar", f.calendar, "name", f.raw.Name)
+				f.expanded, f.skipped, err = recur.Expand(ctx, f.cal, sp.from, sp.to, loc, o.limits.occurrences)
+				span.SetAttr("occurrences", len(f.expanded))
+				span.SetAttr("skipped", f.skipped)
+				span.End(err)
+				timing.Since(timing.Expand, start)
+				if errors.Is(err, recur.ErrLimit) {
+					f.truncate("max-occurrences")
+				} else if err != nil {
+					mu.Lock()
+					expandErr = errors.Join(expandErr, fmt.Errorf("calendar %d: %w", f.calendar, err))
+					mu.Unlock()
+					return
+				}
+				for k := range f.expanded {
+					f.expanded[k].Calendar = f.calendar
+				}
+				f.remember(sp)
+			}()
+		}
+	}
+	wg.Wait()
+	rep.openCircuits(breaker.Open())
+
+	sources = make([]model.Source, len(specs))
+	someFetched := slices.ContainsFunc(fetched, func(r fetchResult) bool { return len(r.feeds) > 0 })
+	for i := range fetched {
+		r := &fetched[i]
+		sources[i] = model.Source{Calendar: i + 1, Status: model.SourceOK}
+		if r.err == nil {
+			continue
+		}
+		sources[i].Status = model.SourceFailed
+		if len(r.feeds) > 0 || !someFetched {
+			continue
+		}
+		if r.feeds = lastGood(i+1, sp); len(r.feeds) > 0 {
+			sources[i].Status = model.SourceStale
+			for _, f := range r.feeds {
+				if f.stale.After(sources[i].Updated) {
+					sources[i].Updated = f.stale
+				}
+			}
+			slog.Warn("Calendar failed; publishing its events from the last run that fetched it", "calendar", i+1, "updated", sources[i].Updated.Format(time.RFC3339))
+		}
+	}
+	feeds, fetchErr = collectFeeds(fetched, rep)
+	if expandErr != nil {
+		return nil, nil, nil, fetchErr, expandErr
+	}
+	for _, s := range sources {
+		if s.Status == model.SourceStale {
+			rep.stale(s.Calendar, s.Updated)
+		}
+	}
+	for _, f := range feeds {
+		if f.skipped > 0 {
+			slog.Warn("Skipped unreadable events", "calendar", f.calendar, "name", f.raw.Name, "skipped", f.skipped)
+		}
+		n := 0
+		for _, e := range f.expanded {
+			if !e.Start.Before(windowStart) && e.Start.Before(windowEnd) {
+				if o.limits.occurrences > 0 && len(events) == o.limits.occurrences {
+					f.truncate("max-occurrences")
+					break
+				}
+				events = append(events, e)
+				n++
+			}
+		}
+		for _, limit := range f.truncated {
+			slog.Warn("Truncated calendar", "calendar", f.calendar, "name", f.raw.Name, "limit", limit)
+			rep.truncated(f.calendar, limit)
+		}
+		slog.Debug("Expanded calendar", "calendar", f.calendar, "name", f.raw.Name, "occurrences", n)
+		rep.expanded(f.calendar, n, f.skipped)
+		if f.stale.IsZero() {
+			rep.hashed(f.calendar, f.raw.Name, f.sum, f.events)
+		}
+		s := &sources[f.calendar-1]
+		s.Events += n
+		s.Skipped += f.skipped
+		for _, limit := range f.truncated {
+			if !slices.Contains(s.Truncated, limit) {
+				s.Truncated = append(s.Truncated, limit)
+			}
+		}
+	}
+	return feeds, events, sources, fetchErr, nil
+}
+
+// ongoing returns the events of feeds under way now, which the window
+// leaves out since they've started: those that started in the last day
+// and haven't ended.
+func ongoing(feeds []feed) []model.Event {
+	now := clock.Now()
+	var events []model.Event
+	for _, f := range feeds {
+		for _, e := range f.expanded {
+			if !e.Start.Before(now.Add(-24*time.Hour)) && e.Start.Before(now) && e.End.After(now) {
+				events = append(events, e)
+			}
+		}
+	}
+	return events
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackdorland/www/internal/clock"
+	"github.com/jackdorland/www/internal/config"
+)
+
+// envDefault returns the environment variable name, or def if it's unset,
+// so every flag can also be set from the workflow's env block.
+func envDefault(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// registerNow defines -now, which pins the clock the window, recurrences
+// and the output's dateCreated are computed from.
+func registerNow(fs *flag.FlagSet) {
+	set := func(s string) error {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return fmt.Errorf("want an RFC 3339 time like 2024-01-02T15:04:05Z")
+		}
+		clock.Set(t)
+		return nil
+	}
+	if v := os.Getenv("CAL_NOW"); v != "" {
+		if err := set(v); err != nil {
+			fatal("Invalid CAL_NOW", "err", err)
+		}
+	}
+	fs.Func("now", "run as if it were this RFC 3339 time, for reproducible runs and backfills (env CAL_NOW)", set)
+}
+
+// configOptions are the flags choosing the config file and profile.
+type configOptions struct {
+	path    string
+	profile string
+}
+
+func (o *configOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.path, "config", os.Getenv("CAL_CONFIG"), "path to a JSON config file, optionally sealed with encrypt-config (env CAL_CONFIG)")
+	fs.StringVar(&o.profile, "profile", os.Getenv("CAL_PROFILE"), "which of the config's profiles to use, if it has any; the pipeline also takes several, separated by commas, or * for all of them, and publishes each in turn (env CAL_PROFILE)")
+}
+
+// load reads the config, or returns nil if there's none. Errors are coded
+// exitConfig.
+func (o *configOptions) load() (*config.Config, error) {
+	if o.path == "" {
+		if o.profile != "" {
+			return nil, withCode(exitConfig, errors.New("-profile needs -config"))
+		}
+		return nil, nil
+	}
+	if o.profile == "*" || strings.Contains(o.profile, ",") {
+		return nil, withCode(exitConfig, errors.New("-profile names one profile, except for the pipeline"))
+	}
+	cfg, err := config.Load(o.path, o.profile)
+	if err != nil {
+		return nil, withCode(exitConfig, fmt.Errorf("loading config: %w", err))
+	}
+	sentry = cfg.Sentry
+	return cfg, nil
+}
+
+// profiles returns the profiles -profile names: none, one, several
+// separated by commas, or with "*" all of the config's. Errors are coded
+// exitConfig.
+func (o *configOptions) profiles() ([]string, error) {
+	if o.profile != "*" {
+		return strings.Split(o.profile, ","), nil
+	}
+	if o.path == "" {
+		return nil, withCode(exitConfig, errors.New("-profile needs -config"))
+	}
+	names, err := config.Profiles(o.path)
+	if err != nil {
+		return nil, withCode(exitConfig, fmt.Errorf("loading config: %w", err))
+	}
+	if len(names) == 0 {
+		return nil, withCode(exitConfig, errors.New("-profile=*: the config has no profiles"))
+	}
+	return names, nil
+}
+
+// parseWindow parses a window length: a Go duration ("36h") or a whole
+// number of days ("7d").
+func parseWindow(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid window %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window %q", s)
+	}
+	return d, nil
+}
+
+// durationFlag defines a duration flag whose default can be overridden by
+// the environment variable env.
+func durationFlag(fs *flag.FlagSet, name, env string, def time.Duration, usage string) *time.Duration {
+	p := new(time.Duration)
+	durationVarFlag(fs, p, name, env, def, usage)
+	return p
+}
+
+// durationVarFlag is durationFlag storing the duration in p.
+func durationVarFlag(fs *flag.FlagSet, p *time.Duration, name, env string, def time.Duration, usage string) {
+	if v := os.Getenv(env); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			fatal("Invalid "+env, "err", err)
+		}
+		def = d
+	}
+	fs.DurationVar(p, name, def, usage+" (env "+env+")")
+}
+
+// intVarFlag defines an int flag stored in p, whose default can be
+// overridden by the environment variable env.
+func intVarFlag(fs *flag.FlagSet, p *int, name, env string, def int, usage string) {
+	if v := os.Getenv(env); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			fatal("Invalid "+env, "err", err)
+		}
+		def = n
+	}
+	fs.IntVar(p, name, def, usage+" (env "+env+")")
+}
+
+// parseSize parses a size in bytes, optionally with a K, M or G suffix
+// (powers of 1024).
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	for i, suffix := range []string{"K", "M", "G"} {
+		if rest, ok := strings.CutSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), suffix); ok {
+			s, mult = rest, 1<<(10*(i+1))
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,76 @@
+//go:build !js
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jackdorland/www/internal/clock"
+	"github.com/jackdorland/www/internal/model"
+	"github.com/jackdorland/www/internal/store"
+)
+
+// runHistory implements the history command: print the events in -store
+// that start between -from and -to as calendar JSON, like render's,
+// including those long gone from the feeds and the window.
+func runHistory(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	path := fs.String("store", os.Getenv("CAL_STORE"), "the database the runs kept events in (env CAL_STORE)")
+	from := fs.String("from", "", "earliest start, as an RFC 3339 time or a YYYY-MM-DD date in -timezone (default 30 days ago)")
+	to := fs.String("to", "", "latest start, not included, in the same form (default now)")
+	timezone := fs.String("timezone", envDefault("CAL_TIMEZONE", "Local"), "IANA time zone for -from and -to dates (env CAL_TIMEZONE)")
+	pretty := fs.Bool("pretty", false, "indent the JSON")
+	registerNow(fs)
+	registerLogging(fs)
+	fs.Parse(args)
+
+	if *path == "" {
+		return withCode(exitConfig, fmt.Errorf("history needs -store"))
+	}
+	loc, err := time.LoadLocation(*timezone)
+	if err != nil {
+		return withCode(exitConfig, fmt.Errorf("invalid timezone: %w", err))
+	}
+	end := clock.Now()
+	start := end.AddDate(0, 0, -30)
+	if *from != "" {
+		if start, err = parseAPITime(*from, loc); err != nil {
+			return withCode(exitConfig, fmt.Errorf("from: %w", err))
+		}
+	}
+	if *to != "" {
+		if end, err = parseAPITime(*to, loc); err != nil {
+			return withCode(exitConfig, fmt.Errorf("to: %w", err))
+		}
+	}
+
+	st, err := store.OpenReadOnly(*path)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	events, err := st.Events(start, end)
+	if err != nil {
+		return err
+	}
+
+	cal := model.Calendar{Events: events}
+	if cal.Events == nil {
+		cal.Events = []model.Event{}
+	}
+	var data []byte
+	if *pretty {
+		data, err = json.MarshalIndent(cal, "", "  ")
+	} else {
+		data, err = json.Marshal(cal)
+	}
+	if err != nil {
+		return fmt.Errorf("marshalling calendar: %w", err)
+	}
+	_, err = os.Stdout.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"filippo.io/age"
+
+	"github.com/jackdorland/www/internal/crypto"
+)
+
+// runKeygen implements the keygen command: print fresh keys as the
+// environment assignments the tool reads, after checking the keys already
+// configured.
+func runKeygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	kind := fs.String("type", "aes256", "key to generate: aes128, aes256, mac, box, hpke, age or sign")
+	var conf configOptions
+	conf.register(fs)
+	registerLogging(fs)
+	fs.Parse(args)
+
+	cfg, err := conf.load()
+	if err != nil {
+		return err
+	}
+	for _, w := range crypto.KeyWarnings(cfg.Crypto()) {
+		slog.Warn(w)
+	}
+
+	switch *kind {
+	case "aes128", "aes256":
+		n := 16
+		if *kind == "aes256" {
+			n = 32
+		}
+		fmt.Printf("CAL_KEY=%s\n", randomHex(n))
+
+	case "mac":
+		fmt.Printf("CAL_MAC_KEY=%s\n", randomHex(32))
+
+	case "box":
+		sk, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("CAL_BOX_PUBLIC_KEY=%x\n", sk.PublicKey().Bytes())
+		fmt.Printf("CAL_BOX_PRIVATE_KEY=%x\n", sk.Bytes())
+
+	case "hpke":
+		sk, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("CAL_HPKE_RECIPIENTS=%x\n", sk.PublicKey().Bytes())
+		fmt.Printf("CAL_HPKE_PRIVATE_KEY=%x\n", sk.Bytes())
+
+	case "sign":
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("CAL_SIGNING_KEY=%x\n", priv.Seed())
+		fmt.Printf("CAL_SIGNING_PUBLIC_KEY=%x\n", pub)
+
+	case "age":
+		id, err := age.GenerateX25519Identity()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("CAL_AGE_RECIPIENTS=%s\n", id.Recipient())
+		fmt.Printf("CAL_AGE_IDENTITY=%s\n", id)
+
+	default:
+		return withCode(exitConfig, fmt.Errorf("unknown key type %q", *kind))
+	}
+	return nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b) // never fails since Go 1.24
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackdorland/www/internal/locale"
+)
+
+// The -locale flags, applied together by setLocale whichever order they're
+// given in.
+var localeFlags struct {
+	tag, clock, weekStart string
+}
+
+// registerLocale defines -locale, -clock and -week-start, which set the
+// language, clock and week of status.txt, -summary, the timeline and week
+// images, the widget and output templates' date and clock functions.
+func registerLocale(fs *flag.FlagSet) {
+	flags := []struct {
+		name, env, usage string
+		p                *string
+	}{
+		{"locale", "CAL_LOCALE", "write dates and times for this BCP 47 locale, like fr or en-US: en, fr, de or es, with the region's clock and week (default English, 24-hour, from Monday)", &localeFlags.tag},
+		{"clock", "CAL_CLOCK", "12 or 24, to override -locale's clock", &localeFlags.clock},
+		{"week-start", "CAL_WEEK_START", "the day weeks start on, like sunday, to override -locale's", &localeFlags.weekStart},
+	}
+	for _, f := range flags {
+		*f.p = envDefault(f.env, *f.p)
+		if err := setLocale(); err != nil {
+			fatal("Invalid "+f.env, "err", err)
+		}
+		fs.Func(f.name, f.usage+" (env "+f.env+")", func(s string) error {
+			*f.p = s
+			return setLocale()
+		})
+	}
+}
+
+// setLocale sets the locale from localeFlags.
+func setLocale() error {
+	l := *locale.Default
+	if localeFlags.tag != "" {
+		parsed, err := locale.Parse(localeFlags.tag)
+		if err != nil {
+			return err
+		}
+		l = *parsed
+	}
+	switch localeFlags.clock {
+	case "":
+	case "12", "24":
+		l.Hour12 = localeFlags.clock == "12"
+	default:
+		return fmt.Errorf("invalid clock %q: use 12 or 24", localeFlags.clock)
+	}
+	if localeFlags.weekStart != "" {
+		day, ok := weekday(localeFlags.weekStart)
+		if !ok {
+			return fmt.Errorf("invalid week start %q: want a day like monday", localeFlags.weekStart)
+		}
+		l.FirstDay = day
+	}
+	if l == *locale.Default {
+		locale.Set(locale.Default)
+	} else {
+		locale.Set(&l)
+	}
+	return nil
+}
+
+// weekday parses an English day name, in any case.
+func weekday(s string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(s, d.String()) {
+			return d, true
+		}
+	}
+	return 0, false
+}
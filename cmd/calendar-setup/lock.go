@@ -0,0 +1,76 @@
+//go:build !js
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// lockOptions are the flags for the lock that keeps overlapping runs, say
+// from cron, from interleaving their writes.
+type lockOptions struct {
+	path string
+	wait time.Duration
+}
+
+func (o *lockOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.path, "lock", os.Getenv("CAL_LOCK"), "hold a lock on this file while running, so overlapping runs can't interleave (env CAL_LOCK)")
+	fs.DurationVar(&o.wait, "lock-wait", 0, "how long to wait for another run to release -lock before giving up")
+}
+
+// acquire takes the lock, waiting up to -lock-wait for another run to
+// release it, and returns the function that releases it. It does nothing
+// without -lock. The lock is an flock, so a run that crashes can't leave
+// it held; the file records the holder's PID for the error message.
+func (o *lockOptions) acquire(ctx context.Context) (release func(), err error) {
+	if o.path == "" {
+		return func() {}, nil
+	}
+	f, err := os.OpenFile(o.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock: %w", err)
+	}
+	deadline := time.Now().Add(o.wait)
+	for waiting := false; ; waiting = true {
+		ok, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("locking %s: %w", o.path, err)
+		}
+		if ok {
+			break
+		}
+
+		holder := "another run"
+		if pid, _ := os.ReadFile(o.path); len(bytes.TrimSpace(pid)) > 0 {
+			holder += " (pid " + string(bytes.TrimSpace(pid)) + ")"
+		}
+		if !time.Now().Before(deadline) {
+			f.Close()
+			return nil, withCode(exitLocked, fmt.Errorf("%s holds %s", holder, o.path))
+		}
+		if !waiting {
+			slog.Info("Waiting for "+holder+" to finish", "lock", o.path, "wait", o.wait.String())
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return func() {
+		f.Truncate(0)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,12 @@
+//go:build !unix && !js
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func tryLock(f *os.File) (bool, error) {
+	return false, errors.New("-lock needs flock, which this system doesn't have")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedLayout is the suffix of a rotated log's name, when it was
+// rotated, which sorts oldest first.
+const rotatedLayout = "20060102T150405.000"
+
+// logRotation is how the -log-file is rotated. The writer reads it as it
+// writes, so the flags can come before or after -log-file.
+var logRotation struct {
+	maxSize int64
+	keep    int
+	maxAge  *time.Duration
+}
+
+// registerLogFile defines -log-file and how it's rotated: once it would
+// grow past -log-max-size it's renamed <path>.<time> and a new one
+// started, and of the rotated logs only the newest -log-keep, and none
+// older than -log-max-age, are kept. A daemon's log can then only take up
+// so much of a small disk.
+func registerLogFile(fs *flag.FlagSet) {
+	logRotation.maxSize = 10 << 20
+	if v := os.Getenv("CAL_LOG_MAX_SIZE"); v != "" {
+		n, err := parseSize(v)
+		if err != nil {
+			fatal("Invalid CAL_LOG_MAX_SIZE", "err", err)
+		}
+		logRotation.maxSize = n
+	}
+	fs.Func("log-max-size", "rotate the -log-file once it would grow past this size, like 10M (0 never to rotate it; env CAL_LOG_MAX_SIZE, default 10M)", func(s string) (err error) {
+		logRotation.maxSize, err = parseSize(s)
+		return err
+	})
+	intVarFlag(fs, &logRotation.keep, "log-keep", "CAL_LOG_KEEP", 5, "how many rotated -log-files to keep")
+	logRotation.maxAge = durationFlag(fs, "log-max-age", "CAL_LOG_MAX_AGE", 0, "delete rotated -log-files older than this, like 720h, when the log is next rotated (0 to keep them until -log-keep)")
+
+	fs.Func("log-file", "write logs to this file instead of stderr, rotating it by -log-max-size (env CAL_LOG_FILE)", setLogFile)
+	if path := os.Getenv("CAL_LOG_FILE"); path != "" {
+		if err := setLogFile(path); err != nil {
+			fatal("Invalid CAL_LOG_FILE", "err", err)
+		}
+	}
+}
+
+// setLogFile sends logs to path.
+func setLogFile(path string) error {
+	l := &rotatingLog{path: path}
+	if err := l.open(); err != nil {
+		return err
+	}
+	if old, ok := logOutput.(*rotatingLog); ok {
+		old.close()
+	}
+	logOutput = l
+	setLogger()
+	return nil
+}
+
+// rotatingLog is a -log-file, rotated as logRotation says.
+type rotatingLog struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// open opens the log to append to it.
+func (l *rotatingLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if max := logRotation.maxSize; max > 0 && l.size > 0 && l.size+int64(len(p)) > max {
+		// a log that can't be rotated is still written to, past the limit
+		if err := l.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "rotating %s: %v\n", l.path, err)
+		}
+	}
+	if l.f == nil {
+		return os.Stderr.Write(p)
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate renames the log, starts a new one and deletes the rotated logs
+// past logRotation's retention.
+func (l *rotatingLog) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	l.f = nil
+	renameErr := os.Rename(l.path, l.path+"."+time.Now().UTC().Format(rotatedLayout))
+	if err := l.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	l.prune()
+	return nil
+}
+
+// prune deletes the rotated logs past logRotation's retention.
+func (l *rotatingLog) prune() {
+	matches, _ := filepath.Glob(l.path + ".*")
+	type rotated struct {
+		path string
+		at   time.Time
+	}
+	var logs []rotated
+	for _, path := range matches {
+		if at, err := time.Parse(rotatedLayout, strings.TrimPrefix(path, l.path+".")); err == nil {
+			logs = append(logs, rotated{path, at})
+		}
+	}
+	// newest first
+	slices.SortFunc(logs, func(a, b rotated) int { return b.at.Compare(a.at) })
+	for i, r := range logs {
+		if i >= logRotation.keep || (*logRotation.maxAge > 0 && time.Since(r.at) > *logRotation.maxAge) {
+			if err := os.Remove(r.path); err != nil {
+				fmt.Fprintf(os.Stderr, "deleting %s: %v\n", r.path, err)
+			}
+		}
+	}
+}
+
+// close closes the log, once another has replaced it.
+func (l *rotatingLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		l.f.Close()
+		l.f = nil
+	}
+}
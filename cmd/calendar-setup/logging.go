@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// logLevel is shared by every handler, so -log-level and -log-format can
+// be given in either order.
+var logLevel slog.LevelVar
+
+// logFormat and logOutput are the -log-format and -log-file handler's, so
+// those can be given in either order too.
+var (
+	logFormat           = "text"
+	logOutput io.Writer = os.Stderr
+)
+
+// registerLogging adds -log-level, -log-format and the -log-file flags to
+// fs, so every command takes them. Logs go to stderr unless -log-file is
+// given; stdout is kept for command output such as render's JSON.
+func registerLogging(fs *flag.FlagSet) {
+	if err := logLevel.UnmarshalText([]byte(envDefault("CAL_LOG_LEVEL", "info"))); err != nil {
+		fatal("Invalid CAL_LOG_LEVEL", "err", err)
+	}
+	fs.TextVar(&logLevel, "log-level", &logLevel, "debug, info, warn or error (env CAL_LOG_LEVEL)")
+
+	format := envDefault("CAL_LOG_FORMAT", "text")
+	if err := setLogFormat(format); err != nil {
+		fatal("Invalid CAL_LOG_FORMAT", "err", err)
+	}
+	fs.Func("log-format", "text or json (env CAL_LOG_FORMAT, default "+format+")", setLogFormat)
+	registerLogFile(fs)
+}
+
+func setLogFormat(format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown log format %q: use text or json", format)
+	}
+	logFormat = format
+	setLogger()
+	return nil
+}
+
+// setLogger makes the default logger write logFormat to logOutput.
+func setLogger() {
+	opts := &slog.HandlerOptions{Level: &logLevel}
+	if logFormat == "json" {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(logOutput, opts)))
+	} else {
+		slog.SetDefault(slog.New(slog.NewTextHandler(logOutput, opts)))
+	}
+}
+
+// fatal logs msg at error level and exits with exitConfig; it's only for
+// errors found before a command runs.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(exitConfig)
+}
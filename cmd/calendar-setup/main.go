@@ -0,0 +1,306 @@
+//go:build !js
+
+// Command calendar-setup fetches the configured iCalendar feeds, keeps the
+// next week of events and publishes them encrypted to docs/cal.aes.
+//
+// With no subcommand it runs the whole pipeline. Each stage can also be run
+// on its own:
+//
+//	fetch     download the feeds and save them as .ics files
+//	render    expand feeds (live or saved) into the calendar JSON
+//	encrypt   encrypt calendar JSON and write the outputs
+//	decrypt   decrypt an output file and print the JSON
+//	serve     generate the outputs in memory and serve them over HTTP
+//	history   print past events kept in -store
+//	selftest  publish a built-in feed and check the results
+//	validate  check the config, keys and feeds and print the effective config
+//	snippet   print a <script> that lists upcoming events on any page
+//	version   print the build's version, commit and date
+//
+// The exit status says what went wrong; see exit.go.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jackdorland/www/internal/clock"
+	"github.com/jackdorland/www/internal/config"
+	"github.com/jackdorland/www/internal/crypto"
+	"github.com/jackdorland/www/internal/model"
+	"github.com/jackdorland/www/internal/schedule"
+)
+
+func main() {
+	defer reportPanics()
+	if len(os.Args) > 1 {
+		commands := map[string]func([]string) error{
+			"fetch":          runFetch,
+			"render":         runRender,
+			"encrypt":        runEncrypt,
+			"decrypt":        runDecrypt,
+			"serve":          runServe,
+			"history":        runHistory,
+			"selftest":       runSelftest,
+			"validate":       runValidate,
+			"keygen":         runKeygen,
+			"encrypt-config": runEncryptConfig,
+			"snippet":        runSnippet,
+			"version":        runVersion,
+		}
+		if run, ok := commands[os.Args[1]]; ok {
+			exit(run(os.Args[2:]))
+			return
+		}
+	}
+
+	var p pipeline
+	p.render.register(flag.CommandLine)
+	p.enc.register(flag.CommandLine)
+	daemon := flag.Bool("daemon", false, "keep running and republish every -interval, rewriting the output only when events change")
+	interval := durationFlag(flag.CommandLine, "interval", "CAL_INTERVAL", 15*time.Minute, "how often -daemon refetches the feeds")
+	cron := flag.String("schedule", os.Getenv("CAL_SCHEDULE"), `cron expression, in -timezone (default local time), for when -daemon refetches the feeds instead of every -interval, like "*/15 7-22 * * *" (env CAL_SCHEDULE)`)
+	timeout := registerTimeout(flag.CommandLine)
+	registerFetchWorkers(flag.CommandLine)
+	p.mon.register(flag.CommandLine)
+	p.lock.register(flag.CommandLine)
+	registerNow(flag.CommandLine)
+	registerPprof(flag.CommandLine)
+	registerTracing(flag.CommandLine)
+	registerLogging(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: calendar-setup [flags]\n       calendar-setup fetch|render|encrypt|decrypt|serve|history|selftest|validate|keygen|encrypt-config|snippet|version [flags]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	p.timeout, p.interval = *timeout, *interval
+	if err := p.mon.load(); err != nil {
+		exit(err)
+	}
+
+	if !*daemon {
+		exit(p.run())
+		return
+	}
+	if *cron != "" {
+		s, err := schedule.Parse(*cron)
+		if err == nil {
+			if p.scheduleLoc, err = time.LoadLocation(p.render.timezone); err != nil {
+				err = fmt.Errorf("invalid timezone: %w", err)
+			}
+		}
+		if err == nil && s.Next(time.Now().In(p.scheduleLoc)).IsZero() {
+			err = fmt.Errorf("schedule %q never fires", *cron)
+		}
+		if err != nil {
+			exit(withCode(exitConfig, err))
+		}
+		p.schedule = s
+	} else if p.interval <= 0 {
+		fatal("Invalid interval", "interval", p.interval.String())
+	}
+	exit(p.runDaemon())
+}
+
+// pipeline is the default command, which runs every stage.
+type pipeline struct {
+	render   renderOptions
+	enc      encryptOptions
+	mon      monitorOptions
+	lock     lockOptions
+	timeout  time.Duration
+	interval time.Duration
+	// schedule, if set, replaces interval. It's read in scheduleLoc, the
+	// -timezone zone.
+	schedule    *schedule.Schedule
+	scheduleLoc *time.Location
+}
+
+// run is the whole pipeline: fetch, render and encrypt, for each profile
+// -profile names. The monitoring files are written whatever the outcome.
+func (p *pipeline) run() error {
+	// check keys before spending time on the network
+	pubs, err := p.publishers()
+	if err != nil {
+		return errors.Join(err, newReport(p.mon, nil).finish(err))
+	}
+	ctx, cancel := commandContext(p.timeout)
+	defer cancel()
+	return p.publishAll(ctx, pubs, false)
+}
+
+// runDaemon runs the pipeline every interval, or at the times in schedule,
+// until SIGINT or SIGTERM, which let a run in progress finish first. Each
+// run is limited to timeout. Failed runs are logged and retried on the next
+// tick; only a bad config stops it.
+func (p *pipeline) runDaemon() error {
+	pubs, err := p.publishers()
+	if err != nil {
+		return errors.Join(err, newReport(p.mon, nil).finish(err))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	// on an interval the first run is straight away; on a schedule it
+	// waits for its time like the rest
+	for first := true; ; first = false {
+		if !first || p.schedule != nil {
+			select {
+			case <-p.wait(ticker):
+			case <-ctx.Done():
+				slog.Info("Shutting down")
+				return nil
+			}
+		}
+
+		// not under ctx: a signal shouldn't interrupt a run
+		runCtx, cancel := withTimeout(context.Background(), p.timeout)
+		err := p.publishAll(runCtx, pubs, true)
+		cancel()
+		if err != nil {
+			logErrors(err)
+		}
+	}
+}
+
+// wait returns a channel that receives when the next run is due.
+func (p *pipeline) wait(ticker *time.Ticker) <-chan time.Time {
+	if p.schedule == nil {
+		slog.Debug("Waiting for next run", "interval", p.interval.String())
+		return ticker.C
+	}
+	next := p.schedule.Next(time.Now().In(p.scheduleLoc))
+	if next.IsZero() {
+		slog.Warn("Schedule never fires again")
+		return nil
+	}
+	slog.Debug("Waiting for next run", "at", next.Format(time.RFC3339))
+	return time.After(time.Until(next))
+}
+
+// publish fetches, renders and writes the calendar. If last is non-nil it
+// holds the hash of the events last written, and the calendar is only
+// rewritten when they change; the outputs that depend on the time are
+// rewritten every run. The run is recorded in rep.
+func publish(ctx context.Context, render *renderOptions, enc *encryptOptions, cfg *config.Config, last *[sha256.Size]byte, rep *runReport) error {
+	feeds, events, sources, fetchErr, err := render.fetchEvents(ctx, feedSpecs(cfg), rep)
+	if err != nil {
+		return errors.Join(err, fetchErr)
+	}
+	if len(feeds) == 0 {
+		return fetchErr
+	}
+	if enc.status || enc.availability > 0 || enc.freeSlots > 0 || enc.booking.Length > 0 || enc.summary {
+		enc.underway = ongoing(feeds)
+	}
+	_, enc.windowEnd, enc.loc, _ = render.bounds()
+	enc.sources = sources
+	sum, err := eventsHash(events, sources)
+	if err != nil {
+		return err
+	}
+	if rep != nil {
+		rep.Events = len(events)
+		rep.EventsSHA256 = hex.EncodeToString(sum[:])
+		rep.events = events
+	}
+
+	if last != nil && sum == *last {
+		slog.Info("Events unchanged; not rewriting the calendar", "events", len(events))
+		if rep != nil {
+			rep.Unchanged = true
+		}
+		// but the time has moved on
+		return errors.Join(enc.writeClock(ctx, cfg, events, rep), fetchErr)
+	}
+
+	// a write failure outranks a partial fetch failure
+	err = enc.write(ctx, cfg, events, rep)
+	if err == nil && last != nil {
+		*last = sum
+	}
+	return errors.Join(err, fetchErr)
+}
+
+// eventsHash identifies a set of events, and the state of the calendars
+// they came from, so a calendar going stale is published too. The
+// ciphertext differs every run, so it's what decides whether the output
+// has changed.
+func eventsHash(events []model.Event, sources []model.Source) ([sha256.Size]byte, error) {
+	data, err := json.Marshal(events)
+	if err != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("marshalling calendar: %w", err)
+	}
+	defer crypto.Wipe(data)
+	h := sha256.New()
+	h.Write(data)
+	if len(sources) > 0 {
+		data, err := json.Marshal(sources)
+		if err != nil {
+			return [sha256.Size]byte{}, fmt.Errorf("marshalling calendar: %w", err)
+		}
+		h.Write(data)
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum, nil
+}
+
+// renderOptions are the flags controlling which events are published.
+type renderOptions struct {
+	window   string
+	timezone string
+	limits   limits
+	// store is the -store database, opened for each run.
+	store string
+	// profile is the config profile being published, when the pipeline
+	// publishes several.
+	profile string
+}
+
+func (o *renderOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.window, "window", envDefault("CAL_WINDOW", "7d"), "how far ahead to publish events, as days (7d) or a duration (36h) (env CAL_WINDOW)")
+	fs.StringVar(&o.timezone, "timezone", envDefault("CAL_TIMEZONE", "Local"), "IANA time zone for floating event times (env CAL_TIMEZONE)")
+	if v := os.Getenv("CAL_MAX_FEED_SIZE"); v != "" {
+		n, err := parseSize(v)
+		if err != nil {
+			fatal("Invalid CAL_MAX_FEED_SIZE", "err", err)
+		}
+		o.limits.feedSize = n
+	}
+	fs.Func("max-feed-size", "read no more than this much of each feed, like 50M; the rest is dropped (env CAL_MAX_FEED_SIZE)", func(s string) (err error) {
+		o.limits.feedSize, err = parseSize(s)
+		return err
+	})
+	fs.StringVar(&o.store, "store", os.Getenv("CAL_STORE"), "keep every feed's events in this database, so unchanged feeds aren't reparsed by later runs and past events can be listed with the history command (env CAL_STORE)")
+	intVarFlag(fs, &o.limits.events, "max-events", "CAL_MAX_EVENTS", 0, "keep no more than this many events from each feed, counting only those near the window (0 for no limit)")
+	intVarFlag(fs, &o.limits.occurrences, "max-occurrences", "CAL_MAX_OCCURRENCES", 0, "expand no more than this many occurrences of each feed, counting from the day before the window, and publish no more than this many in all (0 for no limit)")
+}
+
+// bounds returns the publishing window, from now, and the time zone for
+// floating times.
+func (o *renderOptions) bounds() (start, end time.Time, loc *time.Location, err error) {
+	window, err := parseWindow(o.window)
+	if err != nil {
+		return start, end, nil, withCode(exitConfig, err)
+	}
+	loc, err = time.LoadLocation(o.timezone)
+	if err != nil {
+		return start, end, nil, withCode(exitConfig, fmt.Errorf("invalid timezone: %w", err))
+	}
+	start = clock.Now()
+	return start, start.Add(window), loc, nil
+}
@@ -0,0 +1,120 @@
+//go:build !js
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackdorland/www/internal/clock"
+	"github.com/jackdorland/www/internal/config"
+	"github.com/jackdorland/www/internal/crypto"
+	"github.com/jackdorland/www/internal/output"
+)
+
+const quietFeed = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//test//EN
+BEGIN:VEVENT
+UID:standup@test
+DTSTAMP:20261001T000000Z
+DTSTART:20261015T090000Z
+DTEND:20261015T100000Z
+SUMMARY:Standup
+END:VEVENT
+BEGIN:VEVENT
+UID:lunch@test
+DTSTAMP:20261001T000000Z
+DTSTART:20261015T120000Z
+DTEND:20261015T130000Z
+SUMMARY:Lunch
+END:VEVENT
+END:VCALENDAR
+`
+
+// With -daemon a quiet feed's events hash stays the same from run to run,
+// but status.json must still move on once the meeting under way ends.
+func TestPublishRefreshesStatusWhenUnchanged(t *testing.T) {
+	for _, v := range []string{"CAL_KEY", "CAL_SIGNING_KEY"} {
+		t.Setenv(v, "")
+	}
+	t.Cleanup(func() { clock.Set(time.Time{}) })
+	dir := t.TempDir()
+	feed := filepath.Join(dir, "feed.ics")
+	if err := os.WriteFile(feed, []byte(strings.ReplaceAll(quietFeed, "\n", "\r\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Config: crypto.Config{CurrentKey: "k", Keys: []crypto.KeyConfig{{ID: "k", Key: strings.Repeat("01", 32)}}},
+		Feeds:  []string{feed},
+	}
+	var render renderOptions
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	render.register(fs)
+	if err := fs.Parse([]string{"-timezone", "UTC"}); err != nil {
+		t.Fatal(err)
+	}
+	enc := newTestEncrypt(t)
+	enc.status = true
+	enc.output = filepath.Join(dir, "cal.aes")
+
+	var last [sha256.Size]byte
+	run := func(now string) (output.Status, *runReport) {
+		t.Helper()
+		clock.Set(mustTime(t, now))
+		rep := newReport(monitorOptions{}, nil)
+		if err := publish(context.Background(), &render, enc, cfg, &last, rep); err != nil {
+			t.Fatalf("at %s: %v", now, err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "status.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var status output.Status
+		if err := json.Unmarshal(data, &status); err != nil {
+			t.Fatal(err)
+		}
+		return status, rep
+	}
+
+	if status, _ := run("2026-10-15T09:30:00Z"); !status.Busy {
+		t.Fatalf("during the standup, status is %+v, want busy", status)
+	}
+	status, rep := run("2026-10-15T10:30:00Z")
+	if !rep.Unchanged {
+		t.Fatal("the events changed; the test needs them not to")
+	}
+	if status.Busy || status.State != "free" {
+		t.Errorf("after the standup, status is %+v, want free", status)
+	}
+	if want := mustTime(t, "2026-10-15T10:30:00Z"); !status.Generated.Equal(want) {
+		t.Errorf("status generated at %s, want %s", status.Generated, want)
+	}
+}
+
+func writeJSON(t *testing.T, path string, v any) {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	tm, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tm
+}
@@ -0,0 +1,101 @@
+//go:build !js
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lastSuccessMetric is the one metric carried from one -metrics file to
+// the next.
+const lastSuccessMetric = "calendar_last_success_timestamp_seconds"
+
+// writeMetrics writes rep in the Prometheus text format. lastSuccess is
+// when the last run without errors finished, or zero if none has. A
+// profile's metrics are labelled with it, so that the files of several
+// can be collected together.
+func writeMetrics(w io.Writer, rep *runReport, lastSuccess time.Time) {
+	gauge := func(name, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	labels := func(pairs ...string) string {
+		if rep.Profile != "" {
+			pairs = append([]string{"profile", rep.Profile}, pairs...)
+		}
+		if len(pairs) == 0 {
+			return ""
+		}
+		var b strings.Builder
+		for i := 0; i < len(pairs); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "%s=%s", pairs[i], strconv.Quote(pairs[i+1]))
+		}
+		return "{" + b.String() + "}"
+	}
+
+	gauge("calendar_run_timestamp_seconds", "When the last run started.")
+	fmt.Fprintf(w, "calendar_run_timestamp_seconds%s %d\n", labels(), rep.Started.Unix())
+	gauge("calendar_run_duration_seconds", "How long the last run took.")
+	fmt.Fprintf(w, "calendar_run_duration_seconds%s %g\n", labels(), rep.Seconds)
+	gauge("calendar_run_exit_code", "The exit code of the last run; 0 is success.")
+	fmt.Fprintf(w, "calendar_run_exit_code%s %d\n", labels(), rep.ExitCode)
+	gauge(lastSuccessMetric, "When the last run without errors finished.")
+	if !lastSuccess.IsZero() {
+		fmt.Fprintf(w, "%s%s %d\n", lastSuccessMetric, labels(), lastSuccess.Unix())
+	}
+	gauge("calendar_events_published", "Events published by the last run.")
+	fmt.Fprintf(w, "calendar_events_published%s %d\n", labels(), rep.Events)
+
+	perCalendar := func(name, help string, value func(calendarReport) float64) {
+		gauge(name, help)
+		for _, c := range rep.Calendars {
+			fmt.Fprintf(w, "%s%s %g\n", name, labels("calendar", strconv.Itoa(c.Calendar)), value(c))
+		}
+	}
+	perCalendar("calendar_fetch_duration_seconds", "How long fetching the feed took.", func(c calendarReport) float64 { return c.Seconds })
+	perCalendar("calendar_fetch_success", "Whether the feed was fetched and parsed.", func(c calendarReport) float64 {
+		if c.Error != "" {
+			return 0
+		}
+		return 1
+	})
+	perCalendar("calendar_feed_events", "Events parsed from the feed.", func(c calendarReport) float64 { return float64(c.Events) })
+	perCalendar("calendar_feed_events_skipped", "Events in the feed that couldn't be read.", func(c calendarReport) float64 { return float64(c.Skipped) })
+	perCalendar("calendar_feed_occurrences", "Occurrences of the feed's events inside the window.", func(c calendarReport) float64 { return float64(c.Occurrences) })
+
+	gauge("calendar_output_bytes", "Size of each published output.")
+	for _, o := range rep.Outputs {
+		fmt.Fprintf(w, "calendar_output_bytes%s %d\n", labels("path", o.Path), o.Bytes)
+	}
+}
+
+// readLastSuccess reads the last success from a metrics file written by
+// writeMetrics, or returns zero if there's none.
+func readLastSuccess(path string) time.Time {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// the metric, maybe with labels, then its value
+		line := sc.Text()
+		rest, ok := strings.CutPrefix(line, lastSuccessMetric)
+		if !ok || !(strings.HasPrefix(rest, " ") || strings.HasPrefix(rest, "{")) {
+			continue
+		}
+		if sec, err := strconv.ParseInt(line[strings.LastIndexByte(line, ' ')+1:], 10, 64); err == nil {
+			return time.Unix(sec, 0)
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,85 @@
+//go:build !js
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+
+	"github.com/jackdorland/www/internal/timing"
+)
+
+// registerPprof defines -pprof, which profiles the command from when its
+// flags are parsed until it exits and writes into a directory:
+//
+//	cpu.pprof    the CPU profile
+//	heap.pprof   the heap profile at exit
+//	stages.txt   the time spent in each stage (see timing.Write)
+//
+// for "go tool pprof". (-profile picks a config profile.)
+func registerPprof(fs *flag.FlagSet) {
+	fs.Func("pprof", "write CPU and heap profiles and a breakdown of the time spent in each stage to this directory on exit (env CAL_PPROF)", startProfiling)
+	if dir := os.Getenv("CAL_PPROF"); dir != "" {
+		if err := startProfiling(dir); err != nil {
+			fatal("Invalid CAL_PPROF", "err", err)
+		}
+	}
+}
+
+// startProfiling starts profiling into dir, replacing any profiling
+// started before.
+func startProfiling(dir string) error {
+	stopProfiling()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	cpu, err := os.Create(filepath.Join(dir, "cpu.pprof"))
+	if err != nil {
+		return err
+	}
+	if err := pprof.StartCPUProfile(cpu); err != nil {
+		cpu.Close()
+		return err
+	}
+	timing.Enable()
+
+	stopProfiling = func() {
+		stopProfiling = func() {}
+		pprof.StopCPUProfile()
+		cpu.Close()
+		err := writeProfile(filepath.Join(dir, "heap.pprof"), func(f *os.File) error {
+			// up to date as of the last GC
+			runtime.GC()
+			return pprof.WriteHeapProfile(f)
+		})
+		if err == nil {
+			err = writeProfile(filepath.Join(dir, "stages.txt"), func(f *os.File) error {
+				return timing.Write(f)
+			})
+		}
+		if err != nil {
+			slog.Error("Couldn't write the profiles", "dir", dir, "err", err)
+			return
+		}
+		slog.Info("Wrote profiles", "dir", dir)
+	}
+	return nil
+}
+
+// writeProfile creates path and has write fill it.
+func writeProfile(path string, write func(*os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,149 @@
+//go:build !js
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackdorland/www/internal/config"
+	"github.com/jackdorland/www/internal/output"
+)
+
+// publisher is the pipeline for one profile: its config, and the options
+// as they apply to it.
+type publisher struct {
+	profile string
+	render  renderOptions
+	enc     encryptOptions
+	mon     monitorOptions
+	cfg     *config.Config
+	// last is the hash of the events -daemon last wrote.
+	last [sha256.Size]byte
+}
+
+// publishers loads the config of each profile -profile names. With more
+// than one, each has its own -store and monitoring files, named for it
+// (see profilePath), so no profile's feeds, state or reports mix with
+// another's, and none may publish to another's outputs. Errors are coded
+// exitConfig.
+func (p *pipeline) publishers() ([]*publisher, error) {
+	names, err := p.enc.conf.profiles()
+	if err != nil {
+		return nil, err
+	}
+	var pubs []*publisher
+	for _, name := range names {
+		pub := &publisher{render: p.render, enc: p.enc, mon: p.mon}
+		pub.enc.conf.profile = name
+		if len(names) > 1 {
+			pub.profile = name
+			pub.render.profile = name
+			pub.render.store = profilePath(p.render.store, name)
+			pub.mon = p.mon.forProfile(name)
+		}
+		cfg, err := pub.enc.load()
+		if err == nil {
+			err = pub.mon.check(cfg)
+		}
+		if err != nil {
+			return nil, inProfile(pub.profile, err)
+		}
+		pub.cfg = cfg
+		pubs = append(pubs, pub)
+	}
+	return pubs, checkOutputs(pubs)
+}
+
+// checkOutputs checks no two profiles publish a calendar to the same place.
+// Errors are coded exitConfig.
+func checkOutputs(pubs []*publisher) error {
+	seen := make(map[string]string)
+	for _, pub := range pubs {
+		paths := []string{pub.enc.output}
+		if pub.cfg != nil && len(pub.cfg.Tiers) > 0 {
+			paths = paths[:0]
+			for _, tier := range pub.cfg.Tiers {
+				paths = append(paths, output.TierPath(tier))
+			}
+		}
+		for _, path := range paths {
+			key := pub.enc.sink + " " + filepath.Clean(path)
+			if other, ok := seen[key]; ok {
+				return withCode(exitConfig, fmt.Errorf("profiles %s and %s both publish %s; give each its own output", other, pub.profile, path))
+			}
+			seen[key] = pub.profile
+		}
+	}
+	return nil
+}
+
+// publishAll publishes each profile in turn, holding -lock, recording each
+// in its own report. With daemon set the outputs are only rewritten when a
+// profile's events change.
+func (p *pipeline) publishAll(ctx context.Context, pubs []*publisher, daemon bool) error {
+	release, lockErr := p.lock.acquire(ctx)
+	if lockErr == nil {
+		defer release()
+	}
+	var errs []error
+	for _, pub := range pubs {
+		rep := newReport(pub.mon, pub.cfg)
+		rep.Profile = pub.profile
+		err := lockErr
+		if err == nil {
+			if pub.profile != "" {
+				slog.Info("Publishing profile", "profile", pub.profile)
+			}
+			if pub.cfg != nil {
+				sentry = pub.cfg.Sentry
+			}
+			var last *[sha256.Size]byte
+			if daemon {
+				last = &pub.last
+			}
+			err = traced(ctx, "publish", func(ctx context.Context) error {
+				return publish(ctx, &pub.render, &pub.enc, pub.cfg, last, rep)
+			})
+		}
+		errs = append(errs, inProfile(pub.profile, errors.Join(err, rep.finish(err))))
+	}
+	return errors.Join(errs...)
+}
+
+// inProfile prefixes each error joined into err with profile, if it's set,
+// keeping err's exit code.
+func inProfile(profile string, err error) error {
+	if err == nil || profile == "" {
+		return err
+	}
+	var errs []error
+	for _, e := range flatten(err) {
+		errs = append(errs, fmt.Errorf("profile %s: %w", profile, e))
+	}
+	return withCode(exitCode(err), errors.Join(errs...))
+}
+
+// profilePath is path with profile added before its extension, like
+// report.alice.json, or "" if path is.
+func profilePath(path, profile string) string {
+	if path == "" {
+		return ""
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + profile + ext
+}
+
+// forProfile returns o with its files named for profile (see profilePath).
+// The webhook is shared; the report posted to it names the profile.
+func (o monitorOptions) forProfile(profile string) monitorOptions {
+	for _, path := range []*string{&o.report, &o.metrics, &o.heartbeat, &o.notifyState, &o.feedState} {
+		*path = profilePath(*path, profile)
+	}
+	return o
+}
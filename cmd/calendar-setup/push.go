@@ -0,0 +1,125 @@
+//go:build !js
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jackdorland/www/internal/websocket"
+)
+
+// update tells a subscriber the outputs have changed, so an open page can
+// refetch them instead of polling.
+type update struct {
+	// SHA256 is the events' hash, as in the report.
+	SHA256    string    `json:"sha256"`
+	Events    int       `json:"events"`
+	Generated time.Time `json:"generated"`
+}
+
+// subscribe returns a channel of updates, starting with the current state,
+// and a function to stop them. A subscriber that falls behind only gets
+// the latest update.
+func (s *server) subscribe() (<-chan update, func()) {
+	ch := make(chan update, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[chan update]struct{})
+	}
+	s.subscribers[ch] = struct{}{}
+	if s.report != nil {
+		ch <- update{SHA256: s.report.EventsSHA256, Events: len(s.events), Generated: s.lastGenerated}
+	}
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.subscribers, ch)
+		s.mu.Unlock()
+	}
+}
+
+// broadcast sends u to every subscriber. s.mu must be held.
+func (s *server) broadcast(u update) {
+	s.lastGenerated = u.Generated
+	for ch := range s.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- u
+	}
+}
+
+// serveWebSocket serves /ws: a WebSocket that's sent each update as JSON.
+func (s *server) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		slog.Debug("WebSocket handshake failed", "err", err)
+		return
+	}
+	defer conn.Close()
+	updates, unsubscribe := s.subscribe()
+	defer unsubscribe()
+
+	closed := make(chan error, 1)
+	go func() { closed <- conn.Read() }()
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case u := <-updates:
+			msg, _ := json.Marshal(u)
+			if err := conn.WriteText(msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.Ping(); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// serveEvents serves /events: a Server-Sent Events stream of updates,
+// each an "update" event with the JSON as data and the hash as its ID.
+func (s *server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// tell nginx not to buffer the stream
+	h.Set("X-Accel-Buffering", "no")
+	updates, unsubscribe := s.subscribe()
+	defer unsubscribe()
+
+	fmt.Fprint(w, "retry: 10000\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case u := <-updates:
+			msg, _ := json.Marshal(u)
+			fmt.Fprintf(w, "event: update\nid: %s\ndata: %s\n\n", u.SHA256, msg)
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
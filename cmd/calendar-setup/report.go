@@ -0,0 +1,501 @@
+//go:build !js
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jackdorland/www/internal/clock"
+	"github.com/jackdorland/www/internal/config"
+	"github.com/jackdorland/www/internal/model"
+	"github.com/jackdorland/www/internal/notify"
+	"github.com/jackdorland/www/internal/output"
+	"github.com/jackdorland/www/internal/sink"
+)
+
+// monitorOptions are the flags for the files written, and notifications
+// sent, after each run for monitoring.
+type monitorOptions struct {
+	report          string
+	metrics         string
+	heartbeat       string
+	webhook         string
+	webhookTemplate string
+	notifyState     string
+	feedState       string
+	unchangedDays   int
+
+	// webhookPayload is set by load from -webhook-template.
+	webhookPayload *template.Template
+}
+
+func (o *monitorOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.report, "report", os.Getenv("CAL_REPORT"), "after each run, write a JSON report of timings, counts, errors and outputs to this path (env CAL_REPORT)")
+	fs.StringVar(&o.metrics, "metrics", os.Getenv("CAL_METRICS"), "after each run, write Prometheus metrics to this path, for node-exporter's textfile collector (env CAL_METRICS)")
+	fs.StringVar(&o.heartbeat, "heartbeat", os.Getenv("CAL_HEARTBEAT"), "after each successful run, write the time and the events' SHA-256 to this path (env CAL_HEARTBEAT)")
+	fs.StringVar(&o.webhook, "webhook", os.Getenv("CAL_WEBHOOK"), "after each run, POST the JSON report to this URL (env CAL_WEBHOOK)")
+	fs.StringVar(&o.webhookTemplate, "webhook-template", os.Getenv("CAL_WEBHOOK_TEMPLATE"), "text/template file, executed with the report, for a -webhook payload other than the report itself (env CAL_WEBHOOK_TEMPLATE)")
+	fs.StringVar(&o.notifyState, "notify-state", os.Getenv("CAL_NOTIFY_STATE"), "file recording when the config's daily notifiers were last sent (env CAL_NOTIFY_STATE)")
+	fs.StringVar(&o.feedState, "feed-state", os.Getenv("CAL_FEED_STATE"), "file recording each feed's hash, when it last changed and its recent event counts, to warn, and tell the config's \"empty\" notifiers, when a feed that usually has events parses to none (env CAL_FEED_STATE)")
+	intVarFlag(fs, &o.unchangedDays, "unchanged-days", "CAL_UNCHANGED_DAYS", 0, "warn, and tell the config's \"unchanged\" notifiers, when a feed hasn't changed in this many days, which usually means its secret URL was rotated; needs -feed-state (0 never warns)")
+}
+
+// load parses -webhook-template. Errors are coded exitConfig.
+func (o *monitorOptions) load() error {
+	if o.webhookTemplate == "" {
+		return nil
+	}
+	if o.webhook == "" {
+		return withCode(exitConfig, errors.New("-webhook-template needs -webhook"))
+	}
+	var err error
+	o.webhookPayload, err = output.ParseTemplate(o.webhookTemplate)
+	return withCode(exitConfig, err)
+}
+
+// check checks the config's notifiers can be sent. Errors are coded
+// exitConfig.
+func (o *monitorOptions) check(cfg *config.Config) error {
+	for _, n := range configNotifiers(cfg) {
+		if n.When() == notify.OnDaily && o.notifyState == "" {
+			return withCode(exitConfig, errors.New("daily notifiers need -notify-state"))
+		}
+		if n.When() == notify.OnUnchanged && o.unchangedDays <= 0 {
+			return withCode(exitConfig, errors.New("unchanged notifiers need -unchanged-days"))
+		}
+		if n.When() == notify.OnEmpty && o.feedState == "" {
+			return withCode(exitConfig, errors.New("empty notifiers need -feed-state"))
+		}
+	}
+	if o.unchangedDays > 0 && o.feedState == "" {
+		return withCode(exitConfig, errors.New("-unchanged-days needs -feed-state"))
+	}
+	return nil
+}
+
+// configNotifiers returns the config's notifiers, if any.
+func configNotifiers(cfg *config.Config) []notify.Config {
+	if cfg == nil {
+		return nil
+	}
+	return cfg.Notify
+}
+
+// runReport is the JSON written to -report after every run, so monitoring
+// can tell success from failure without parsing logs, and the source of
+// the metrics, heartbeat and notifications. The methods do nothing on a
+// nil *runReport, which is what the stage commands pass.
+type runReport struct {
+	// Profile is the config profile published, when the pipeline
+	// publishes several.
+	Profile string `json:"profile,omitempty"`
+	// Status is "ok", "partial" (some feeds failed; the rest were
+	// published) or "failed".
+	Status   string    `json:"status"`
+	Started  time.Time `json:"started"`
+	Seconds  float64   `json:"seconds"`
+	ExitCode int       `json:"exitCode"`
+	Errors   []string  `json:"errors,omitempty"`
+	// Unchanged is set when -daemon found the events unchanged and so
+	// didn't rewrite the outputs.
+	Unchanged bool             `json:"unchanged,omitempty"`
+	Calendars []calendarReport `json:"calendars"`
+	Events    int              `json:"events"`
+	// EventsSHA256 identifies the events published, changed or not.
+	EventsSHA256 string         `json:"eventsSha256,omitempty"`
+	Outputs      []outputReport `json:"outputs,omitempty"`
+	// OpenCircuits are the hosts the run stopped fetching from after
+	// they failed -breaker-threshold times in a row.
+	OpenCircuits []string `json:"openCircuits,omitempty"`
+
+	mon       monitorOptions
+	notifiers []notify.Config
+	// events are those published, for daily agendas.
+	events []model.Event
+	// sums are the hashes of the feeds fetched, newlyUnchanged those just
+	// found unchanged for -unchanged-days, and newlyEmpty those just found
+	// empty when they usually aren't; see checkFeeds.
+	sums           []feedSum
+	newlyUnchanged []feedSum
+	newlyEmpty     []feedSum
+}
+
+type calendarReport struct {
+	// Calendar counts from 1, like CALENDAR_<n>.
+	Calendar int     `json:"calendar"`
+	Seconds  float64 `json:"seconds"`
+	// Events is the number of events in the feed, Occurrences the number
+	// of them (recurrences expanded) inside the window, and Skipped the
+	// number that couldn't be read.
+	Events      int    `json:"events"`
+	Occurrences int    `json:"occurrences"`
+	Skipped     int    `json:"skipped"`
+	Error       string `json:"error,omitempty"`
+	// Truncated lists the limits the feed was cut short by, like
+	// max-events.
+	Truncated []string `json:"truncated,omitempty"`
+	// Stale is set if the calendar failed and the events of the last
+	// run that fetched it, at this time, were published instead.
+	Stale time.Time `json:"stale,omitzero"`
+	// UnchangedSince is set if a feed of the calendar hasn't changed
+	// since then, longer than -unchanged-days.
+	UnchangedSince time.Time `json:"unchangedSince,omitzero"`
+	// Empty is set if a feed of the calendar parsed to no events though
+	// it usually has some, which usually means the provider changed its
+	// format.
+	Empty bool `json:"empty,omitempty"`
+}
+
+type outputReport struct {
+	Path   string `json:"path"`
+	Bytes  int    `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// newReport starts the report for a run, which sends it to the config's
+// notifiers as well as where mon says.
+func newReport(mon monitorOptions, cfg *config.Config) *runReport {
+	return &runReport{Started: time.Now(), Calendars: []calendarReport{}, mon: mon, notifiers: configNotifiers(cfg)}
+}
+
+// fetched records the fetch of one feed spec.
+func (r *runReport) fetched(calendar int, took time.Duration, events int, err error) {
+	if r == nil {
+		return
+	}
+	c := calendarReport{Calendar: calendar, Seconds: took.Seconds(), Events: events}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	r.Calendars = append(r.Calendars, c)
+}
+
+// expanded records the occurrences one feed contributed.
+func (r *runReport) expanded(calendar, occurrences, skipped int) {
+	if r == nil {
+		return
+	}
+	for i := range r.Calendars {
+		if r.Calendars[i].Calendar == calendar {
+			r.Calendars[i].Occurrences += occurrences
+			r.Calendars[i].Skipped += skipped
+		}
+	}
+}
+
+// truncated records that a feed was cut short by limit.
+func (r *runReport) truncated(calendar int, limit string) {
+	if r == nil {
+		return
+	}
+	for i := range r.Calendars {
+		if r.Calendars[i].Calendar == calendar && !slices.Contains(r.Calendars[i].Truncated, limit) {
+			r.Calendars[i].Truncated = append(r.Calendars[i].Truncated, limit)
+		}
+	}
+}
+
+// openCircuits records the hosts a run stopped fetching from.
+func (r *runReport) openCircuits(hosts []string) {
+	if r == nil {
+		return
+	}
+	r.OpenCircuits = append(r.OpenCircuits, hosts...)
+}
+
+// stale records that a failed calendar's events from updated were used.
+func (r *runReport) stale(calendar int, updated time.Time) {
+	if r == nil {
+		return
+	}
+	for i := range r.Calendars {
+		if r.Calendars[i].Calendar == calendar {
+			r.Calendars[i].Stale = updated
+		}
+	}
+}
+
+// wrote records a published output.
+func (r *runReport) wrote(out sink.Output) {
+	if r == nil {
+		return
+	}
+	sum := sha256.Sum256(out.Data)
+	r.Outputs = append(r.Outputs, outputReport{Path: out.Path, Bytes: len(out.Data), SHA256: hex.EncodeToString(sum[:])})
+}
+
+// finish records the run's outcome and writes the monitoring files. Errors
+// are coded exitWrite.
+func (r *runReport) finish(err error) error {
+	if r == nil {
+		return nil
+	}
+	end := time.Now()
+	r.Seconds = end.Sub(r.Started).Seconds()
+	r.ExitCode = exitCode(err)
+	switch r.ExitCode {
+	case 0:
+		r.Status = "ok"
+	case exitPartial:
+		r.Status = "partial"
+	default:
+		r.Status = "failed"
+	}
+	if err != nil {
+		for _, e := range flatten(err) {
+			r.Errors = append(r.Errors, e.Error())
+		}
+	}
+
+	var errs []error
+	if err := r.checkFeeds(); err != nil {
+		errs = append(errs, err)
+	}
+	r.reportSentry()
+	if r.mon.report != "" {
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err == nil {
+			err = writeLocal(r.mon.report, append(data, '\n'))
+		}
+		if err != nil {
+			errs = append(errs, withCode(exitWrite, fmt.Errorf("writing report: %w", err)))
+		}
+	}
+	if r.mon.metrics != "" {
+		// the last success has to survive failed runs, so carry it over
+		lastSuccess := readLastSuccess(r.mon.metrics)
+		if r.ExitCode == 0 {
+			lastSuccess = end
+		}
+		var buf bytes.Buffer
+		writeMetrics(&buf, r, lastSuccess)
+		if err := writeLocal(r.mon.metrics, buf.Bytes()); err != nil {
+			errs = append(errs, withCode(exitWrite, fmt.Errorf("writing metrics: %w", err)))
+		}
+	}
+	// the heartbeat goes stale, rather than saying so, when runs fail
+	if r.mon.heartbeat != "" && r.ExitCode == 0 {
+		line := end.UTC().Format(time.RFC3339) + " " + r.EventsSHA256 + "\n"
+		if err := writeLocal(r.mon.heartbeat, []byte(line)); err != nil {
+			errs = append(errs, withCode(exitWrite, fmt.Errorf("writing heartbeat: %w", err)))
+		}
+	}
+	if r.mon.webhook != "" {
+		if err := r.notify(); err != nil {
+			errs = append(errs, fmt.Errorf("webhook: %w", err))
+		}
+	}
+	if len(r.notifiers) > 0 {
+		errs = append(errs, r.notifyAll())
+	}
+	return errors.Join(errs...)
+}
+
+// reportSentry reports each calendar that failed to Sentry, tagged with
+// its number, and the run's errors if nothing was published. A calendar's
+// failures are grouped into one issue however their messages vary.
+func (r *runReport) reportSentry() {
+	for _, c := range r.Calendars {
+		if c.Error == "" {
+			continue
+		}
+		n := strconv.Itoa(c.Calendar)
+		reportSentry(notify.SentryEvent{
+			Message:     "calendar " + n + ": " + c.Error,
+			Level:       "error",
+			Tags:        r.sentryTags("calendar", n, "status", r.Status),
+			Fingerprint: []string{"calendar-failed", n},
+		})
+	}
+	if r.Status == "failed" {
+		reportSentry(notify.SentryEvent{
+			Message:     "run failed: " + strings.Join(r.Errors, "; "),
+			Level:       "error",
+			Tags:        r.sentryTags("status", r.Status, "exit_code", strconv.Itoa(r.ExitCode)),
+			Fingerprint: []string{"run-failed", strconv.Itoa(r.ExitCode)},
+		})
+	}
+}
+
+// sentryTags are the key, value pairs given, and the profile if there is
+// one.
+func (r *runReport) sentryTags(pairs ...string) map[string]string {
+	tags := make(map[string]string)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		tags[pairs[i]] = pairs[i+1]
+	}
+	if r.Profile != "" {
+		tags["profile"] = r.Profile
+	}
+	return tags
+}
+
+// notifyAll sends the report to the config's notifiers that want it: those
+// for failures if the run didn't succeed, those for every run, and the
+// daily ones if none has been sent today.
+func (r *runReport) notifyAll() error {
+	today := time.Now().Format(time.DateOnly)
+	daily := false
+	for _, n := range r.notifiers {
+		if n.When() == notify.OnDaily {
+			last, _ := os.ReadFile(r.mon.notifyState)
+			daily = string(bytes.TrimSpace(last)) != today
+			break
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var errs []error
+	for i, n := range r.notifiers {
+		msg := r.message()
+		switch n.When() {
+		case notify.OnFailure:
+			if r.Status == "ok" {
+				continue
+			}
+		case notify.OnDaily:
+			if !daily {
+				continue
+			}
+			msg.Title = "Calendar daily summary"
+			if n.Agenda {
+				msg.Body += "\n\n" + r.agenda()
+			}
+		case notify.OnUnchanged:
+			if len(r.newlyUnchanged) == 0 {
+				continue
+			}
+			msg = r.unchangedMessage()
+		case notify.OnEmpty:
+			if len(r.newlyEmpty) == 0 {
+				continue
+			}
+			msg = r.emptyMessage()
+		}
+		if r.Profile != "" {
+			msg.Title += " (" + r.Profile + ")"
+		}
+		if err := notify.Send(ctx, n, msg); err != nil {
+			errs = append(errs, fmt.Errorf("notify[%d] (%s): %w", i, n.Service, err))
+		}
+	}
+	// recorded even if sending failed, so a broken notifier isn't retried
+	// every run
+	if daily {
+		if err := writeLocal(r.mon.notifyState, []byte(today+"\n")); err != nil {
+			errs = append(errs, withCode(exitWrite, fmt.Errorf("writing notify state: %w", err)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// agenda lists the published events starting in the next day. Private
+// events show only as busy.
+func (r *runReport) agenda() string {
+	now := clock.Now()
+	var next []model.Event
+	for _, e := range r.events {
+		if !e.Start.Before(now) && e.Start.Before(now.Add(24*time.Hour)) {
+			next = append(next, e)
+		}
+	}
+	if len(next) == 0 {
+		return "Nothing in the next 24 hours."
+	}
+	sort.Slice(next, func(i, j int) bool { return next[i].Start.Before(next[j].Start) })
+	var b strings.Builder
+	b.WriteString("Next 24 hours:")
+	for _, e := range next {
+		title := e.Title
+		if e.Private {
+			title = "Busy"
+		}
+		fmt.Fprintf(&b, "\n%s–%s %s", e.Start.Format("Mon 15:04"), e.End.Format("15:04"), title)
+	}
+	return b.String()
+}
+
+// unchangedMessage tells "unchanged" notifiers about the feeds just found
+// unchanged for -unchanged-days.
+func (r *runReport) unchangedMessage() notify.Message {
+	var b strings.Builder
+	fmt.Fprintf(&b, "No changes in %d days; if the calendar is still in use, its secret URL may have been rotated.", r.mon.unchangedDays)
+	for _, f := range r.newlyUnchanged {
+		fmt.Fprintf(&b, "\n- calendar %d: %s", f.calendar, f.name)
+	}
+	return notify.Message{Title: "Calendar feed stopped changing", Body: b.String(), Urgent: true}
+}
+
+// emptyMessage tells "empty" notifiers about the feeds just found empty.
+func (r *runReport) emptyMessage() notify.Message {
+	var b strings.Builder
+	b.WriteString("These feeds usually have events but parsed to none; the provider may have changed its format.")
+	for _, f := range r.newlyEmpty {
+		fmt.Fprintf(&b, "\n- calendar %d: %s", f.calendar, f.name)
+	}
+	return notify.Message{Title: "Calendar feed is suddenly empty", Body: b.String(), Urgent: true}
+}
+
+// message describes the run for a notifier.
+func (r *runReport) message() notify.Message {
+	msg := notify.Message{Title: "Calendar published", Urgent: r.Status != "ok"}
+	switch r.Status {
+	case "partial":
+		msg.Title = "Calendar published, but some feeds failed"
+	case "failed":
+		msg.Title = "Calendar publish failed"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (exit %d) in %.1fs\n", r.Status, r.ExitCode, r.Seconds)
+	fmt.Fprintf(&b, "%d events from %d calendars\n", r.Events, len(r.Calendars))
+	for _, e := range r.Errors {
+		fmt.Fprintf(&b, "- %s\n", e)
+	}
+	msg.Body = strings.TrimSuffix(b.String(), "\n")
+	return msg
+}
+
+// notify posts the report, or -webhook-template's rendering of it, to
+// -webhook.
+func (r *runReport) notify() error {
+	var payload []byte
+	if r.mon.webhookPayload != nil {
+		var buf bytes.Buffer
+		if err := r.mon.webhookPayload.Execute(&buf, r); err != nil {
+			return fmt.Errorf("executing template: %w", err)
+		}
+		payload = buf.Bytes()
+	} else {
+		var err error
+		if payload, err = json.Marshal(r); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return notify.Webhook(ctx, r.mon.webhook, payload)
+}
+
+// writeLocal writes a local file atomically, whatever -sink is.
+func writeLocal(path string, data []byte) error {
+	snk, err := sink.Open("file:")
+	if err != nil {
+		return err
+	}
+	return snk.Write(context.Background(), sink.Output{Path: path, Data: data})
+}
@@ -0,0 +1,208 @@
+//go:build !js
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jackdorland/www/internal/clock"
+	"github.com/jackdorland/www/internal/crypto"
+	"github.com/jackdorland/www/internal/model"
+	"github.com/jackdorland/www/internal/output"
+)
+
+// selftestFeed is the feed selftest publishes, as of selftestNow with a
+// week's window in America/New_York, which moves to daylight saving time
+// on Sunday the 10th.
+const selftestFeed = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//calendar-setup//selftest//EN
+BEGIN:VEVENT
+UID:standup@selftest
+SUMMARY:Standup
+DTSTART;TZID=America/New_York:20240301T093000
+DTEND;TZID=America/New_York:20240301T094500
+RRULE:FREQ=DAILY;COUNT=20
+EXDATE;TZID=America/New_York:20240311T093000
+END:VEVENT
+BEGIN:VEVENT
+UID:yoga@selftest
+SUMMARY:Yoga
+DTSTART;TZID=Europe/Berlin:20240206T180000
+DTEND;TZID=Europe/Berlin:20240206T190000
+RRULE:FREQ=WEEKLY;BYDAY=TU,TH
+EXDATE;TZID=Europe/Berlin:20240307T180000,20240314T180000
+END:VEVENT
+BEGIN:VEVENT
+UID:conference@selftest
+SUMMARY:Conference
+DTSTART;VALUE=DATE:20240312
+DTEND;VALUE=DATE:20240314
+END:VEVENT
+BEGIN:VEVENT
+UID:flight@selftest
+SUMMARY:Flight
+DTSTART:20240309T180000Z
+DTEND:20240309T210000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:dentist@selftest
+SUMMARY:Dentist
+CLASS:PRIVATE
+DTSTART:20240313T150000
+DTEND:20240313T160000
+END:VEVENT
+BEGIN:VEVENT
+UID:past@selftest
+SUMMARY:Long gone
+DTSTART:20230101T100000Z
+DTEND:20230101T110000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:later@selftest
+SUMMARY:Next month
+DTSTART:20240410T100000Z
+DTEND:20240410T110000Z
+END:VEVENT
+END:VCALENDAR
+`
+
+// selftestNow is the time selftest runs at.
+var selftestNow = time.Date(2024, 3, 8, 12, 0, 0, 0, time.UTC)
+
+// selftestFormats are the formats selftest encrypts and decrypts with its
+// throwaway key: those a key decrypts.
+var selftestFormats = []string{crypto.CipherAESGCM, crypto.CipherChaCha, crypto.CipherAESSIV, crypto.CipherAESCTR, crypto.CipherWebCrypto, crypto.CipherJWE}
+
+// runSelftest implements the selftest command: publish a built-in feed
+// covering recurrences, time zones, all-day events and EXDATEs with the
+// clock pinned, check the events, and check each format round-trips them
+// under a throwaway key. It's a quick check of a new build on the server,
+// and needs no config, key or network.
+func runSelftest(args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	registerLogging(fs)
+	fs.Parse(args)
+
+	dir, err := os.MkdirTemp("", "calendar-selftest")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "selftest.ics")
+	if err := os.WriteFile(path, []byte(selftestFeed), 0600); err != nil {
+		return err
+	}
+	clock.Set(selftestNow)
+
+	failed := 0
+	check := func(name string, err error) {
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", name, err)
+			return
+		}
+		fmt.Printf("ok   %s\n", name)
+	}
+
+	render := renderOptions{window: "7d", timezone: "America/New_York"}
+	_, events, _, fetchErr, err := render.fetchEvents(context.Background(), []string{path}, nil)
+	if err == nil {
+		err = fetchErr
+	}
+	if err == nil {
+		err = compareEvents(events, selftestEvents())
+	}
+	check("expand", err)
+
+	// the key is given as most setups give it, in CAL_KEY, which
+	// aes-ctr needs anyway; any real one isn't used
+	os.Setenv("CAL_KEY", randomHex(32))
+	os.Unsetenv("CAL_MAC_KEY")
+	for _, format := range selftestFormats {
+		check(format, roundTrip(format, events))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("selftest: %d of %d checks failed", failed, 1+len(selftestFormats))
+	}
+	fmt.Println("selftest passed")
+	return nil
+}
+
+// selftestEvents are the events selftestFeed should publish, in order.
+func selftestEvents() []model.Event {
+	at := func(s string) time.Time {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	event := func(title, start, end string) model.Event {
+		return model.Event{Title: title, Start: at(start), End: at(end)}
+	}
+	return []model.Event{
+		// 09:30 in New York is 14:30 UTC until the 10th, then 13:30; the
+		// 11th is left out, and the 15th is after the window
+		event("Standup", "2024-03-08T14:30:00Z", "2024-03-08T14:45:00Z"),
+		event("Standup", "2024-03-09T14:30:00Z", "2024-03-09T14:45:00Z"),
+		event("Standup", "2024-03-10T13:30:00Z", "2024-03-10T13:45:00Z"),
+		event("Standup", "2024-03-12T13:30:00Z", "2024-03-12T13:45:00Z"),
+		event("Standup", "2024-03-13T13:30:00Z", "2024-03-13T13:45:00Z"),
+		event("Standup", "2024-03-14T13:30:00Z", "2024-03-14T13:45:00Z"),
+		// 18:00 in Berlin, where it's still winter; the 14th is left out
+		event("Yoga", "2024-03-12T17:00:00Z", "2024-03-12T18:00:00Z"),
+		// all-day, from midnight in New York
+		event("Conference", "2024-03-12T04:00:00Z", "2024-03-14T04:00:00Z"),
+		event("Flight", "2024-03-09T18:00:00Z", "2024-03-09T21:00:00Z"),
+		// floating, so in New York
+		{Title: "Dentist", Start: at("2024-03-13T19:00:00Z"), End: at("2024-03-13T20:00:00Z"), Private: true},
+	}
+}
+
+// compareEvents checks got has the titles, times and privacy of want.
+func compareEvents(got, want []model.Event) error {
+	if len(got) != len(want) {
+		return fmt.Errorf("got %d events, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Title != w.Title || !g.Start.Equal(w.Start) || !g.End.Equal(w.End) || g.Private != w.Private {
+			return fmt.Errorf("event %d is %q %s–%s (private %t), want %q %s–%s (private %t)", i,
+				g.Title, g.Start.UTC().Format(time.RFC3339), g.End.UTC().Format(time.RFC3339), g.Private,
+				w.Title, w.Start.UTC().Format(time.RFC3339), w.End.UTC().Format(time.RFC3339), w.Private)
+		}
+	}
+	return nil
+}
+
+// roundTrip encrypts events in format under CAL_KEY, decrypts them and
+// checks they come back as published: the same, but none private.
+func roundTrip(format string, events []model.Event) error {
+	data, err := output.Encrypt(nil, format, false, events, nil)
+	if err != nil {
+		return err
+	}
+	plaintext, err := crypto.DecryptCalendar(nil, false, data)
+	if err != nil {
+		return fmt.Errorf("decrypting: %w", err)
+	}
+	defer crypto.Wipe(plaintext)
+	var cal model.Calendar
+	if err := json.Unmarshal(plaintext, &cal); err != nil {
+		return fmt.Errorf("decrypted payload is not a calendar: %w", err)
+	}
+	want := make([]model.Event, len(events))
+	for i, e := range events {
+		e.Private = false
+		want[i] = e
+	}
+	return compareEvents(cal.Events, want)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/jackdorland/www/internal/notify"
+	"github.com/jackdorland/www/internal/version"
+)
+
+// sentry is the config's Sentry project, if it has one. Like clock.Set,
+// configOptions.load sets it once at startup.
+var sentry *notify.SentryConfig
+
+// reportSentry sends ev to the Sentry project, if there is one. A failure
+// to send it is only logged.
+func reportSentry(ev notify.SentryEvent) {
+	if sentry == nil {
+		return
+	}
+	ev.Release = version.Get().Short()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := notify.SendSentry(ctx, *sentry, ev); err != nil {
+		slog.Warn("Couldn't report to Sentry", "err", err)
+	}
+}
+
+// reportPanics, deferred, reports a panic to Sentry and carries on
+// panicking. A goroutine's panic ends the process without running main's
+// defers, so each goroutine running feed code defers it too.
+func reportPanics() {
+	if sentry == nil {
+		return
+	}
+	if v := recover(); v != nil {
+		reportSentry(notify.SentryEvent{Message: fmt.Sprint("panic: ", v), Level: "fatal", Stack: string(debug.Stack())})
+		panic(v)
+	}
+}
@@ -0,0 +1,417 @@
+//go:build !js
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jackdorland/www/internal/clock"
+	"github.com/jackdorland/www/internal/config"
+	"github.com/jackdorland/www/internal/model"
+	"github.com/jackdorland/www/internal/output"
+	"github.com/jackdorland/www/internal/sink"
+)
+
+// runServe implements the serve command: generate the outputs in memory,
+// regenerate them every -interval, and serve them over HTTP. Nothing is
+// written to disk. Prometheus metrics are served at /metrics, and with
+// -ics the calendar itself at /calendar.ics. /api/events filters the
+// events per request (see api.go), and /ws and /events (Server-Sent
+// Events) tell browsers when the outputs change (see push.go).
+// /badge.json is a shields.io badge saying whether the calendar's owner
+// is in a meeting. Other paths that aren't outputs fall through to -dir.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	var render renderOptions
+	var enc encryptOptions
+	render.register(fs)
+	enc.register(fs)
+	addr := fs.String("addr", "localhost:8080", "address to listen on, or unix:<path> for a Unix domain socket")
+	fastCGI := fs.Bool("fcgi", false, "speak FastCGI rather than HTTP, for a front-end server like nginx")
+	dir := fs.String("dir", "docs", "directory to serve other files from (empty for none)")
+	interval := durationFlag(fs, "interval", "CAL_INTERVAL", 15*time.Minute, "how often to refetch the feeds")
+	timeout := registerTimeout(fs)
+	registerFetchWorkers(fs)
+	registerNow(fs)
+	registerPprof(fs)
+	registerTracing(fs)
+	plaintext := fs.Bool("plaintext", false, "also serve each output's unencrypted JSON, as <name>.json")
+	icsFeed := fs.Bool("ics", false, "also serve the merged calendar unencrypted at /calendar.ics, for calendar apps to subscribe to; private events show as Busy")
+	icsToken := fs.String("ics-token", os.Getenv("CAL_ICS_TOKEN"), "if set, /calendar.ics needs ?token=<this> (env CAL_ICS_TOKEN)")
+	registerLogging(fs)
+	fs.Parse(args)
+
+	d := *interval
+	if d <= 0 {
+		return withCode(exitConfig, fmt.Errorf("invalid interval %s", d))
+	}
+	cfg, err := enc.load()
+	if err != nil {
+		return err
+	}
+
+	s := &server{render: &render, enc: &enc, cfg: cfg, plaintext: *plaintext, timeout: *timeout, interval: d, ics: *icsFeed, icsToken: *icsToken}
+	if err := s.refresh(); s.files == nil {
+		return err
+	} else if err != nil {
+		logErrors(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// streams end at shutdown, which doesn't wait for hijacked connections
+	s.done = ctx.Done()
+
+	// a refresh in progress finishes before the listener closes
+	var refreshing sync.WaitGroup
+	refreshing.Add(1)
+	go func() {
+		defer refreshing.Done()
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.refresh(); err != nil {
+					logErrors(err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	mux := http.NewServeMux()
+	var static http.Handler = http.NotFoundHandler()
+	if *dir != "" {
+		static = http.FileServer(http.Dir(*dir))
+	}
+	mux.Handle("/", s.handler(static))
+	mux.HandleFunc("/metrics", s.metrics)
+	mux.HandleFunc("/api/events", s.api)
+	mux.HandleFunc("/ws", s.serveWebSocket)
+	mux.HandleFunc("/events", s.serveEvents)
+	mux.HandleFunc("/badge.json", s.badge)
+	ln, err := listen(*addr)
+	if err != nil {
+		return withCode(exitConfig, err)
+	}
+	srv := &http.Server{Handler: mux}
+
+	errc := make(chan error, 1)
+	if *fastCGI {
+		go func() { errc <- fcgi.Serve(ln, mux) }()
+		slog.Info("Serving FastCGI", "addr", *addr, "interval", d.String())
+	} else {
+		go func() { errc <- srv.Serve(ln) }()
+		slog.Info("Serving", "addr", *addr, "interval", d.String())
+	}
+
+	select {
+	case err := <-errc:
+		stop()
+		refreshing.Wait()
+		return err
+	case <-ctx.Done():
+	}
+	slog.Info("Shutting down")
+	refreshing.Wait()
+	if *fastCGI {
+		// fcgi has no graceful shutdown; closing the listener stops it
+		return ln.Close()
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// listen listens on a TCP address, or a Unix domain socket given as
+// unix:<path>. A socket file left behind by an earlier run is replaced.
+// Closing the listener removes it.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// let the front-end server, usually in our group, connect
+	if err := os.Chmod(path, 0660); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// icsPath is where -ics serves the calendar.
+const icsPath = "/calendar.ics"
+
+// server holds the latest generated outputs.
+type server struct {
+	render    *renderOptions
+	enc       *encryptOptions
+	cfg       *config.Config
+	plaintext bool
+	timeout   time.Duration
+	interval  time.Duration
+	// ics serves /calendar.ics, to requests with ?token=icsToken if set.
+	ics      bool
+	icsToken string
+
+	mu      sync.RWMutex
+	files   map[string]servedFile
+	sum     [sha256.Size]byte
+	events  []model.Event
+	sources []model.Source
+	// report describes the last refresh, and lastSuccess is when the
+	// last one without errors finished.
+	report      *runReport
+	lastSuccess time.Time
+	// underway are the events that had started at the last refresh but
+	// not ended, for the badge; see ongoing.
+	underway []model.Event
+
+	// subscribers are sent an update whenever the outputs change; done
+	// is closed at shutdown.
+	subscribers   map[chan update]struct{}
+	lastGenerated time.Time
+	done          <-chan struct{}
+}
+
+type servedFile struct {
+	data         []byte
+	modTime      time.Time
+	etag         string
+	cacheControl string
+}
+
+// refresh regenerates the outputs, keeping the current ones (and their
+// ETags) if the events haven't changed. It isn't interrupted by shutdown,
+// only by the timeout.
+func (s *server) refresh() error {
+	rep := newReport(monitorOptions{}, nil)
+	err := traced(context.Background(), "refresh", func(ctx context.Context) error {
+		return s.generate(ctx, rep)
+	})
+	rep.finish(err)
+	s.mu.Lock()
+	if rep.Unchanged && s.report != nil {
+		rep.Outputs = s.report.Outputs
+	}
+	s.report = rep
+	if err == nil {
+		s.lastSuccess = time.Now()
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// generate does the work of refresh, recording it in rep.
+func (s *server) generate(ctx context.Context, rep *runReport) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	cals, events, sources, fetchErr, err := s.render.fetchEvents(ctx, feedSpecs(s.cfg), rep)
+	if err != nil {
+		return err
+	}
+	if len(cals) == 0 {
+		return fetchErr
+	}
+	// build adds the icons too, but the API serves these
+	if s.cfg != nil && len(s.cfg.Icons) > 0 {
+		events = output.WithIcons(events, s.cfg.Icons)
+	}
+	underway := ongoing(cals)
+	s.mu.Lock()
+	s.underway = underway
+	s.mu.Unlock()
+	s.enc.underway = underway
+	_, s.enc.windowEnd, s.enc.loc, _ = s.render.bounds()
+	s.enc.sources = sources
+	sum, err := eventsHash(events, sources)
+	if err != nil {
+		return err
+	}
+	rep.Events = len(events)
+	rep.EventsSHA256 = hex.EncodeToString(sum[:])
+	s.mu.RLock()
+	unchanged := s.files != nil && sum == s.sum
+	s.mu.RUnlock()
+	if unchanged {
+		slog.Debug("Events unchanged", "events", len(events))
+		rep.Unchanged = true
+		// but the time has moved on
+		return errors.Join(s.refreshClock(events, rep), fetchErr)
+	}
+
+	files, err := s.build(events, rep)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.files, s.sum, s.events, s.sources = files, sum, events, sources
+	s.broadcast(update{SHA256: rep.EventsSHA256, Events: len(events), Generated: time.Now()})
+	s.mu.Unlock()
+	slog.Info("Generated outputs", "events", len(events), "files", len(files))
+	return fetchErr
+}
+
+// build generates every output, keyed by URL path: the same files the
+// pipeline would write, plus plaintext JSON if enabled.
+func (s *server) build(events []model.Event, rep *runReport) (map[string]servedFile, error) {
+	outs, err := s.enc.build(s.cfg, events, s.plaintext)
+	if err != nil {
+		return nil, err
+	}
+	for _, out := range outs {
+		rep.wrote(out)
+	}
+	s.mu.RLock()
+	prev := s.files
+	s.mu.RUnlock()
+	now := time.Now()
+	files := make(map[string]servedFile)
+	add := func(path string, data []byte, cacheControl string) {
+		files[path] = newServedFile(prev, path, data, cacheControl, now)
+	}
+	for _, out := range outs {
+		add(servedPath(out), out.Data, sink.CacheControl(out, "public, no-cache"))
+	}
+	if s.ics {
+		// the feed isn't encrypted, so it shows only what the least tier does
+		if s.cfg != nil && len(s.cfg.Tiers) > 0 {
+			events = output.TierEvents(output.LeastTier(s.cfg.Tiers), events)
+		}
+		add(icsPath, output.ICS(events, s.interval), "private, no-cache")
+	}
+	return files, nil
+}
+
+// refreshClock rebuilds the outputs that depend on the time (see
+// clockOutputs) and serves them in place of the last ones, for a refresh
+// whose events are unchanged.
+func (s *server) refreshClock(events []model.Event, rep *runReport) error {
+	outs, err := s.enc.clockOutputs(s.cfg, events)
+	if len(outs) == 0 {
+		return err
+	}
+	for _, out := range outs {
+		rep.wrote(out)
+	}
+	now := time.Now()
+	s.mu.Lock()
+	files := maps.Clone(s.files)
+	for _, out := range outs {
+		path := servedPath(out)
+		files[path] = newServedFile(s.files, path, out.Data, sink.CacheControl(out, "public, no-cache"), now)
+	}
+	s.files = files
+	s.mu.Unlock()
+	return err
+}
+
+// servedPath is the URL path out is served at.
+func servedPath(out sink.Output) string {
+	return "/" + filepath.Base(out.Path)
+}
+
+// newServedFile serves data at path. If it came out the same as the file
+// prev serves there, it keeps that one's Last-Modified.
+func newServedFile(prev map[string]servedFile, path string, data []byte, cacheControl string, now time.Time) servedFile {
+	f := servedFile{data, now, etag(data), cacheControl}
+	if p, ok := prev[path]; ok && p.etag == f.etag {
+		f.modTime = p.modTime
+	}
+	return f
+}
+
+// etag is a strong ETag for data: its quoted SHA-256.
+func etag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// metrics serves the last refresh's metrics in the Prometheus text format.
+func (s *server) metrics(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	s.mu.RLock()
+	writeMetrics(&buf, s.report, s.lastSuccess)
+	s.mu.RUnlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// badge serves /badge.json, the current status in the shields.io endpoint
+// schema. It's worked out per request, so it's fresh between refreshes.
+func (s *server) badge(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	events := append(slices.Clip(s.underway), s.events...)
+	s.mu.RUnlock()
+	loc, err := time.LoadLocation(s.render.timezone)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := json.Marshal(output.StatusBadge(events, clock.Now(), loc))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	h.Set("Cache-Control", "public, max-age=60")
+	w.Write(data)
+}
+
+// handler serves the generated files and passes anything else to next.
+// Responses carry an ETag and Last-Modified, and http.ServeContent answers
+// If-None-Match and If-Modified-Since with 304 Not Modified, so polling
+// clients only download a file when it changes.
+func (s *server) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		f, ok := s.files[r.URL.Path]
+		s.mu.RUnlock()
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path == icsPath && s.icsToken != "" && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(s.icsToken)) != 1 {
+			http.NotFound(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("Content-Type", sink.ContentType(r.URL.Path))
+		h.Set("Cache-Control", f.cacheControl)
+		h.Set("ETag", f.etag)
+		http.ServeContent(w, r, r.URL.Path, f.modTime, bytes.NewReader(f.data))
+	})
+}
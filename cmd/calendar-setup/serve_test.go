@@ -0,0 +1,111 @@
+//go:build !js
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackdorland/www/internal/clock"
+	"github.com/jackdorland/www/internal/config"
+	"github.com/jackdorland/www/internal/crypto"
+	"github.com/jackdorland/www/internal/model"
+	"github.com/jackdorland/www/internal/output"
+)
+
+// newTestEncrypt returns encrypt options at their defaults.
+func newTestEncrypt(t *testing.T) *encryptOptions {
+	t.Helper()
+	var enc encryptOptions
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	enc.register(fs)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	return &enc
+}
+
+// /calendar.ics isn't encrypted, so with tiers it must show no more than
+// the least of them.
+func TestServeICSRedactsTiers(t *testing.T) {
+	for _, v := range []string{"CAL_KEY", "CAL_SIGNING_KEY"} {
+		t.Setenv(v, "")
+	}
+	cfg := &config.Config{Config: crypto.Config{
+		Keys: []crypto.KeyConfig{
+			{ID: "all", Key: strings.Repeat("01", 32)},
+			{ID: "busy", Key: strings.Repeat("02", 32)},
+		},
+		Tiers: []crypto.TierConfig{
+			{Name: "all", Key: "all"},
+			{Name: "busy", Key: "busy", Show: crypto.ShowBusy},
+		},
+	}}
+	s := &server{enc: newTestEncrypt(t), cfg: cfg, ics: true, interval: time.Hour}
+	events := []model.Event{{Title: "Dentist appointment", Start: mustTime(t, "2026-10-15T09:00:00Z"), End: mustTime(t, "2026-10-15T10:00:00Z")}}
+	files, err := s.build(events, newReport(monitorOptions{}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.files = files
+
+	rec := httptest.NewRecorder()
+	s.handler(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest("GET", icsPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d", icsPath, rec.Code)
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "Dentist") {
+		t.Errorf("%s shows a busy tier's title:\n%s", icsPath, body)
+	}
+	if !strings.Contains(body, "SUMMARY:Busy") {
+		t.Errorf("%s is missing the event:\n%s", icsPath, body)
+	}
+}
+
+// Between changes to the events, refreshClock keeps status.json current.
+func TestServeRefreshesClockOutputs(t *testing.T) {
+	for _, v := range []string{"CAL_KEY", "CAL_SIGNING_KEY"} {
+		t.Setenv(v, "")
+	}
+	t.Cleanup(func() { clock.Set(time.Time{}) })
+	cfg := &config.Config{Config: crypto.Config{CurrentKey: "k", Keys: []crypto.KeyConfig{{ID: "k", Key: strings.Repeat("01", 32)}}}}
+	enc := newTestEncrypt(t)
+	enc.status = true
+	s := &server{enc: enc, cfg: cfg, interval: time.Hour}
+	events := []model.Event{{Title: "Standup", Start: mustTime(t, "2026-10-15T09:00:00Z"), End: mustTime(t, "2026-10-15T10:00:00Z")}}
+
+	clock.Set(mustTime(t, "2026-10-15T08:00:00Z"))
+	files, err := s.build(events, newReport(monitorOptions{}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.files = files
+	status := func() output.Status {
+		t.Helper()
+		var st output.Status
+		if err := json.Unmarshal(s.files["/status.json"].data, &st); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+	if status().Busy {
+		t.Fatalf("before the standup, status is %+v, want free", status())
+	}
+
+	clock.Set(mustTime(t, "2026-10-15T09:30:00Z"))
+	if err := s.refreshClock(events, newReport(monitorOptions{}, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if !status().Busy {
+		t.Errorf("during the standup, status is %+v, want busy", status())
+	}
+	if _, ok := s.files["/cal.aes"]; !ok {
+		t.Error("refreshClock dropped the calendar")
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jackdorland/www/internal/crypto"
+	"github.com/jackdorland/www/internal/output"
+	"github.com/jackdorland/www/internal/version"
+)
+
+// runSnippet implements the snippet command: print a <script> element to
+// paste into a page, which lists the upcoming events from the calendar at
+// a URL. It records this build and the container version, so a snippet
+// is regenerated with the tool that changes the format.
+func runSnippet(args []string) error {
+	fs := flag.NewFlagSet("snippet", flag.ExitOnError)
+	target := fs.String("target", "#calendar", "CSS selector of the element to fill")
+	days := fs.Int("days", 7, "how many days ahead to list")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: snippet [flags] url (where cal.aes is published, next to decrypt.js; may be relative to the page)")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *days < 1 {
+		return withCode(exitConfig, fmt.Errorf("invalid days %d", *days))
+	}
+
+	data, err := output.Snippet(fs.Arg(0), *target, *days, version.Get().Short(), crypto.ContainerVersion)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
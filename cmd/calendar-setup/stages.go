@@ -0,0 +1,870 @@
+//go:build !js
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jackdorland/www/internal/clock"
+	"github.com/jackdorland/www/internal/config"
+	"github.com/jackdorland/www/internal/crypto"
+	"github.com/jackdorland/www/internal/model"
+	"github.com/jackdorland/www/internal/notify"
+	"github.com/jackdorland/www/internal/output"
+	"github.com/jackdorland/www/internal/sink"
+	"github.com/jackdorland/www/internal/timing"
+	"github.com/jackdorland/www/internal/tracing"
+)
+
+// runFetch implements the fetch command: download the feeds and save each
+// as calendar-<n>.ics (calendar-<n>-<k>.ics if a source yields several), so
+// later stages can be rerun without the network.
+func runFetch(args []string) error {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	dir := fs.String("dir", "feeds", "directory to save the feeds in")
+	var conf configOptions
+	conf.register(fs)
+	timeout := registerTimeout(fs)
+	registerFetchWorkers(fs)
+	registerPprof(fs)
+	registerLogging(fs)
+	fs.Parse(args)
+
+	cfg, err := conf.load()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext(*timeout)
+	defer cancel()
+
+	if err := os.MkdirAll(*dir, 0700); err != nil {
+		return withCode(exitWrite, err)
+	}
+	feeds, fetchErr := fetchAll(ctx, cfg, nil)
+	perCalendar := make(map[int]int)
+	for _, f := range feeds {
+		perCalendar[f.calendar]++
+	}
+	seen := make(map[int]int)
+	for _, f := range feeds {
+		seen[f.calendar]++
+		name := fmt.Sprintf("calendar-%d.ics", f.calendar)
+		if perCalendar[f.calendar] > 1 {
+			name = fmt.Sprintf("calendar-%d-%d.ics", f.calendar, seen[f.calendar])
+		}
+		path := filepath.Join(*dir, name)
+		if err := os.WriteFile(path, f.raw.Data, 0600); err != nil {
+			return withCode(exitWrite, fmt.Errorf("writing feed: %w", err))
+		}
+		slog.Info("Saved feed", "calendar", f.calendar, "path", path)
+	}
+	return fetchErr
+}
+
+// runRender implements the render command: expand the feeds into the
+// calendar JSON that would be encrypted, from the feeds given as arguments
+// (saved .ics files, globs or URLs) or the configured feeds otherwise.
+func runRender(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	var render renderOptions
+	render.register(fs)
+	var conf configOptions
+	conf.register(fs)
+	out := fs.String("o", "-", "where to write the JSON")
+	pretty := fs.Bool("pretty", false, "indent the JSON")
+	timeout := registerTimeout(fs)
+	registerFetchWorkers(fs)
+	registerNow(fs)
+	registerPprof(fs)
+	registerLogging(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: render [flags] [feed ...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	cfg, err := conf.load()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext(*timeout)
+	defer cancel()
+
+	specs := feedSpecs(cfg)
+	if fs.NArg() > 0 {
+		specs = fs.Args()
+	}
+	feeds, events, sources, fetchErr, err := render.fetchEvents(ctx, specs, nil)
+	if err != nil {
+		return err
+	}
+	if len(feeds) == 0 {
+		return fetchErr
+	}
+	cal := model.Calendar{Events: events}
+	cal.SetSources(sources)
+	var data []byte
+	marshalStart := time.Now()
+	if *pretty {
+		data, err = json.MarshalIndent(cal, "", "  ")
+	} else {
+		data, err = json.Marshal(cal)
+	}
+	timing.Since(timing.Serialize, marshalStart)
+	if err != nil {
+		return fmt.Errorf("marshalling calendar: %w", err)
+	}
+	data = append(data, '\n')
+
+	if *out == "-" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(*out, data, 0600)
+	}
+	return errors.Join(withCode(exitWrite, err), fetchErr)
+}
+
+// runEncrypt implements the encrypt command: read calendar JSON from
+// render (a file, or stdin) and write the encrypted outputs.
+func runEncrypt(args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	var enc encryptOptions
+	enc.register(fs)
+	timezone := fs.String("timezone", envDefault("CAL_TIMEZONE", "Local"), "IANA time zone the human-readable outputs, like status.txt, are written in (env CAL_TIMEZONE)")
+	registerNow(fs)
+	registerPprof(fs)
+	registerLogging(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: encrypt [flags] [calendar.json (default stdin)]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	cfg, err := enc.load()
+	if err != nil {
+		return err
+	}
+	if enc.loc, err = time.LoadLocation(*timezone); err != nil {
+		return withCode(exitConfig, fmt.Errorf("invalid timezone: %w", err))
+	}
+
+	var data []byte
+	if fs.NArg() == 0 || fs.Arg(0) == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(fs.Arg(0))
+	}
+	if err != nil {
+		return fmt.Errorf("reading calendar: %w", err)
+	}
+
+	var cal model.Calendar
+	err = json.Unmarshal(data, &cal)
+	crypto.Wipe(data)
+	if err != nil {
+		return fmt.Errorf("parsing calendar: %w", err)
+	}
+	ctx, cancel := commandContext(0)
+	defer cancel()
+	enc.sources = cal.Sources
+	return enc.write(ctx, cfg, cal.Events, nil)
+}
+
+// encryptOptions are the flags controlling how the calendar is encrypted
+// and where it's written.
+type encryptOptions struct {
+	format        string
+	legacyCTR     bool
+	passphrase    bool
+	conf          configOptions
+	requireAES256 bool
+	output        string
+	sink          string
+	dryRun        bool
+	verify        bool
+	demo          bool
+	widget        string
+	deployHook    string
+	manifest      bool
+	weekPNG       string
+	timeline      bool
+	status        bool
+	nextLabel     string
+	manifestKeep  int
+	availability  time.Duration
+	freeSlots     time.Duration
+	workingHours  output.WorkingHours
+	booking       output.BookingRules
+	summary       bool
+	summaryPath   string
+	// templatePrivate keeps private titles in -format=template output.
+	templatePrivate bool
+	viewerZones     []*time.Location
+
+	// template is set by load for -format=template:<path>, and
+	// summaryTemplate for -summary.
+	template        *template.Template
+	summaryTemplate *template.Template
+	// underway are the events already under way, which -status,
+	// -availability, -free-slots, -booking and -summary count but the
+	// window leaves out, and windowEnd the
+	// end of the window; the pipeline and serve set them.
+	underway  []model.Event
+	windowEnd time.Time
+	// loc is -timezone, which the human-readable outputs are written in;
+	// the pipeline and serve set it, and the encrypt command has its own
+	// -timezone.
+	loc *time.Location
+	// sources are the calendars the events came from, published with
+	// them; the pipeline and serve set them, and encrypt reads them from
+	// render's JSON.
+	sources []model.Source
+}
+
+func (o *encryptOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.format, "format", envDefault("CAL_FORMAT", crypto.CipherAESGCM), "output format: aes-gcm, chacha20-poly1305, aes-siv, aes-ctr (legacy layout), webcrypto, jwe, age, box, or template:<path> for unencrypted output from a text/template (env CAL_FORMAT)")
+	fs.StringVar(&o.format, "cipher", o.format, "old name for -format")
+	fs.BoolVar(&o.templatePrivate, "template-private", false, "keep the titles of private events in -format=template output, which is published unencrypted; by default they're titled Busy")
+	fs.BoolVar(&o.legacyCTR, "legacy-ctr", false, "shorthand for -format=aes-ctr")
+	fs.BoolVar(&o.passphrase, "passphrase", false, "treat CAL_KEY as a passphrase and derive the key with Argon2id")
+	o.conf.register(fs)
+	fs.BoolVar(&o.requireAES256, "require-aes-256", false, "refuse to encrypt with keys shorter than 256 bits")
+	fs.StringVar(&o.output, "output", envDefault("CAL_OUTPUT", "docs/cal.aes"), "where to write the encrypted calendar (env CAL_OUTPUT)")
+	fs.StringVar(&o.sink, "sink", envDefault("CAL_SINK", "file:"), "where to publish the outputs: file: for local paths, file:<root>, s3:<bucket>/<prefix>[?region=&endpoint=&cache-control=] for an S3-compatible bucket, sftp://<user>@<host>/<dir>[?key=&known_hosts=] for a server over SSH, git:[<dir>][?remote=&branch=&message=] to commit and push them, or kv:<account>/<namespace>[?token=&prefix=] for Cloudflare Workers KV (env CAL_SINK)")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "encrypt in memory and print what would be published, without writing anything")
+	fs.BoolVar(&o.verify, "verify", true, "after publishing, read each calendar back and, if a key held here opens it, decrypt it and check it holds every event, failing the run if not")
+	fs.BoolVar(&o.demo, "demo", false, "also write demo.html, a page that decrypts and lists the calendar in the browser (aes-gcm only)")
+	fs.StringVar(&o.deployHook, "deploy-hook", os.Getenv("CAL_DEPLOY_HOOK"), "build hook URL (Netlify, Vercel, Cloudflare Pages) to POST to after publishing, so the site rebuilds (env CAL_DEPLOY_HOOK)")
+	fs.BoolVar(&o.manifest, "manifest", false, "also write a content-named copy of each calendar (cal.<sha>.aes) and manifest.json mapping the names to them, so a CDN can cache the copies forever")
+	fs.IntVar(&o.manifestKeep, "manifest-keep", 3, "how many generations of -manifest copies to keep published")
+	fs.StringVar(&o.weekPNG, "week-png", os.Getenv("CAL_WEEK_PNG"), "also write week.png, this size (like 1200x630 for OpenGraph or 800x480 for e-ink), showing this week's busy times in -timezone (default local time) without titles (env CAL_WEEK_PNG)")
+	fs.BoolVar(&o.timeline, "timeline", false, "also write timeline.svg, the window's busy times in -timezone (default local time) without titles, for the site to inline and style with CSS variables")
+	fs.BoolVar(&o.status, "status", false, "also write status.json and status.txt, saying whether the calendar's owner is busy and what's next as of the run (\"busy until 15:00\", \"next: Standup at 09:30\"), for the site's header and shell prompts; private events show as Busy")
+	fs.StringVar(&o.nextLabel, "next-label", os.Getenv("CAL_NEXT_LABEL"), "with -status, title every event this, like Busy, so the next event's countdown doesn't reveal what it is (env CAL_NEXT_LABEL)")
+	if v := os.Getenv("CAL_AVAILABILITY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			fatal("Invalid CAL_AVAILABILITY", "err", err)
+		}
+		o.availability = d
+	}
+	fs.Func("availability", "also write availability.json, how many of the calendars, one per person, are free in each slot of this length, like 30m, through the window, for a team page; it doesn't say who is busy or with what (the pipeline and serve only; env CAL_AVAILABILITY)", func(s string) (err error) {
+		o.availability, err = time.ParseDuration(s)
+		return err
+	})
+	if v := os.Getenv("CAL_FREE_SLOTS"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			fatal("Invalid CAL_FREE_SLOTS", "err", err)
+		}
+		o.freeSlots = d
+	}
+	fs.Func("free-slots", "also write free-slots.json, the times in -working-hours, on weekdays through the window, when every calendar is free for a meeting this long, like 1h (the pipeline and serve only; env CAL_FREE_SLOTS)", func(s string) (err error) {
+		o.freeSlots, err = time.ParseDuration(s)
+		return err
+	})
+	hours := envDefault("CAL_WORKING_HOURS", "09:00-17:00")
+	var err error
+	if o.workingHours, err = output.ParseWorkingHours(hours); err != nil {
+		fatal("Invalid CAL_WORKING_HOURS", "err", err)
+	}
+	fs.Func("working-hours", "the hours, in -timezone, -free-slots and -booking suggest meetings in (env CAL_WORKING_HOURS, default "+hours+")", func(s string) (err error) {
+		o.workingHours, err = output.ParseWorkingHours(s)
+		return err
+	})
+	durationVarFlag(fs, &o.booking.Length, "booking", "CAL_BOOKING", 0, "also write booking.json, the times in -working-hours, on weekdays through the window, a visitor may propose a meeting this long, like 30m, for a booking page")
+	durationVarFlag(fs, &o.booking.Buffer, "booking-buffer", "CAL_BOOKING_BUFFER", 0, "keep -booking slots this far from any event")
+	durationVarFlag(fs, &o.booking.Notice, "booking-notice", "CAL_BOOKING_NOTICE", 24*time.Hour, "offer no -booking slot starting sooner than this")
+	intVarFlag(fs, &o.booking.MaxPerDay, "booking-max", "CAL_BOOKING_MAX", 0, "offer no -booking slots on days with this many events in working hours already (0 for no limit)")
+	fs.BoolVar(&o.summary, "summary", false, "also write summary.txt, a sentence on the rest of the week (\"Busy Tuesday morning, away Thursday–Friday, 11 meetings total\") for the site's \"this week\" blurb")
+	fs.StringVar(&o.summaryPath, "summary-template", envDefault("CAL_SUMMARY_TEMPLATE", ""), "write -summary with this text/template, executed with output.Summary, instead of the default (env CAL_SUMMARY_TEMPLATE)")
+	zones := envDefault("CAL_VIEWER_ZONES", "")
+	if o.viewerZones, err = output.ParseZones(zones); err != nil {
+		fatal("Invalid CAL_VIEWER_ZONES", "err", err)
+	}
+	fs.Func("viewer-zones", "also publish each event's times in these time zones, like local (-timezone),UTC,America/New_York, for pages to show in several without a time zone database; templates can use {{zoned .}} (env CAL_VIEWER_ZONES)", func(s string) (err error) {
+		o.viewerZones, err = output.ParseZones(s)
+		return err
+	})
+	registerLocale(fs)
+	fs.StringVar(&o.widget, "widget", envDefault("CAL_WIDGET", ""), "also write widget.html, .js and .css, a week strip to include in site pages, which load it from this URL path, say /docs/ (aes-gcm only; env CAL_WIDGET)")
+}
+
+// load reads the config, if any, and checks the encryption keys. Errors are
+// coded exitConfig.
+func (o *encryptOptions) load() (*config.Config, error) {
+	if o.legacyCTR {
+		o.format = crypto.CipherAESCTR
+	}
+
+	cfg, err := o.conf.load()
+	if err != nil {
+		return nil, err
+	}
+	if cfg != nil && cfg.Output != "" {
+		o.output = cfg.Output
+	}
+	if cfg != nil && cfg.Sink != "" {
+		o.sink = cfg.Sink
+	}
+
+	if minBits := o.minKeyBits(cfg.Crypto()); minBits > 0 {
+		if err := crypto.CheckKeyStrength(cfg.Crypto(), o.passphrase, minBits); err != nil {
+			return nil, withCode(exitConfig, fmt.Errorf("invalid key: %w", err))
+		}
+	}
+	if _, err := sink.Open(o.sink); err != nil {
+		return nil, withCode(exitConfig, err)
+	}
+	if path, ok := strings.CutPrefix(o.format, output.TemplatePrefix); ok {
+		if cfg != nil && len(cfg.Tiers) > 0 {
+			return nil, withCode(exitConfig, fmt.Errorf("tiers need an encrypted format, not a template"))
+		}
+		var err error
+		if o.template, err = output.ParseTemplate(path); err != nil {
+			return nil, withCode(exitConfig, err)
+		}
+	}
+	if o.summary {
+		var err error
+		if o.summaryTemplate, err = output.ParseSummaryTemplate(o.summaryPath); err != nil {
+			return nil, withCode(exitConfig, err)
+		}
+	}
+	if cfg != nil && len(cfg.Tiers) > 0 && (o.format == crypto.CipherAge || o.format == crypto.CipherBox) {
+		return nil, withCode(exitConfig, fmt.Errorf("tiers need a keyring cipher; %s encrypts every tier to the same recipients", o.format))
+	}
+	return cfg, nil
+}
+
+// minKeyBits is the smallest AES key the format will accept, or 0 for the
+// public-key formats.
+func (o *encryptOptions) minKeyBits(cfg *crypto.Config) int {
+	switch o.format {
+	case crypto.CipherAESGCM, crypto.CipherChaCha, crypto.CipherAESSIV, crypto.CipherAESCTR, crypto.CipherWebCrypto, crypto.CipherJWE:
+		minBits := 128
+		if cfg != nil && cfg.MinKeyBits > minBits {
+			minBits = cfg.MinKeyBits
+		}
+		if o.requireAES256 || o.format == crypto.CipherChaCha {
+			minBits = 256
+		}
+		return minBits
+	}
+	return 0
+}
+
+// build encrypts events into the outputs to publish: the calendar (or one
+// per tier), its signature and decrypt.js, plus unencrypted JSON next to
+// each calendar if plaintext is set. Every tier is attempted; the outputs
+// that could be built are returned with the failures joined.
+func (o *encryptOptions) build(cfg *config.Config, events []model.Event, plaintext bool) ([]sink.Output, error) {
+	signKey, err := crypto.SigningKey()
+	if err != nil {
+		return nil, withCode(exitConfig, fmt.Errorf("loading signing key: %w", err))
+	}
+	defer crypto.Wipe(signKey)
+
+	if cfg != nil && len(cfg.Icons) > 0 {
+		events = output.WithIcons(events, cfg.Icons)
+	}
+	if len(o.viewerZones) > 0 {
+		zones := slices.Clone(o.viewerZones)
+		for i, z := range zones {
+			if z == time.Local {
+				zones[i] = o.location()
+			}
+		}
+		events = output.InZones(events, zones)
+	}
+	if o.template != nil {
+		data, err := output.RenderTemplate(o.template, events, o.sources, o.location(), o.templatePrivate)
+		if err != nil {
+			return nil, err
+		}
+		outs := []sink.Output{{Path: o.output, Data: data, Private: true}}
+		if signKey != nil {
+			outs = append(outs, sink.Output{Path: crypto.SignaturePath(o.output), Data: crypto.Sign(signKey, data)})
+		}
+		if o.manifest {
+			return o.withManifest(outs, len(outs))
+		}
+		return outs, nil
+	}
+
+	var outs []sink.Output
+	var calendars []string
+	add := func(cfg *crypto.Config, passphrase bool, events []model.Event, path string) error {
+		data, err := output.Encrypt(cfg, o.format, passphrase, events, o.sources)
+		if err != nil {
+			return err
+		}
+		outs = append(outs, sink.Output{Path: path, Data: data})
+		calendars = append(calendars, path)
+		if signKey != nil {
+			outs = append(outs, sink.Output{Path: crypto.SignaturePath(path), Data: crypto.Sign(signKey, data)})
+		}
+		if plaintext {
+			plain, err := output.Marshal(events, o.sources)
+			if err != nil {
+				return err
+			}
+			outs = append(outs, sink.Output{Path: strings.TrimSuffix(path, filepath.Ext(path)) + ".json", Data: plain, Private: true})
+		}
+		return nil
+	}
+
+	var errs []error
+	if cfg != nil && len(cfg.Tiers) > 0 {
+		for _, tier := range cfg.Tiers {
+			if err := add(cfg.ForTier(tier), false, output.TierEvents(tier, events), output.TierPath(tier)); err != nil {
+				errs = append(errs, fmt.Errorf("tier %s: %w", tier.Name, err))
+			}
+		}
+	} else if err := add(cfg.Crypto(), o.passphrase, events, o.output); err != nil {
+		errs = append(errs, err)
+	}
+	// the outputs after these are the same every run
+	calendarOuts := len(outs)
+	clockOuts, err := o.clockOutputs(cfg, events)
+	outs = append(outs, clockOuts...)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if o.format == crypto.CipherAESGCM {
+		dir := filepath.Dir(o.output)
+		js, err := crypto.DecryptJS()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("decrypt.js: %w", err))
+		} else {
+			outs = append(outs, sink.Output{Path: filepath.Join(dir, "decrypt.js"), Data: js})
+		}
+		// the calendars as the pages next to decrypt.js see them
+		var files []string
+		for _, path := range calendars {
+			files = append(files, relPath(dir, path))
+		}
+		if o.demo {
+			if html, err := output.DemoHTML(files); err != nil {
+				errs = append(errs, fmt.Errorf("demo.html: %w", err))
+			} else {
+				outs = append(outs, sink.Output{Path: filepath.Join(dir, "demo.html"), Data: html})
+			}
+		}
+		if o.widget != "" && len(files) > 0 {
+			base := strings.TrimSuffix(o.widget, "/") + "/"
+			if widget, err := output.WidgetFiles(files[0], base); err != nil {
+				errs = append(errs, fmt.Errorf("widget: %w", err))
+			} else {
+				for _, name := range []string{"widget.html", "widget.js", "widget.css"} {
+					outs = append(outs, sink.Output{Path: filepath.Join(dir, name), Data: widget[name]})
+				}
+			}
+		}
+	}
+	if o.manifest {
+		var err error
+		if outs, err = o.withManifest(outs, calendarOuts); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return outs, withCode(exitWrite, errors.Join(errs...))
+}
+
+// clockOutputs builds the outputs that depend on the time as well as the
+// events, like status.json, which a quiet calendar mustn't leave saying
+// busy once the meeting is over.
+func (o *encryptOptions) clockOutputs(cfg *config.Config, events []model.Event) ([]sink.Output, error) {
+	var outs []sink.Output
+	var errs []error
+	if o.weekPNG != "" {
+		var w, h int
+		if _, err := fmt.Sscanf(o.weekPNG, "%dx%d", &w, &h); err != nil {
+			errs = append(errs, fmt.Errorf("week.png: invalid size %q", o.weekPNG))
+		} else if img, err := output.WeekPNG(events, clock.Now().In(o.location()), w, h); err != nil {
+			errs = append(errs, fmt.Errorf("week.png: %w", err))
+		} else {
+			outs = append(outs, sink.Output{Path: filepath.Join(filepath.Dir(o.output), "week.png"), Data: img})
+		}
+	}
+	if o.timeline {
+		outs = append(outs, sink.Output{Path: filepath.Join(filepath.Dir(o.output), "timeline.svg"), Data: output.TimelineSVG(events, clock.Now(), o.location())})
+	}
+	if o.status {
+		dir := filepath.Dir(o.output)
+		// status.json is unencrypted, so it shows no more than any tier
+		current := append(slices.Clip(o.underway), events...)
+		if cfg != nil && len(cfg.Tiers) > 0 {
+			current = output.TierEvents(output.LeastTier(cfg.Tiers), current)
+		}
+		status := output.CurrentStatus(current, clock.Now(), o.nextLabel)
+		if data, err := json.Marshal(status); err != nil {
+			errs = append(errs, fmt.Errorf("status.json: %w", err))
+		} else {
+			outs = append(outs, sink.Output{Path: filepath.Join(dir, "status.json"), Data: data})
+		}
+		outs = append(outs, sink.Output{Path: filepath.Join(dir, "status.txt"), Data: []byte(status.Text(o.location()) + "\n")})
+	}
+	if o.summary {
+		summary := output.WeekSummary(append(slices.Clip(o.underway), events...), clock.Now(), o.location())
+		if data, err := output.RenderSummary(o.summaryTemplate, summary); err != nil {
+			errs = append(errs, fmt.Errorf("summary.txt: %w", err))
+		} else {
+			outs = append(outs, sink.Output{Path: filepath.Join(filepath.Dir(o.output), "summary.txt"), Data: append(data, '\n')})
+		}
+	}
+	if o.availability > 0 {
+		if data, err := o.teamAvailability(events); err != nil {
+			errs = append(errs, fmt.Errorf("availability.json: %w", err))
+		} else {
+			outs = append(outs, sink.Output{Path: filepath.Join(filepath.Dir(o.output), "availability.json"), Data: data})
+		}
+	}
+	if o.freeSlots > 0 {
+		if data, err := o.commonFreeSlots(events); err != nil {
+			errs = append(errs, fmt.Errorf("free-slots.json: %w", err))
+		} else {
+			outs = append(outs, sink.Output{Path: filepath.Join(filepath.Dir(o.output), "free-slots.json"), Data: data})
+		}
+	}
+	if o.booking.Length > 0 {
+		o.booking.Hours = o.workingHours
+		b := output.BookingSlots(append(slices.Clip(o.underway), events...), clock.Now(), o.until(events), o.booking, o.location())
+		if data, err := json.Marshal(b); err != nil {
+			errs = append(errs, fmt.Errorf("booking.json: %w", err))
+		} else {
+			outs = append(outs, sink.Output{Path: filepath.Join(filepath.Dir(o.output), "booking.json"), Data: data})
+		}
+	}
+	return outs, errors.Join(errs...)
+}
+
+// teamAvailability is availability.json for events. Through the encrypt
+// command, whose events don't say whose they are, it fails.
+func (o *encryptOptions) teamAvailability(events []model.Event) ([]byte, error) {
+	a, err := output.TeamAvailability(append(slices.Clip(o.underway), events...), o.people(), clock.Now(), o.until(events), o.availability)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(a)
+}
+
+// commonFreeSlots is free-slots.json for events; see teamAvailability.
+func (o *encryptOptions) commonFreeSlots(events []model.Event) ([]byte, error) {
+	fs, err := output.CommonFreeSlots(append(slices.Clip(o.underway), events...), o.people(), clock.Now(), o.until(events), o.freeSlots, o.workingHours, o.location())
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(fs)
+}
+
+// location is loc, or the local time zone if it isn't set.
+func (o *encryptOptions) location() *time.Location {
+	if o.loc == nil {
+		return time.Local
+	}
+	return o.loc
+}
+
+// people are the calendars, one per person, that -availability and
+// -free-slots count: those that didn't fail, and so wouldn't look free.
+func (o *encryptOptions) people() []int {
+	var people []int
+	for _, s := range o.sources {
+		if s.Status != model.SourceFailed {
+			people = append(people, s.Calendar)
+		}
+	}
+	return people
+}
+
+// until is the end of the window, or failing that of the last event.
+func (o *encryptOptions) until(events []model.Event) time.Time {
+	to := o.windowEnd
+	if to.IsZero() {
+		for _, e := range events {
+			if e.End.After(to) {
+				to = e.End
+			}
+		}
+	}
+	return to
+}
+
+// withManifest adds a content-named copy of each of the first n outputs,
+// then the manifest mapping their names to the copies, last so it's only
+// published once they are.
+func (o *encryptOptions) withManifest(outs []sink.Output, n int) ([]sink.Output, error) {
+	dir := filepath.Dir(o.output)
+	m := output.Manifest{Generated: clock.Now(), Files: make(map[string]string)}
+	for _, out := range outs[:n] {
+		hashed := sink.Output{Path: output.HashedName(out.Path, out.Data), Data: out.Data, Private: out.Private, Immutable: true}
+		outs = append(outs, hashed)
+		m.Files[relPath(dir, out.Path)] = relPath(dir, hashed.Path)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return outs, fmt.Errorf("%s: %w", output.ManifestName, err)
+	}
+	return append(outs, sink.Output{Path: filepath.Join(dir, output.ManifestName), Data: data}), nil
+}
+
+// rotateManifest adds the published manifest's generations to the one in
+// outs (it's last) and returns the paths of the copies no longer in it. A
+// sink that can't read back the manifest keeps every copy.
+func (o *encryptOptions) rotateManifest(ctx context.Context, snk sink.Sink, outs []sink.Output) []string {
+	r, ok := snk.(sink.Reader)
+	if !ok || len(outs) == 0 {
+		return nil
+	}
+	out := &outs[len(outs)-1]
+	var m output.Manifest
+	if err := json.Unmarshal(out.Data, &m); err != nil {
+		return nil
+	}
+	var prev *output.Manifest
+	if data, err := r.Read(ctx, out.Path); err == nil {
+		prev = new(output.Manifest)
+		if err := json.Unmarshal(data, prev); err != nil {
+			slog.Warn("Ignoring the published manifest", "path", out.Path, "err", err)
+			prev = nil
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("Couldn't read the published manifest; keeping old copies", "path", out.Path, "err", err)
+		return nil
+	}
+
+	stale := m.Rotate(prev, o.manifestKeep)
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil
+	}
+	out.Data = data
+	dir := filepath.Dir(out.Path)
+	for i, name := range stale {
+		stale[i] = filepath.Join(dir, filepath.FromSlash(name))
+	}
+	return stale
+}
+
+// relPath is path relative to dir, with forward slashes, for pages.
+func relPath(dir, path string) string {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// write builds the outputs for events and publishes them to the sink,
+// recording each in rep. With -dry-run it only prints a summary. Once ctx
+// is done no further outputs are started.
+func (o *encryptOptions) write(ctx context.Context, cfg *config.Config, events []model.Event, rep *runReport) error {
+	_, span := tracing.Start(ctx, "encrypt", "format", o.format, "events", len(events))
+	outs, buildErr := o.build(cfg, events, false)
+	span.SetAttr("outputs", len(outs))
+	span.End(buildErr)
+	if o.dryRun {
+		summarize(outs, events, o.format)
+		return buildErr
+	}
+
+	snk, err := sink.Open(o.sink)
+	if err != nil {
+		return withCode(exitConfig, err)
+	}
+	var stale []string
+	if o.manifest {
+		stale = o.rotateManifest(ctx, snk, outs)
+	}
+	if err := o.upload(ctx, snk, outs, stale, buildErr, rep); err != nil {
+		return err
+	}
+	if o.verify {
+		_, span := tracing.Start(ctx, "verify")
+		err := o.verifyPublished(ctx, snk, cfg, outs, len(events))
+		span.End(err)
+		if err != nil {
+			slog.Error("The published calendar is unreadable", "err", err)
+			return withCode(exitWrite, err)
+		}
+	}
+
+	slog.Info("Successfully encrypted and saved calendar", "events", len(events), "format", o.format, "outputs", len(outs))
+	return o.deploy(ctx)
+}
+
+// writeClock publishes just the outputs that depend on the time (see
+// clockOutputs), for a run whose events are the ones last written.
+func (o *encryptOptions) writeClock(ctx context.Context, cfg *config.Config, events []model.Event, rep *runReport) error {
+	outs, buildErr := o.clockOutputs(cfg, events)
+	buildErr = withCode(exitWrite, buildErr)
+	if len(outs) == 0 || o.dryRun {
+		return buildErr
+	}
+	snk, err := sink.Open(o.sink)
+	if err != nil {
+		return withCode(exitConfig, err)
+	}
+	if err := o.upload(ctx, snk, outs, nil, buildErr, rep); err != nil {
+		return err
+	}
+	slog.Info("Refreshed the time-dependent outputs", "outputs", len(outs))
+	return o.deploy(ctx)
+}
+
+// upload writes outs to snk, recording each in rep, then, if they all
+// were and the build had no errors either, removes the stale paths and
+// commits.
+func (o *encryptOptions) upload(ctx context.Context, snk sink.Sink, outs []sink.Output, stale []string, buildErr error, rep *runReport) error {
+	errs := []error{buildErr}
+	for _, out := range outs {
+		_, span := tracing.Start(ctx, "upload", "path", out.Path, "bytes", len(out.Data))
+		err := snk.Write(ctx, out)
+		span.End(err)
+		if err != nil {
+			errs = append(errs, withCode(exitWrite, fmt.Errorf("writing %s: %w", out.Path, err)))
+			continue
+		}
+		slog.Debug("Wrote output", "path", out.Path, "bytes", len(out.Data))
+		rep.wrote(out)
+	}
+	// a partial tree is neither committed nor tidied
+	complete := buildErr == nil && len(errs) == 1
+	if r, ok := snk.(sink.Remover); ok && complete {
+		for _, path := range stale {
+			if err := r.Remove(ctx, path); err != nil {
+				slog.Warn("Couldn't remove an old copy", "path", path, "err", err)
+				continue
+			}
+			slog.Debug("Removed old copy", "path", path)
+		}
+	}
+	if c, ok := snk.(sink.Committer); ok && complete {
+		_, span := tracing.Start(ctx, "commit")
+		err := c.Commit(ctx)
+		span.End(err)
+		if err != nil {
+			errs = append(errs, withCode(exitWrite, fmt.Errorf("publishing: %w", err)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deploy calls -deploy-hook, if set.
+func (o *encryptOptions) deploy(ctx context.Context) error {
+	if o.deployHook == "" {
+		return nil
+	}
+	// the hooks all start a build on an empty POST
+	if err := notify.Webhook(ctx, o.deployHook, []byte("{}")); err != nil {
+		return withCode(exitWrite, fmt.Errorf("deploy hook: %w", err))
+	}
+	slog.Info("Triggered deploy hook")
+	return nil
+}
+
+// verifyPublished reads each calendar in outs back from snk and checks it's
+// what was written and, for the formats a key decrypts, that it decrypts
+// to a calendar of n events: a truncated or garbled upload could otherwise
+// go unnoticed until someone opens the page.
+func (o *encryptOptions) verifyPublished(ctx context.Context, snk sink.Sink, cfg *config.Config, outs []sink.Output, n int) error {
+	r, ok := snk.(sink.Reader)
+	if !ok || o.template != nil {
+		return nil
+	}
+	type calendar struct {
+		path       string
+		cfg        *crypto.Config
+		passphrase bool
+	}
+	calendars := []calendar{{o.output, cfg.Crypto(), o.passphrase}}
+	if cfg != nil && len(cfg.Tiers) > 0 {
+		calendars = calendars[:0]
+		for _, tier := range cfg.Tiers {
+			calendars = append(calendars, calendar{output.TierPath(tier), cfg.ForTier(tier), false})
+		}
+	}
+	var errs []error
+	for _, c := range calendars {
+		i := slices.IndexFunc(outs, func(out sink.Output) bool { return out.Path == c.path })
+		if i < 0 {
+			continue
+		}
+		if err := o.verifyCalendar(ctx, r, c.path, outs[i].Data, c.cfg, c.passphrase, n); err != nil {
+			errs = append(errs, fmt.Errorf("verifying %s: %w", c.path, err))
+			continue
+		}
+		slog.Debug("Verified output", "path", c.path)
+	}
+	return errors.Join(errs...)
+}
+
+// verifyCalendar checks the calendar published at path; see
+// verifyPublished.
+func (o *encryptOptions) verifyCalendar(ctx context.Context, r sink.Reader, path string, written []byte, cfg *crypto.Config, passphrase bool, n int) error {
+	data, err := r.Read(ctx, path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, written) {
+		return fmt.Errorf("read back %d bytes, not the %d written", len(data), len(written))
+	}
+	// age and box are encrypted to public keys, which can't decrypt them
+	if o.minKeyBits(cfg) == 0 && !(o.format == crypto.CipherAge && passphrase) {
+		return nil
+	}
+	// nor, without their private keys, can HPKE or KMS recipients
+	if held, err := crypto.HoldsRecipient(cfg, passphrase, data); err != nil || !held {
+		return err
+	}
+	plaintext, err := crypto.DecryptCalendar(cfg, passphrase, data)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(plaintext)
+	var cal model.Calendar
+	if err := json.Unmarshal(plaintext, &cal); err != nil {
+		return fmt.Errorf("decrypted payload is not a calendar: %w", err)
+	}
+	if len(cal.Events) != n {
+		return fmt.Errorf("decrypted to %d events, not the %d published", len(cal.Events), n)
+	}
+	return nil
+}
+
+// summarize prints what write would publish.
+func summarize(outs []sink.Output, events []model.Event, format string) {
+	for _, out := range outs {
+		fmt.Printf("Would write %s (%d bytes)\n", out.Path, len(out.Data))
+	}
+	fmt.Printf("%d events, %s\n", len(events), format)
+	if len(events) == 0 {
+		return
+	}
+	first, last := events[0], events[0]
+	for _, e := range events[1:] {
+		if e.Start.Before(first.Start) {
+			first = e
+		}
+		if e.Start.After(last.Start) {
+			last = e
+		}
+	}
+	fmt.Printf("  first: %s %s\n", first.Start.Format(time.RFC3339), first.Title)
+	fmt.Printf("  last:  %s %s\n", last.Start.Format(time.RFC3339), last.Title)
+}
@@ -0,0 +1,67 @@
+//go:build !js
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackdorland/www/internal/tracing"
+)
+
+// registerTracing defines -otlp, which traces each run's stages (fetch,
+// parse, expand, encrypt and upload, per feed and per output) and exports
+// the spans to an OTLP/HTTP collector. It also reads the standard
+// OTEL_EXPORTER_OTLP_HEADERS, for a backend's API key, and
+// OTEL_SERVICE_NAME.
+func registerTracing(fs *flag.FlagSet) {
+	fs.Func("otlp", "export a trace of each run to this OTLP/HTTP collector, like http://localhost:4318 (env OTEL_EXPORTER_OTLP_ENDPOINT)", startTracing)
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		if err := startTracing(endpoint); err != nil {
+			fatal("Invalid OTEL_EXPORTER_OTLP_ENDPOINT", "err", err)
+		}
+	}
+}
+
+// startTracing enables tracing to endpoint.
+func startTracing(endpoint string) error {
+	if u, err := url.Parse(endpoint); err != nil || u.Host == "" {
+		return fmt.Errorf("%q is not a URL", endpoint)
+	}
+	// the headers are comma-separated key=value pairs, URL-encoded
+	header := make(http.Header)
+	for _, pair := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		k, _ = url.QueryUnescape(strings.TrimSpace(k))
+		v, _ = url.QueryUnescape(strings.TrimSpace(v))
+		header.Set(k, v)
+	}
+	tracing.Enable(tracing.Exporter{Endpoint: endpoint, Header: header, Service: envDefault("OTEL_SERVICE_NAME", "calendar-setup")})
+	return nil
+}
+
+// traced runs f under a root span named name and exports the trace. An
+// export that fails is only logged.
+func traced(ctx context.Context, name string, f func(context.Context) error) error {
+	ctx, span := tracing.Start(ctx, name)
+	err := f(ctx)
+	span.End(err)
+	if tracing.Enabled() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := tracing.Flush(flushCtx); err != nil {
+			slog.Warn("Couldn't export the trace", "err", err)
+		}
+	}
+	return err
+}
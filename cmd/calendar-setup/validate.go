@@ -0,0 +1,188 @@
+//go:build !js
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/jackdorland/www/internal/config"
+	"github.com/jackdorland/www/internal/crypto"
+	"github.com/jackdorland/www/internal/output"
+	"github.com/jackdorland/www/internal/source"
+)
+
+// runValidate implements the validate command: check the config file,
+// flags and environment without publishing anything, then print the
+// configuration the pipeline would run with. Secrets are never printed.
+// Every problem found is reported, not just the first.
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	var render renderOptions
+	var enc encryptOptions
+	render.register(fs)
+	enc.register(fs)
+	checkURLs := fs.Bool("check-urls", false, "also fetch and parse each feed")
+	registerLogging(fs)
+	fs.Parse(args)
+
+	var errs []error
+	cfg, loadErr := enc.load()
+	if loadErr != nil {
+		errs = append(errs, loadErr)
+	}
+	for _, w := range crypto.KeyWarnings(cfg.Crypto()) {
+		slog.Warn(w)
+	}
+	if _, err := parseWindow(render.window); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := time.LoadLocation(render.timezone); err != nil {
+		errs = append(errs, fmt.Errorf("invalid timezone: %w", err))
+	}
+
+	var feeds []string
+	for i, raw := range feedSpecs(cfg) {
+		u, err := checkFeed(raw, *checkURLs)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("calendar %d: %w", i+1, err))
+		}
+		feeds = append(feeds, u)
+	}
+
+	if loadErr == nil {
+		view := effectiveConfig(&render, &enc, cfg, feeds)
+		data, err := json.MarshalIndent(view, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	}
+	return withCode(exitConfig, errors.Join(errs...))
+}
+
+// checkFeed checks a feed spec, fetching it if fetch is set, and returns
+// it with any URL path and query, which usually carry the feed's secret
+// token, replaced by "...".
+func checkFeed(spec string, fetch bool) (string, error) {
+	if spec == "" {
+		return "", errors.New("not set")
+	}
+	shown := spec
+	if u, err := url.Parse(spec); err == nil && u.Host != "" {
+		shown = u.Scheme + "://" + u.Host + "/..."
+	}
+	src, err := source.Open(spec)
+	if err != nil || !fetch {
+		return shown, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	raws, err := src.Fetch(ctx)
+	if err != nil {
+		return shown, err
+	}
+	for _, raw := range raws {
+		if _, err := raw.Parse(); err != nil {
+			return shown, err
+		}
+	}
+	return shown, nil
+}
+
+// validatedConfig is the normalized view validate prints: defaults filled
+// in and key material reduced to its type.
+type validatedConfig struct {
+	Profile       string              `json:"profile,omitempty"`
+	Format        string              `json:"format"`
+	Outputs       []string            `json:"outputs"`
+	Sink          string              `json:"sink"`
+	Window        string              `json:"window"`
+	Timezone      string              `json:"timezone"`
+	MinKeyBits    int                 `json:"minKeyBits,omitempty"`
+	Feeds         []string            `json:"feeds"`
+	CurrentKey    string              `json:"currentKey,omitempty"`
+	Keys          []validatedKey      `json:"keys,omitempty"`
+	Recipients    []string            `json:"recipients,omitempty"`
+	KMS           []crypto.KMSConfig  `json:"kms,omitempty"`
+	HPKE          []crypto.HPKEConfig `json:"hpke,omitempty"`
+	AgeRecipients []string            `json:"ageRecipients,omitempty"`
+	BoxPublicKey  string              `json:"boxPublicKey,omitempty"`
+	Tiers         []crypto.TierConfig `json:"tiers,omitempty"`
+	// Notify lists each notifier as "<service> (<on>)"; their URLs and
+	// tokens are secret.
+	Notify []string `json:"notify,omitempty"`
+	// Sentry is set if errors are reported to Sentry; the DSN is secret.
+	Sentry bool              `json:"sentry,omitempty"`
+	Icons  []output.IconRule `json:"icons,omitempty"`
+}
+
+type validatedKey struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+func effectiveConfig(render *renderOptions, enc *encryptOptions, cfg *config.Config, feeds []string) validatedConfig {
+	v := validatedConfig{
+		Profile:    enc.conf.profile,
+		Format:     enc.format,
+		Outputs:    []string{enc.output},
+		Sink:       enc.sink,
+		Window:     render.window,
+		Timezone:   render.timezone,
+		MinKeyBits: enc.minKeyBits(cfg.Crypto()),
+		Feeds:      feeds,
+	}
+	if window, err := parseWindow(render.window); err == nil {
+		v.Window = window.String()
+	}
+	if cfg == nil {
+		if os.Getenv("CAL_KEY") != "" {
+			v.Keys = []validatedKey{{ID: "CAL_KEY", Type: keyType(crypto.KeyConfig{Key: os.Getenv("CAL_KEY")}, enc.passphrase)}}
+		}
+		return v
+	}
+
+	v.CurrentKey = cfg.CurrentKey
+	for _, k := range cfg.Keys {
+		v.Keys = append(v.Keys, validatedKey{ID: k.ID, Type: keyType(k, false)})
+	}
+	v.Recipients = cfg.Recipients
+	v.KMS = cfg.KMS
+	v.HPKE = cfg.HPKE
+	v.AgeRecipients = cfg.AgeRecipients
+	v.BoxPublicKey = cfg.BoxPublicKey
+	for _, n := range cfg.Notify {
+		v.Notify = append(v.Notify, n.Service+" ("+n.When()+")")
+	}
+	v.Sentry = cfg.Sentry != nil
+	v.Icons = cfg.Icons
+	if len(cfg.Tiers) > 0 {
+		v.Outputs = nil
+	}
+	for _, t := range cfg.Tiers {
+		if t.Show == "" {
+			t.Show = crypto.ShowAll
+		}
+		t.Output = output.TierPath(t)
+		v.Tiers = append(v.Tiers, t)
+		v.Outputs = append(v.Outputs, t.Output)
+	}
+	return v
+}
+
+// keyType describes a key without revealing it.
+func keyType(k crypto.KeyConfig, passphrase bool) string {
+	if passphrase || k.Passphrase != "" {
+		return "passphrase"
+	}
+	return fmt.Sprintf("aes-%d", 4*len(k.Key))
+}
@@ -0,0 +1,60 @@
+//go:build !js
+
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackdorland/www/internal/crypto"
+	"github.com/jackdorland/www/internal/model"
+)
+
+// An HPKE-only publisher holds no key that opens what it writes, so
+// -verify can only check the bytes read back, and mustn't fail the run.
+func TestEncryptVerifiesHPKEOnly(t *testing.T) {
+	sk, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"CAL_KEY", "CAL_CONFIG", "CAL_HPKE_RECIPIENTS", "CAL_HPKE_PRIVATE_KEY", "CAL_SIGNING_KEY"} {
+		t.Setenv(v, "")
+	}
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.json")
+	writeJSON(t, config, map[string]any{
+		"hpke": []crypto.HPKEConfig{{PublicKey: hex.EncodeToString(sk.PublicKey().Bytes())}},
+	})
+	calendar := filepath.Join(dir, "calendar.json")
+	events := []model.Event{{Title: "Standup", Start: mustTime(t, "2026-10-15T09:30:00Z"), End: mustTime(t, "2026-10-15T09:45:00Z")}}
+	writeJSON(t, calendar, model.Calendar{Events: events})
+	out := filepath.Join(dir, "cal.aes")
+
+	if err := runEncrypt([]string{"-config", config, "-output", out, "-verify", calendar}); err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	// the recipient can still read it
+	t.Setenv("CAL_HPKE_PRIVATE_KEY", hex.EncodeToString(sk.Bytes()))
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := crypto.DecryptCalendar(nil, false, data)
+	if err != nil {
+		t.Fatalf("decrypting with the HPKE private key: %v", err)
+	}
+	var cal model.Calendar
+	if err := json.Unmarshal(plaintext, &cal); err != nil {
+		t.Fatal(err)
+	}
+	if len(cal.Events) != 1 || cal.Events[0].Title != "Standup" {
+		t.Errorf("decrypted %+v, want the one event", cal.Events)
+	}
+}
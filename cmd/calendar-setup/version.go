@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"github.com/jackdorland/www/internal/version"
+)
+
+// runVersion implements the version command. Each generated calendar
+// records the same build in its "version" field.
+func runVersion(args []string) error {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print as JSON")
+	fs.Parse(args)
+
+	info := version.Get()
+	if !*asJSON {
+		fmt.Println(info)
+		return nil
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -0,0 +1,62 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"encoding/json"
+	"syscall/js"
+
+	"github.com/jackdorland/www/internal/crypto"
+	"github.com/jackdorland/www/internal/model"
+)
+
+// The WebAssembly build (make wasm) replaces the CLI with a single global,
+//
+//	calDecrypt(bytes: Uint8Array, keys: KeyConfig[]) => Promise<string>
+//
+// where keys is the config file's "keys" list, so the browser decodes
+// cal.aes with exactly the code that wrote it. The promise resolves to the
+// normalized calendar JSON.
+func main() {
+	js.Global().Set("calDecrypt", js.FuncOf(func(this js.Value, args []js.Value) any {
+		var data []byte
+		var keys string
+		if len(args) > 0 {
+			data = make([]byte, args[0].Get("length").Int())
+			js.CopyBytesToGo(data, args[0])
+		}
+		if len(args) > 1 {
+			keys = js.Global().Get("JSON").Call("stringify", args[1]).String()
+		}
+
+		// Run off the event loop: KMS unwrapping uses fetch, which would
+		// deadlock if we blocked the calling JS.
+		return js.Global().Get("Promise").New(js.FuncOf(func(this js.Value, p []js.Value) any {
+			resolve, reject := p[0], p[1]
+			go func() {
+				out, err := wasmDecrypt(data, keys)
+				if err != nil {
+					reject.Invoke(js.Global().Get("Error").New(err.Error()))
+					return
+				}
+				resolve.Invoke(string(out))
+			}()
+			return nil
+		}))
+	}))
+	select {}
+}
+
+func wasmDecrypt(data []byte, keys string) ([]byte, error) {
+	cfg := &crypto.Config{}
+	if keys != "" {
+		if err := json.Unmarshal([]byte(keys), &cfg.Keys); err != nil {
+			return nil, err
+		}
+	}
+	plaintext, err := crypto.DecryptCalendar(cfg, false, data)
+	if err != nil {
+		return nil, err
+	}
+	return model.Normalize(plaintext)
+}
@@ -3,6 +3,18 @@ module github.com/jackdorland/www
 go 1.24.4
 
 require (
-	github.com/arran4/golang-ical v0.3.2 // indirect
+	filippo.io/age v1.3.1
+	filippo.io/hpke v0.4.0
+	github.com/arran4/golang-ical v0.3.2
+	github.com/pkg/sftp v1.13.10
 	github.com/teambition/rrule-go v1.8.2
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/crypto v0.46.0
+	golang.org/x/image v0.34.0
+	golang.org/x/text v0.32.0
+)
+
+require (
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/sys v0.39.0 // indirect
 )
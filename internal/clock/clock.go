@@ -0,0 +1,22 @@
+// Package clock provides the time the pipeline treats as now. It's the
+// real time unless pinned with Set, so a run can be reproduced exactly or
+// a past snapshot regenerated.
+package clock
+
+import "time"
+
+var fixed time.Time
+
+// Now returns the pinned time, if any, or the current time.
+func Now() time.Time {
+	if !fixed.IsZero() {
+		return fixed
+	}
+	return time.Now()
+}
+
+// Set pins Now to t; the zero Time unpins it. It's meant to be called once
+// at startup, before anything reads the clock.
+func Set(t time.Time) {
+	fixed = t
+}
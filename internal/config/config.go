@@ -0,0 +1,176 @@
+// Package config reads the JSON file passed with -config: the keys the
+// outputs are encrypted with (see crypto.Config) and everything else about
+// a run, like its feeds, sink and notifiers.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jackdorland/www/internal/crypto"
+	"github.com/jackdorland/www/internal/notify"
+	"github.com/jackdorland/www/internal/output"
+)
+
+// Config is the config file, or one of its profiles.
+type Config struct {
+	crypto.Config
+
+	// Feeds, if set, are the feed specs to publish instead of CALENDAR_1
+	// to CALENDAR_3.
+	Feeds []string `json:"feeds,omitempty"`
+
+	// Output, if set, replaces -output.
+	Output string `json:"output,omitempty"`
+
+	// Sink, if set, replaces -sink.
+	Sink string `json:"sink,omitempty"`
+
+	// Notify lists the ntfy, Pushover, Slack and email notifiers told how
+	// runs went. Their URLs, tokens and passwords may be secret references.
+	Notify []notify.Config `json:"notify,omitempty"`
+
+	// Sentry, if set, is told of panics and of each calendar that fails.
+	Sentry *notify.SentryConfig `json:"sentry,omitempty"`
+
+	// Icons attach icons to events by title, category or calendar, like
+	// ✈️ to travel.
+	Icons []output.IconRule `json:"icons,omitempty"`
+
+	// Profiles are named configs, one of which is chosen with -profile.
+	// A profile shares nothing with the others but the vault section, so
+	// one run can't publish one site's events under another's keys.
+	Profiles map[string]*Config `json:"profiles,omitempty"`
+}
+
+// Crypto returns the key config, or nil if c is nil, which encrypts with
+// the keys in the environment.
+func (c *Config) Crypto() *crypto.Config {
+	if c == nil {
+		return nil
+	}
+	return &c.Config
+}
+
+// Load reads the config file at path. If it has profiles, the one named
+// profile is returned; otherwise profile must be empty.
+func Load(path, profile string) (*Config, error) {
+	file, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := file.profile(profile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// first, so the rest may be vault: references
+	if err := cfg.Config.Resolve(); err != nil {
+		return nil, err
+	}
+	for i := range cfg.Notify {
+		n := &cfg.Notify[i]
+		for _, s := range []*string{&n.URL, &n.Token, &n.User, &n.Password} {
+			if *s, err = crypto.ResolveSecret(*s); err != nil {
+				return nil, fmt.Errorf("notify[%d]: %w", i, err)
+			}
+		}
+	}
+	if cfg.Sentry != nil {
+		if cfg.Sentry.DSN, err = crypto.ResolveSecret(cfg.Sentry.DSN); err != nil {
+			return nil, fmt.Errorf("sentry: %w", err)
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Profiles returns the names of the profiles in the config file at path,
+// sorted, or none if it has none.
+func Profiles(path string) ([]string, error) {
+	file, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	return file.profileNames(), nil
+}
+
+// read reads the config file at path, profiles and all, without resolving
+// its secrets.
+func read(path string) (*Config, error) {
+	data, err := crypto.ReadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	var file Config
+	err = json.Unmarshal(data, &file)
+	crypto.Wipe(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &file, nil
+}
+
+func (c *Config) profileNames() []string {
+	var names []string
+	for n := range c.Profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// profile returns the named profile, with the file's vault section if it
+// has none of its own.
+func (c *Config) profile(name string) (*Config, error) {
+	if len(c.Profiles) == 0 {
+		if name != "" {
+			return nil, fmt.Errorf("no profile %q: the config has no profiles", name)
+		}
+		return c, nil
+	}
+
+	rest := *c
+	rest.Vault, rest.Profiles = nil, nil
+	if !reflect.ValueOf(rest).IsZero() {
+		return nil, fmt.Errorf("only vault may be set outside profiles")
+	}
+	names := c.profileNames()
+	if name == "" {
+		return nil, fmt.Errorf("the config has profiles; choose one of %s with -profile", strings.Join(names, ", "))
+	}
+	p := c.Profiles[name]
+	if p == nil {
+		return nil, fmt.Errorf("no profile %q (have %s)", name, strings.Join(names, ", "))
+	}
+	if len(p.Profiles) > 0 {
+		return nil, fmt.Errorf("profile %q: profiles can't be nested", name)
+	}
+	if p.Vault == nil {
+		p.Vault = c.Vault
+	}
+	return p, nil
+}
+
+func (c *Config) validate() error {
+	for i, n := range c.Notify {
+		if err := n.Validate(); err != nil {
+			return fmt.Errorf("notify[%d]: %w", i, err)
+		}
+	}
+	if c.Sentry != nil {
+		if err := c.Sentry.Validate(); err != nil {
+			return fmt.Errorf("sentry: %w", err)
+		}
+	}
+	if err := c.Config.Validate(); err != nil {
+		return err
+	}
+	return output.ValidateIcons(c.Icons)
+}
@@ -0,0 +1,58 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// ageRecipients collects the age recipients to encrypt to: the config's
+// ageRecipients (falling back to CAL_AGE_RECIPIENTS), or a scrypt
+// passphrase recipient from CAL_KEY when -passphrase is set.
+func ageRecipients(cfg *Config, passphrase bool) ([]age.Recipient, error) {
+	if passphrase {
+		pass, err := secretEnv("CAL_KEY")
+		if err != nil {
+			return nil, err
+		}
+		r, err := age.NewScryptRecipient(pass)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Recipient{r}, nil
+	}
+
+	var lines []string
+	if cfg != nil {
+		lines = cfg.AgeRecipients
+	}
+	if len(lines) == 0 {
+		lines = strings.Fields(strings.ReplaceAll(os.Getenv("CAL_AGE_RECIPIENTS"), ",", " "))
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("no age recipients configured")
+	}
+
+	return age.ParseRecipients(strings.NewReader(strings.Join(lines, "\n")))
+}
+
+// encryptAge writes a standard age file, readable with age -d or rage. The
+// plaintext is encrypted in chunks as marshal writes it.
+func encryptAge(recipients []age.Recipient, marshal func(io.Writer) error) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if err := marshal(w); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,48 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// boxPublicKey reads the X25519 public key for -cipher=box from the config
+// (boxPublicKey) or CAL_BOX_PUBLIC_KEY, hex encoded. Only the matching
+// private key, held by the reader, can open the output.
+func boxPublicKey(cfg *Config) (*[32]byte, error) {
+	v := os.Getenv("CAL_BOX_PUBLIC_KEY")
+	if cfg != nil && cfg.BoxPublicKey != "" {
+		v = cfg.BoxPublicKey
+	}
+	if v == "" {
+		return nil, fmt.Errorf("no box public key configured")
+	}
+
+	b, err := hex.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("decoding box public key: %w", err)
+	}
+	if len(b) != 32 {
+		return nil, fmt.Errorf("box public key must be 32 bytes, got %d", len(b))
+	}
+
+	var pk [32]byte
+	copy(pk[:], b)
+	return &pk, nil
+}
+
+// encryptBox seals plaintext with crypto_box_seal (an ephemeral X25519 key
+// plus XSalsa20-Poly1305), byte-compatible with libsodium's
+// crypto_box_seal_open, behind the container header.
+func encryptBox(pk *[32]byte, plaintext []byte) ([]byte, error) {
+	h := fileHeader{Cipher: cipherIDBox}
+	header, err := h.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	return box.SealAnonymous(header, plaintext, pk, rand.Reader)
+}
@@ -0,0 +1,275 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Config is the key material in the -config file: which keys the outputs
+// are encrypted with and how. The rest of the file is config.Config, which
+// embeds it.
+type Config struct {
+	// CurrentKey is the ID of the key new output is encrypted with.
+	CurrentKey string `json:"currentKey"`
+	// Keys lists the current key and any previous ones still held by
+	// clients, so payloads can be matched to keys by the ID in the header.
+	Keys []KeyConfig `json:"keys"`
+	// Recipients, if set, switches to envelope encryption: every listed
+	// key ID gets its own wrapped copy of the data key, and currentKey is
+	// not used.
+	Recipients []string `json:"recipients,omitempty"`
+
+	// KMS keys each get a copy of the data key wrapped by a cloud KMS,
+	// alongside any local recipients.
+	KMS []KMSConfig `json:"kms,omitempty"`
+
+	// HPKE recipients each get a copy of the data key sealed to their
+	// public key, alongside any other recipients.
+	HPKE []HPKEConfig `json:"hpke,omitempty"`
+
+	// Vault configures access for vault: secret references.
+	Vault *VaultConfig `json:"vault,omitempty"`
+
+	// AgeRecipients are age public keys (age1...) used with -cipher=age.
+	AgeRecipients []string `json:"ageRecipients,omitempty"`
+
+	// BoxPublicKey is the hex X25519 public key used with -cipher=box.
+	BoxPublicKey string `json:"boxPublicKey,omitempty"`
+
+	// Tiers, if set, replace docs/cal.aes with one output per tier, each
+	// under its own key.
+	Tiers []TierConfig `json:"tiers,omitempty"`
+
+	// MinKeyBits is the smallest raw AES key accepted for encryption,
+	// 128 (the default) or 256.
+	MinKeyBits int `json:"minKeyBits,omitempty"`
+}
+
+type KMSConfig struct {
+	// Provider is "aws" or "gcp".
+	Provider string `json:"provider"`
+	// KeyID is an AWS key ARN (or ID/alias with region) or a GCP
+	// projects/.../cryptoKeys/... resource name.
+	KeyID  string `json:"keyId"`
+	Region string `json:"region,omitempty"`
+}
+
+type KeyConfig struct {
+	ID         string `json:"id"`
+	Key        string `json:"key,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+// ReadConfig reads the config file at path, decrypting it if it was sealed
+// with encrypt-config and expanding ${VAR}s, but resolving none of its
+// secrets.
+func ReadConfig(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, containerMagic[:]) {
+		if data, err = decryptConfig(data); err != nil {
+			return nil, fmt.Errorf("decrypting %s: %w", path, err)
+		}
+	}
+	if data, err = expandConfigEnv(data); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// Resolve sets up Vault, if configured, for the secret references that
+// follow, then resolves the keys' references.
+func (c *Config) Resolve() error {
+	if c.Vault != nil {
+		vault = newVaultClient(*c.Vault)
+	}
+
+	// keys may be file:/cmd:/keychain:/env:/vault: references
+	var err error
+	for i := range c.Keys {
+		k := &c.Keys[i]
+		if k.Key, err = ResolveSecret(k.Key); err != nil {
+			return fmt.Errorf("key %q: %w", k.ID, err)
+		}
+		if k.Passphrase, err = ResolveSecret(k.Passphrase); err != nil {
+			return fmt.Errorf("key %q: %w", k.ID, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the keys and how they're used.
+func (c *Config) Validate() error {
+	if c.MinKeyBits != 0 && c.MinKeyBits != 128 && c.MinKeyBits != 256 {
+		return fmt.Errorf("minKeyBits must be 128 or 256")
+	}
+	for i, k := range c.KMS {
+		if k.Provider != kmsAWS && k.Provider != kmsGCP {
+			return fmt.Errorf("kms[%d]: provider must be %q or %q", i, kmsAWS, kmsGCP)
+		}
+		if k.KeyID == "" {
+			return fmt.Errorf("kms[%d]: missing keyId", i)
+		}
+	}
+	for i, h := range c.HPKE {
+		if _, err := newHPKEKey(h); err != nil {
+			return fmt.Errorf("hpke[%d]: %w", i, err)
+		}
+	}
+	if len(c.Keys) == 0 {
+		if c.CurrentKey != "" || len(c.Recipients) > 0 || len(c.Tiers) > 0 {
+			return fmt.Errorf("currentKey, recipients or tiers set but no keys listed")
+		}
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for i, k := range c.Keys {
+		if k.ID == "" {
+			return fmt.Errorf("keys[%d]: missing id", i)
+		}
+		if seen[k.ID] {
+			return fmt.Errorf("keys[%d]: duplicate id %q", i, k.ID)
+		}
+		seen[k.ID] = true
+
+		if (k.Key == "") == (k.Passphrase == "") {
+			return fmt.Errorf("key %q: set exactly one of key or passphrase", k.ID)
+		}
+		if k.Key != "" {
+			if _, err := hex.DecodeString(k.Key); err != nil {
+				return fmt.Errorf("key %q: %w", k.ID, err)
+			}
+		}
+	}
+
+	for _, id := range c.Recipients {
+		if !seen[id] {
+			return fmt.Errorf("recipient %q is not in keys", id)
+		}
+	}
+
+	if err := c.validateTiers(); err != nil {
+		return err
+	}
+
+	if c.CurrentKey == "" {
+		if len(c.Recipients) == 0 && len(c.Tiers) == 0 {
+			return fmt.Errorf("keys listed but none of currentKey, recipients or tiers set")
+		}
+		return nil
+	}
+	if !seen[c.CurrentKey] {
+		return fmt.Errorf("currentKey %q is not in keys", c.CurrentKey)
+	}
+	return nil
+}
+
+// configVar matches ${VAR} in config strings.
+var configVar = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandConfigEnv replaces ${VAR} in every string of the config JSON with
+// the environment variable VAR, so one file can be shared by machines with
+// different URLs and credentials. Unset variables are an error rather
+// than an empty string; "$${" is a literal "${".
+func expandConfigEnv(data []byte) ([]byte, error) {
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	var expand func(v any) any
+	expand = func(v any) any {
+		switch v := v.(type) {
+		case string:
+			parts := strings.Split(v, "$${")
+			for i, p := range parts {
+				parts[i] = configVar.ReplaceAllStringFunc(p, func(m string) string {
+					name := configVar.FindStringSubmatch(m)[1]
+					val, ok := os.LookupEnv(name)
+					if !ok {
+						missing = append(missing, name)
+					}
+					return val
+				})
+			}
+			return strings.Join(parts, "${")
+		case []any:
+			for i := range v {
+				v[i] = expand(v[i])
+			}
+		case map[string]any:
+			for k := range v {
+				v[k] = expand(v[k])
+			}
+		}
+		return v
+	}
+	doc = expand(doc)
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("unset environment variables: %s", strings.Join(missing, ", "))
+	}
+	return json.Marshal(doc)
+}
+
+// key returns the keyring entry with the given ID.
+func (c *Config) key(id string) (KeyConfig, bool) {
+	for _, k := range c.Keys {
+		if k.ID == id {
+			return k, true
+		}
+	}
+	return KeyConfig{}, false
+}
+
+// configKey reads the master key for encrypted config files from
+// CAL_CONFIG_KEY, which may be a secret reference like any other key.
+func configKey() (string, error) {
+	key, err := secretEnv("CAL_CONFIG_KEY")
+	if err != nil {
+		return "", err
+	}
+	if key == "" {
+		return "", fmt.Errorf("CAL_CONFIG_KEY is not set")
+	}
+	if _, err := hex.DecodeString(key); err != nil {
+		return "", fmt.Errorf("CAL_CONFIG_KEY must be hex: %w", err)
+	}
+	return key, nil
+}
+
+// decryptConfig opens a config file written by encrypt-config.
+func decryptConfig(data []byte) ([]byte, error) {
+	key, err := configKey()
+	if err != nil {
+		return nil, err
+	}
+	return decryptContainer([]KeyConfig{{ID: "config", Key: key}}, data)
+}
+
+// EncryptConfig seals a JSON config with CAL_CONFIG_KEY, in the form
+// ReadConfig opens.
+func EncryptConfig(data []byte) ([]byte, error) {
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	hexKey, err := configKey()
+	if err != nil {
+		return nil, err
+	}
+	key, _ := hex.DecodeString(hexKey)
+	defer Wipe(key)
+	return encryptAEAD(encryptionKey{ID: "config", Key: key}, cipherIDAESGCM, writeBytes(data))
+}
@@ -0,0 +1,227 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Container layout (format v3), all integers big-endian:
+//
+//	magic    4 bytes  "CALX"
+//	version  1 byte   containerVersion
+//	cipher   1 byte   cipher ID (cipherIDAESGCM, ...)
+//	length   2 bytes  length of the field section that follows
+//	fields   length bytes of tag (1 byte), size (2 bytes), value
+//	payload  everything after the fields
+//
+// Readers skip fields with tags they don't know, so new fields can be added
+// without a version bump. The whole header is authenticated as associated
+// data where the cipher supports it.
+var containerMagic = [4]byte{'C', 'A', 'L', 'X'}
+
+const containerVersion = 3
+
+// ContainerVersion is the container format version written now. Readers
+// outside this package, like the embed snippet, check it.
+const ContainerVersion = containerVersion
+
+const (
+	cipherIDAESGCM byte = 1
+	cipherIDBox    byte = 2
+	cipherIDAESSIV byte = 3
+	cipherIDChaCha byte = 4 // ChaCha20-Poly1305
+)
+
+const (
+	fieldNonce      byte = 1
+	fieldKeyID      byte = 2
+	fieldKDF        byte = 3
+	fieldRecipient  byte = 4
+	fieldWrappedKey byte = 5
+	fieldKMS        byte = 6
+	fieldHPKESuite  byte = 7
+)
+
+const kdfIDArgon2id byte = 1
+
+type fileHeader struct {
+	Cipher byte
+	Nonce  []byte
+	KeyID  string
+	KDF    *kdfParams
+
+	// Recipients is set for envelope encryption: the payload is sealed
+	// with a random data key, wrapped once per recipient key.
+	Recipients []wrappedKey
+}
+
+type wrappedKey struct {
+	KeyID   string
+	Wrapped []byte
+	KDF     *kdfParams
+	// KMS names the cloud provider holding KeyID when the data key was
+	// wrapped by a KMS rather than with AES-KW.
+	KMS string
+	// HPKE is the ciphersuite when the data key was sealed to an HPKE
+	// public key; Wrapped is then the encapsulated key and ciphertext.
+	HPKE *hpkeSuite
+}
+
+// kdfParams records how a passphrase was stretched into the AES key, so the
+// reader can repeat the derivation without out-of-band configuration.
+type kdfParams struct {
+	Alg     byte
+	Salt    []byte
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+func (h *fileHeader) marshal() ([]byte, error) {
+	var fields []byte
+	if h.Nonce != nil {
+		fields = appendField(fields, fieldNonce, h.Nonce)
+	}
+	if h.KeyID != "" {
+		fields = appendField(fields, fieldKeyID, []byte(h.KeyID))
+	}
+	if h.KDF != nil {
+		fields = appendField(fields, fieldKDF, h.KDF.marshal())
+	}
+	for _, r := range h.Recipients {
+		var rf []byte
+		rf = appendField(rf, fieldKeyID, []byte(r.KeyID))
+		rf = appendField(rf, fieldWrappedKey, r.Wrapped)
+		if r.KDF != nil {
+			rf = appendField(rf, fieldKDF, r.KDF.marshal())
+		}
+		if r.KMS != "" {
+			rf = appendField(rf, fieldKMS, []byte(r.KMS))
+		}
+		if r.HPKE != nil {
+			rf = appendField(rf, fieldHPKESuite, r.HPKE.marshal())
+		}
+		fields = appendField(fields, fieldRecipient, rf)
+	}
+	if len(fields) > 0xffff {
+		return nil, fmt.Errorf("header too large (%d bytes)", len(fields))
+	}
+
+	out := append([]byte(nil), containerMagic[:]...)
+	out = append(out, containerVersion, h.Cipher)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(fields)))
+	return append(out, fields...), nil
+}
+
+func (k *kdfParams) marshal() []byte {
+	out := []byte{k.Alg}
+	out = binary.BigEndian.AppendUint32(out, k.Time)
+	out = binary.BigEndian.AppendUint32(out, k.Memory)
+	out = append(out, k.Threads)
+	return append(out, k.Salt...)
+}
+
+func appendField(b []byte, tag byte, value []byte) []byte {
+	b = append(b, tag)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+	return append(b, value...)
+}
+
+// parseHeader reads a v3 container header, returning it along with the raw
+// header bytes (the associated data) and the payload that follows.
+func parseHeader(data []byte) (*fileHeader, []byte, []byte, error) {
+	if len(data) < 8 || [4]byte(data[:4]) != containerMagic {
+		return nil, nil, nil, fmt.Errorf("not a container file")
+	}
+	if data[4] != containerVersion {
+		return nil, nil, nil, fmt.Errorf("unsupported container version %d", data[4])
+	}
+
+	n := int(binary.BigEndian.Uint16(data[6:8]))
+	if len(data) < 8+n {
+		return nil, nil, nil, fmt.Errorf("truncated header")
+	}
+
+	h := &fileHeader{Cipher: data[5]}
+	err := walkFields(data[8:8+n], func(tag byte, value []byte) error {
+		switch tag {
+		case fieldNonce:
+			h.Nonce = value
+		case fieldKeyID:
+			h.KeyID = string(value)
+		case fieldKDF:
+			kdf, err := parseKDF(value)
+			if err != nil {
+				return err
+			}
+			h.KDF = kdf
+		case fieldRecipient:
+			var r wrappedKey
+			err := walkFields(value, func(tag byte, value []byte) error {
+				switch tag {
+				case fieldKeyID:
+					r.KeyID = string(value)
+				case fieldWrappedKey:
+					r.Wrapped = value
+				case fieldKDF:
+					kdf, err := parseKDF(value)
+					if err != nil {
+						return err
+					}
+					r.KDF = kdf
+				case fieldKMS:
+					r.KMS = string(value)
+				case fieldHPKESuite:
+					suite, err := parseHPKESuite(value)
+					if err != nil {
+						return err
+					}
+					r.HPKE = suite
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			h.Recipients = append(h.Recipients, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return h, data[:8+n], data[8+n:], nil
+}
+
+func parseKDF(b []byte) (*kdfParams, error) {
+	if len(b) < 10 {
+		return nil, fmt.Errorf("truncated KDF field")
+	}
+	return &kdfParams{
+		Alg:     b[0],
+		Time:    binary.BigEndian.Uint32(b[1:5]),
+		Memory:  binary.BigEndian.Uint32(b[5:9]),
+		Threads: b[9],
+		Salt:    b[10:],
+	}, nil
+}
+
+// walkFields calls fn for each tag/length/value field in b, including ones
+// with unknown tags so the caller decides what to skip.
+func walkFields(b []byte, fn func(tag byte, value []byte) error) error {
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return fmt.Errorf("truncated field")
+		}
+		tag, n := b[0], int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+n {
+			return fmt.Errorf("truncated field %d", tag)
+		}
+		if err := fn(tag, b[3:3+n]); err != nil {
+			return err
+		}
+		b = b[3+n:]
+	}
+	return nil
+}
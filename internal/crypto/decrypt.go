@@ -0,0 +1,464 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// DecryptCalendar detects which format data is in and decrypts it with
+// whichever configured key fits.
+func DecryptCalendar(cfg *Config, passphrase bool, data []byte) ([]byte, error) {
+	keys, err := keyCandidates(cfg, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(data, containerMagic[:]):
+		return decryptContainer(keys, data)
+	case bytes.HasPrefix(data, []byte("age-encryption.org/")):
+		return decryptAge(passphrase, data)
+	case bytes.HasPrefix(data, []byte(`{"v":2,`)):
+		return decryptV2(keys, data)
+	case bytes.HasPrefix(data, []byte("{")):
+		return decryptWebCrypto(keys, data)
+	case bytes.HasPrefix(data, []byte("eyJ")) && bytes.Count(data, []byte(".")) == 4:
+		// a JWE's protected header is base64url JSON, so starts eyJ; an
+		// aes-ctr payload, after its hex IV, can hold any four dots
+		return decryptJWE(keys, data)
+	}
+	return decryptCTR(keys, data)
+}
+
+// HoldsRecipient reports whether a key this process holds, in cfg or the
+// environment, is one of the recipients data was encrypted to: a keyring
+// key or an HPKE private key. A file that isn't sealed to recipients is
+// under a keyring key, so counts. KMS recipients don't, since wrapping a
+// data key with a KMS key doesn't mean being allowed to unwrap it.
+func HoldsRecipient(cfg *Config, passphrase bool, data []byte) (bool, error) {
+	if !bytes.HasPrefix(data, containerMagic[:]) {
+		return true, nil
+	}
+	h, _, _, err := parseHeader(data)
+	if err != nil || len(h.Recipients) == 0 {
+		return err == nil, err
+	}
+	keys, err := keyCandidates(cfg, passphrase)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range h.Recipients {
+		switch {
+		case r.HPKE != nil:
+			if dataKey, err := hpkeUnwrap(r); err == nil {
+				Wipe(dataKey)
+				return true, nil
+			}
+		case r.KMS == "":
+			if len(withID(keys, r.KeyID)) > 0 {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// keyCandidates lists every symmetric key the reader might hold: the config
+// keyring, then CAL_KEY.
+func keyCandidates(cfg *Config, passphrase bool) ([]KeyConfig, error) {
+	var keys []KeyConfig
+	if cfg != nil {
+		keys = append(keys, cfg.Keys...)
+	}
+
+	v, err := secretEnv("CAL_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if v != "" {
+		k := KeyConfig{ID: os.Getenv("CAL_KEY_ID")}
+		if passphrase {
+			k.Passphrase = v
+		} else {
+			k.Key = v
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// withID narrows keys to those matching a key ID from the file, if it has
+// one. Keys without an ID are always kept since they might be the one.
+func withID(keys []KeyConfig, id string) []KeyConfig {
+	if id == "" {
+		return keys
+	}
+
+	var out []KeyConfig
+	for _, k := range keys {
+		if k.ID == id || k.ID == "" {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// keyBytes turns a candidate into AES key bytes, running the passphrase
+// through the KDF recorded in the file when there is one.
+func keyBytes(k KeyConfig, kdf *kdfParams) ([]byte, error) {
+	if kdf == nil {
+		if k.Key == "" {
+			return nil, fmt.Errorf("key %q is a passphrase but the file has no KDF parameters", k.ID)
+		}
+		return hex.DecodeString(k.Key)
+	}
+
+	if kdf.Alg != kdfIDArgon2id {
+		return nil, fmt.Errorf("unknown KDF %d", kdf.Alg)
+	}
+	if k.Passphrase == "" {
+		return nil, fmt.Errorf("file was encrypted with a passphrase but key %q is a raw key", k.ID)
+	}
+	return argon2.IDKey([]byte(k.Passphrase), kdf.Salt, kdf.Time, kdf.Memory, kdf.Threads, argonKeyLen), nil
+}
+
+// errNoKey is returned when none of the configured keys opens the file.
+var errNoKey = errors.New("no configured key could decrypt the file")
+
+func decryptContainer(keys []KeyConfig, data []byte) ([]byte, error) {
+	h, header, payload, err := parseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch h.Cipher {
+	case cipherIDAESGCM, cipherIDChaCha:
+		return openAEADHeader(keys, h, header, payload)
+	case cipherIDAESSIV:
+		for _, k := range withID(keys, h.KeyID) {
+			key, err := keyBytes(k, h.KDF)
+			if err != nil {
+				continue
+			}
+			derived, err := sivKey(key)
+			Wipe(key)
+			if err != nil {
+				continue
+			}
+			plaintext, err := sivOpen(derived, payload, header)
+			Wipe(derived)
+			if err == nil {
+				return plaintext, nil
+			}
+		}
+		return nil, errNoKey
+	case cipherIDBox:
+		return openBox(payload)
+	}
+	return nil, fmt.Errorf("unknown cipher ID %d", h.Cipher)
+}
+
+// openAEADHeader opens an AES-GCM or ChaCha20-Poly1305 payload described by
+// h, either directly with a matching key or by unwrapping one of the
+// recipient data keys.
+func openAEADHeader(keys []KeyConfig, h *fileHeader, aad, payload []byte) ([]byte, error) {
+	if len(h.Recipients) == 0 {
+		for _, k := range withID(keys, h.KeyID) {
+			key, err := keyBytes(k, h.KDF)
+			if err != nil {
+				continue
+			}
+			plaintext, err := openAEAD(h.Cipher, key, h.Nonce, payload, aad)
+			Wipe(key)
+			if err == nil {
+				return plaintext, nil
+			}
+		}
+		return nil, errNoKey
+	}
+
+	for _, r := range h.Recipients {
+		if r.HPKE != nil {
+			dataKey, err := hpkeUnwrap(r)
+			if err != nil {
+				continue
+			}
+			defer Wipe(dataKey)
+			return openAEAD(h.Cipher, dataKey, h.Nonce, payload, aad)
+		}
+		if r.KMS != "" {
+			dataKey, err := kmsUnwrap(r)
+			if err != nil {
+				slog.Warn("Skipping KMS recipient", "kms", r.KMS, "key_id", r.KeyID, "err", err)
+				continue
+			}
+			defer Wipe(dataKey)
+			return openAEAD(h.Cipher, dataKey, h.Nonce, payload, aad)
+		}
+
+		for _, k := range withID(keys, r.KeyID) {
+			kek, err := keyBytes(k, r.KDF)
+			if err != nil {
+				continue
+			}
+			dataKey, err := unwrapKey(kek, r.Wrapped)
+			Wipe(kek)
+			if err != nil {
+				continue
+			}
+			defer Wipe(dataKey)
+			return openAEAD(h.Cipher, dataKey, h.Nonce, payload, aad)
+		}
+	}
+	return nil, errNoKey
+}
+
+func openAEAD(cipherID byte, key, nonce, sealed, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(cipherID, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("bad nonce length %d", len(nonce))
+	}
+
+	return aead.Open(nil, nonce, sealed, aad)
+}
+
+// openBox opens a crypto_box_seal payload with CAL_BOX_PRIVATE_KEY.
+func openBox(sealed []byte) ([]byte, error) {
+	v, err := secretEnv("CAL_BOX_PRIVATE_KEY")
+	if err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(v)
+	if err != nil || len(b) != 32 {
+		return nil, fmt.Errorf("CAL_BOX_PRIVATE_KEY must be a hex 32-byte X25519 private key")
+	}
+
+	var sk, pk [32]byte
+	copy(sk[:], b)
+	Wipe(b)
+	defer Wipe(sk[:])
+	pub, err := curve25519.X25519(sk[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	copy(pk[:], pub)
+
+	plaintext, ok := box.OpenAnonymous(nil, sealed, &pk, &sk)
+	if !ok {
+		return nil, errNoKey
+	}
+	return plaintext, nil
+}
+
+// decryptAge opens an age file with the identities in CAL_AGE_IDENTITY, or
+// CAL_KEY as a scrypt passphrase.
+func decryptAge(passphrase bool, data []byte) ([]byte, error) {
+	var identities []age.Identity
+	if passphrase {
+		pass, err := secretEnv("CAL_KEY")
+		if err != nil {
+			return nil, err
+		}
+		id, err := age.NewScryptIdentity(pass)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, id)
+	} else {
+		v, err := secretEnv("CAL_AGE_IDENTITY")
+		if err != nil {
+			return nil, err
+		}
+		identities, err = age.ParseIdentities(strings.NewReader(v))
+		if err != nil {
+			return nil, fmt.Errorf("parsing CAL_AGE_IDENTITY: %w", err)
+		}
+	}
+
+	r, err := age.Decrypt(bytes.NewReader(data), identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// v2Header is the JSON header line written before the binary container
+// existed.
+type v2Header struct {
+	KeyID string `json:"kid"`
+	Nonce string `json:"nonce"`
+	KDF   *struct {
+		Salt    string `json:"salt"`
+		Time    uint32 `json:"t"`
+		Memory  uint32 `json:"m"`
+		Threads uint8  `json:"p"`
+	} `json:"kdf"`
+	Recipients []struct {
+		KeyID string `json:"kid"`
+		Key   string `json:"key"`
+	} `json:"recipients"`
+}
+
+func decryptV2(keys []KeyConfig, data []byte) ([]byte, error) {
+	line, sealed, _ := bytes.Cut(data, []byte("\n"))
+
+	var v v2Header
+	if err := json.Unmarshal(line, &v); err != nil {
+		return nil, fmt.Errorf("parsing header: %w", err)
+	}
+
+	h := &fileHeader{Cipher: cipherIDAESGCM, KeyID: v.KeyID}
+	var err error
+	if h.Nonce, err = hex.DecodeString(v.Nonce); err != nil {
+		return nil, fmt.Errorf("decoding nonce: %w", err)
+	}
+	if v.KDF != nil {
+		salt, err := hex.DecodeString(v.KDF.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("decoding salt: %w", err)
+		}
+		h.KDF = &kdfParams{Alg: kdfIDArgon2id, Salt: salt, Time: v.KDF.Time, Memory: v.KDF.Memory, Threads: v.KDF.Threads}
+	}
+	for _, r := range v.Recipients {
+		wrapped, err := hex.DecodeString(r.Key)
+		if err != nil {
+			return nil, fmt.Errorf("decoding wrapped key: %w", err)
+		}
+		h.Recipients = append(h.Recipients, wrappedKey{KeyID: r.KeyID, Wrapped: wrapped})
+	}
+
+	return openAEADHeader(keys, h, line, sealed)
+}
+
+func decryptWebCrypto(keys []KeyConfig, data []byte) ([]byte, error) {
+	var p webCryptoPayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parsing webcrypto payload: %w", err)
+	}
+
+	iv, err := base64.StdEncoding.DecodeString(p.IV)
+	if err != nil {
+		return nil, fmt.Errorf("decoding iv: %w", err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(p.Data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding data: %w", err)
+	}
+
+	return openAEADHeader(keys, &fileHeader{Cipher: cipherIDAESGCM, KeyID: p.KeyID, Nonce: iv}, nil, sealed)
+}
+
+func decryptJWE(keys []KeyConfig, data []byte) ([]byte, error) {
+	parts := strings.Split(strings.TrimSpace(string(data)), ".")
+	b64 := base64.RawURLEncoding
+
+	header, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("decoding protected header: %w", err)
+	}
+	var h jweHeader
+	if err := json.Unmarshal(header, &h); err != nil {
+		return nil, fmt.Errorf("parsing protected header: %w", err)
+	}
+	if h.Alg != "dir" {
+		return nil, fmt.Errorf("unsupported JWE alg %q", h.Alg)
+	}
+
+	iv, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decoding iv: %w", err)
+	}
+	ciphertext, err := b64.DecodeString(parts[3])
+	if err != nil {
+		return nil, fmt.Errorf("decoding ciphertext: %w", err)
+	}
+	tag, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return nil, fmt.Errorf("decoding tag: %w", err)
+	}
+
+	fh := &fileHeader{Cipher: cipherIDAESGCM, KeyID: h.KeyID, Nonce: iv}
+	return openAEADHeader(keys, fh, []byte(parts[0]), append(ciphertext, tag...))
+}
+
+// decryptCTR reads the legacy layout. CTR can't tell a wrong key from a
+// right one, so this uses the first raw key and relies on the MAC (when
+// CAL_MAC_KEY is set) or a JSON check to catch mistakes.
+func decryptCTR(keys []KeyConfig, data []byte) ([]byte, error) {
+	ivHex, ciphertext, ok := bytes.Cut(data, []byte("\n"))
+	if !ok || len(ivHex) != 2*aes.BlockSize {
+		return nil, fmt.Errorf("unrecognized file format")
+	}
+
+	v, err := secretEnv("CAL_MAC_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if v != "" {
+		macKey, err := hex.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("decoding MAC key: %w", err)
+		}
+		if len(data) < len(ivHex)+1+sha256.Size {
+			return nil, fmt.Errorf("file too short for a MAC")
+		}
+
+		body, tag := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+		mac := hmac.New(sha256.New, macKey)
+		Wipe(macKey)
+		mac.Write(body)
+		if !hmac.Equal(mac.Sum(nil), tag) {
+			return nil, fmt.Errorf("MAC mismatch: file was modified or truncated")
+		}
+		ciphertext = ciphertext[:len(ciphertext)-sha256.Size]
+	}
+
+	iv, err := hex.DecodeString(string(ivHex))
+	if err != nil {
+		return nil, fmt.Errorf("decoding IV: %w", err)
+	}
+
+	for _, k := range keys {
+		if k.Key == "" {
+			continue
+		}
+		key, err := hex.DecodeString(k.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		block, err := aes.NewCipher(key)
+		Wipe(key)
+		if err != nil {
+			return nil, err
+		}
+		plaintext := make([]byte, len(ciphertext))
+		cipher.NewCTR(block, iv).XORKeyStream(plaintext, ciphertext)
+		if !json.Valid(plaintext) {
+			Wipe(plaintext)
+			return nil, fmt.Errorf("decrypted payload is not JSON (wrong key?)")
+		}
+		return plaintext, nil
+	}
+	return nil, errNoKey
+}
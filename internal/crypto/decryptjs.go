@@ -0,0 +1,117 @@
+package crypto
+
+import (
+	"bytes"
+	"text/template"
+)
+
+// decrypt.js is generated from the same constants the encryptor uses, so
+// the browser side can't drift from the container format. It handles the
+// AES-GCM container (direct key or AES-KW recipients), which is everything
+// SubtleCrypto can do natively.
+var decryptJSTemplate = template.Must(template.New("decrypt.js").Parse(`// Code generated by calendar-setup; DO NOT EDIT.
+//
+// Decrypts cal.aes (container v{{.Version}}, AES-GCM) with WebCrypto:
+//
+//   import { DecryptCalendar } from "./decrypt.js";
+//   const buf = await (await fetch("cal.aes")).arrayBuffer();
+//   const cal = await DecryptCalendar(buf, { "key-id": "hex key" });
+//
+// keys maps key IDs to hex AES keys; a plain hex string is a key with no ID.
+
+const MAGIC = [{{range $i, $b := .Magic}}{{if $i}}, {{end}}{{$b}}{{end}}];
+const VERSION = {{.Version}};
+const CIPHER_AES_GCM = {{.CipherAESGCM}};
+const FIELD_NONCE = {{.FieldNonce}};
+const FIELD_KEY_ID = {{.FieldKeyID}};
+const FIELD_KDF = {{.FieldKDF}};
+const FIELD_RECIPIENT = {{.FieldRecipient}};
+const FIELD_WRAPPED_KEY = {{.FieldWrappedKey}};
+const FIELD_KMS = {{.FieldKMS}};
+
+function fields(bytes) {
+  const view = new DataView(bytes.buffer, bytes.byteOffset, bytes.byteLength);
+  const out = [];
+  for (let o = 0; o < bytes.length; ) {
+    const tag = bytes[o], n = view.getUint16(o + 1);
+    out.push([tag, bytes.subarray(o + 3, o + 3 + n)]);
+    o += 3 + n;
+  }
+  return out;
+}
+
+function field(list, tag) {
+  const f = list.find(([t]) => t === tag);
+  return f && f[1];
+}
+
+function hex(s) {
+  return Uint8Array.from(s.match(/../g), (b) => parseInt(b, 16));
+}
+
+export async function DecryptCalendar(buffer, keys) {
+  const data = new Uint8Array(buffer);
+  if (MAGIC.some((b, i) => data[i] !== b)) throw new Error("not a cal.aes container");
+  if (data[4] !== VERSION) throw new Error("unsupported container version " + data[4]);
+  if (data[5] !== CIPHER_AES_GCM) throw new Error("unsupported cipher " + data[5]);
+
+  const len = new DataView(data.buffer, data.byteOffset).getUint16(6);
+  const header = data.subarray(0, 8 + len);
+  const payload = data.subarray(8 + len);
+  const list = fields(data.subarray(8, 8 + len));
+  if (field(list, FIELD_KDF)) throw new Error("passphrase-derived keys need Argon2id, which WebCrypto lacks");
+
+  if (typeof keys === "string") keys = { "": keys };
+  const dec = new TextDecoder();
+  const candidates = (kid) => Object.entries(keys).filter(([id]) => !kid || !id || id === kid).map(([, k]) => hex(k));
+
+  let key;
+  const recipients = list.filter(([t]) => t === FIELD_RECIPIENT).map(([, v]) => fields(v));
+  if (recipients.length) {
+    for (const r of recipients) {
+      if (field(r, FIELD_KMS) || field(r, FIELD_KDF)) continue;
+      for (const k of candidates(dec.decode(field(r, FIELD_KEY_ID)))) {
+        try {
+          const kek = await crypto.subtle.importKey("raw", k, "AES-KW", false, ["unwrapKey"]);
+          key = await crypto.subtle.unwrapKey("raw", field(r, FIELD_WRAPPED_KEY), kek, "AES-KW", "AES-GCM", false, ["decrypt"]);
+          break;
+        } catch {}
+      }
+      if (key) break;
+    }
+  } else {
+    const kid = field(list, FIELD_KEY_ID);
+    for (const k of candidates(kid && dec.decode(kid))) {
+      const candidate = await crypto.subtle.importKey("raw", k, "AES-GCM", false, ["decrypt"]);
+      try {
+        const plain = await crypto.subtle.decrypt({ name: "AES-GCM", iv: field(list, FIELD_NONCE), additionalData: header }, candidate, payload);
+        return JSON.parse(dec.decode(plain));
+      } catch {}
+    }
+  }
+  if (!key) throw new Error("no key could decrypt cal.aes");
+
+  const plain = await crypto.subtle.decrypt({ name: "AES-GCM", iv: field(list, FIELD_NONCE), additionalData: header }, key, payload);
+  return JSON.parse(dec.decode(plain));
+}
+`))
+
+// DecryptJS renders decrypt.js.
+func DecryptJS() ([]byte, error) {
+	var buf bytes.Buffer
+	err := decryptJSTemplate.Execute(&buf, map[string]any{
+		"Magic":           containerMagic,
+		"Version":         containerVersion,
+		"CipherAESGCM":    cipherIDAESGCM,
+		"FieldNonce":      fieldNonce,
+		"FieldKeyID":      fieldKeyID,
+		"FieldKDF":        fieldKDF,
+		"FieldRecipient":  fieldRecipient,
+		"FieldWrappedKey": fieldWrappedKey,
+		"FieldKMS":        fieldKMS,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
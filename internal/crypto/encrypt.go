@@ -0,0 +1,363 @@
+// Package crypto holds everything to do with keys and ciphertext: the
+// output formats, key resolution and secret references, envelope
+// recipients, signing, and the config file that describes them.
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// docs/cal.aes layouts:
+//
+//	legacy (v1): hex IV line, then raw AES-CTR ciphertext, optionally
+//	             followed by an HMAC-SHA256 tag over everything before it
+//	v2:          JSON header line, then AES-GCM ciphertext (no longer written)
+//	v3:          binary container header (see container.go), then the
+//	             AES-GCM or ChaCha20-Poly1305 ciphertext with the tag
+//	             appended, the AES-SIV synthetic IV and ciphertext, or a
+//	             crypto_box_seal box
+//
+// age output is a plain age file with no header of ours, webcrypto output
+// is a JSON document (see webcrypto.go) and jwe output is an RFC 7516
+// compact serialization.
+const (
+	CipherAESGCM    = "aes-gcm"
+	CipherAESCTR    = "aes-ctr"
+	CipherAESSIV    = "aes-siv"
+	CipherChaCha    = "chacha20-poly1305"
+	CipherAge       = "age"
+	CipherBox       = "box"
+	CipherWebCrypto = "webcrypto"
+	CipherJWE       = "jwe"
+)
+
+// Argon2id parameters for passphrase keys, per the RFC 9106 second
+// recommended option.
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	argonKeyLen  = 32
+)
+
+// deriveKey stretches a passphrase into a 256-bit key with a fresh salt.
+func deriveKey(passphrase []byte) ([]byte, *kdfParams, error) {
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, nil, fmt.Errorf("generating salt: %w", err)
+	}
+
+	params := &kdfParams{
+		Alg:     kdfIDArgon2id,
+		Salt:    salt,
+		Time:    argonTime,
+		Memory:  argonMemory,
+		Threads: argonThreads,
+	}
+	key := argon2.IDKey(passphrase, salt, params.Time, params.Memory, params.Threads, argonKeyLen)
+	return key, params, nil
+}
+
+// encryptAEAD seals the plaintext marshal writes under k with the given
+// container cipher, recording the key's ID and KDF parameters (if any) in
+// the header.
+func encryptAEAD(k encryptionKey, cipherID byte, marshal func(io.Writer) error) ([]byte, error) {
+	return sealAEAD(k.Key, fileHeader{Cipher: cipherID, KeyID: k.ID, KDF: k.KDF}, marshal)
+}
+
+// writeBytes is a marshal function for plaintext that's already in memory.
+func writeBytes(plaintext []byte) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := w.Write(plaintext)
+		return err
+	}
+}
+
+// marshalBytes runs marshal into memory, for the formats that need all of
+// the plaintext at once.
+func marshalBytes(marshal func(io.Writer) error) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := marshal(&buf); err != nil {
+		Wipe(buf.Bytes())
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// keyWrapper produces one recipient's wrapped copy of the data key.
+type keyWrapper interface {
+	wrap(dataKey []byte) (wrappedKey, error)
+}
+
+func (k encryptionKey) wrap(dataKey []byte) (wrappedKey, error) {
+	wrapped, err := wrapKey(k.Key, dataKey)
+	if err != nil {
+		return wrappedKey{}, fmt.Errorf("wrapping data key for %q: %w", k.ID, err)
+	}
+	return wrappedKey{KeyID: k.ID, Wrapped: wrapped, KDF: k.KDF}, nil
+}
+
+// encryptEnvelope seals the plaintext marshal writes under a fresh data key
+// and wraps that key for each recipient, so any one of their keys can open
+// the file and a recipient is revoked by dropping them from the list.
+func encryptEnvelope(recipients []keyWrapper, cipherID byte, marshal func(io.Writer) error) ([]byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("generating data key: %w", err)
+	}
+	defer Wipe(dataKey)
+
+	h := fileHeader{Cipher: cipherID}
+	for _, r := range recipients {
+		w, err := r.wrap(dataKey)
+		if err != nil {
+			return nil, err
+		}
+		h.Recipients = append(h.Recipients, w)
+	}
+
+	return sealAEAD(dataKey, h, marshal)
+}
+
+// newAEAD returns the AEAD for a container cipher ID.
+func newAEAD(cipherID byte, key []byte) (cipher.AEAD, error) {
+	switch cipherID {
+	case cipherIDAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("creating cipher: %w", err)
+		}
+		return cipher.NewGCM(block)
+	case cipherIDChaCha:
+		if len(key) != chacha20poly1305.KeySize {
+			return nil, fmt.Errorf("chacha20-poly1305 needs a 256-bit key, got %d bits", 8*len(key))
+		}
+		return chacha20poly1305.New(key)
+	}
+	return nil, fmt.Errorf("cipher ID %d is not an AEAD", cipherID)
+}
+
+// sealAEAD fills in the nonce of h and writes it as the container header in
+// front of the payload sealed with h's cipher. The plaintext is marshalled
+// straight after the header and sealed where it lies, so a large calendar
+// is only held once.
+func sealAEAD(key []byte, h fileHeader, marshal func(io.Writer) error) ([]byte, error) {
+	aead, err := newAEAD(h.Cipher, key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+
+	h.Nonce = nonce
+	header, err := h.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(header)
+	if err := marshal(buf); err != nil {
+		Wipe(buf.Bytes())
+		return nil, err
+	}
+	// room for the tag, so Seal needn't copy
+	buf.Grow(aead.Overhead())
+	out := buf.Bytes()
+	header, plaintext := out[:len(header)], out[len(header):]
+	// the header is authenticated too, so it can't be swapped out
+	sealed := aead.Seal(plaintext[:0], nonce, plaintext, header)
+	Wipe(nonce)
+	return out[:len(header)+len(sealed)], nil
+}
+
+// encryptCTR writes the pre-GCM layout, kept so frontends can be migrated
+// before the switch. On its own it has no integrity protection; pass a
+// macKey to append an encrypt-then-MAC tag the frontend can check first.
+// Like sealAEAD, it encrypts the plaintext in place after the IV line.
+func encryptCTR(key, macKey []byte, marshal func(io.Writer) error) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, fmt.Errorf("generating IV: %w", err)
+	}
+
+	stream := cipher.NewCTR(block, iv)
+	buf := bytes.NewBuffer(hex.AppendEncode(nil, iv))
+	buf.WriteByte('\n')
+	Wipe(iv)
+	ivLine := buf.Len()
+	if err := marshal(buf); err != nil {
+		Wipe(buf.Bytes())
+		return nil, err
+	}
+	if macKey != nil {
+		buf.Grow(sha256.Size)
+	}
+	out := buf.Bytes()
+	stream.XORKeyStream(out[ivLine:], out[ivLine:])
+	if macKey == nil {
+		return out, nil
+	}
+
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(out)
+	return mac.Sum(out), nil
+}
+
+// EncryptCalendar encrypts the plaintext marshal writes with the named
+// cipher, using whichever keys or recipients cfg and the environment
+// configure for it. The AEAD, CTR and age formats encrypt it as it's
+// written or where it lies, so a calendar of tens of MB isn't also held as
+// plaintext; the rest collect it first.
+func EncryptCalendar(cfg *Config, cipherName string, passphrase bool, marshal func(io.Writer) error) ([]byte, error) {
+	switch cipherName {
+	case CipherAESGCM, CipherChaCha, CipherAESCTR:
+		if !useEnvelope(cfg) {
+			return encryptWithCurrentKey(cfg, passphrase, cipherName, marshal)
+		}
+		if cipherName == CipherAESCTR {
+			return nil, fmt.Errorf("aes-ctr can't be used with envelope recipients")
+		}
+
+		recipients, err := recipientKeys(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("loading recipient keys: %w", err)
+		}
+		defer func() {
+			for _, r := range recipients {
+				if k, ok := r.(encryptionKey); ok {
+					Wipe(k.Key)
+				}
+			}
+		}()
+		return encryptEnvelope(recipients, aeadCipherID(cipherName), marshal)
+
+	case CipherAge:
+		recipients, err := ageRecipients(cfg, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("loading age recipients: %w", err)
+		}
+		return encryptAge(recipients, marshal)
+
+	case CipherAESSIV:
+		if useEnvelope(cfg) {
+			return nil, fmt.Errorf("aes-siv can't be used with envelope recipients")
+		}
+
+		key, err := currentKey(cfg, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("loading key: %w", err)
+		}
+		defer Wipe(key.Key)
+		plaintext, err := marshalBytes(marshal)
+		if err != nil {
+			return nil, err
+		}
+		defer Wipe(plaintext)
+		return encryptSIV(key, plaintext)
+
+	case CipherWebCrypto, CipherJWE:
+		if useEnvelope(cfg) {
+			return nil, fmt.Errorf("%s output can't be used with envelope recipients", cipherName)
+		}
+
+		key, err := currentKey(cfg, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("loading key: %w", err)
+		}
+		defer Wipe(key.Key)
+		plaintext, err := marshalBytes(marshal)
+		if err != nil {
+			return nil, err
+		}
+		defer Wipe(plaintext)
+		if cipherName == CipherJWE {
+			return encryptJWE(key, plaintext)
+		}
+		return encryptWebCrypto(key, plaintext)
+
+	case CipherBox:
+		pk, err := boxPublicKey(cfg)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := marshalBytes(marshal)
+		if err != nil {
+			return nil, err
+		}
+		defer Wipe(plaintext)
+		return encryptBox(pk, plaintext)
+	}
+
+	return nil, fmt.Errorf("unknown cipher %q", cipherName)
+}
+
+// aeadCipherID maps an AEAD -cipher name to its container cipher ID.
+func aeadCipherID(cipherName string) byte {
+	if cipherName == CipherChaCha {
+		return cipherIDChaCha
+	}
+	return cipherIDAESGCM
+}
+
+// encryptWithCurrentKey handles the single-key formats: AES-GCM or
+// ChaCha20-Poly1305, or the legacy CTR layout with its optional MAC.
+func encryptWithCurrentKey(cfg *Config, passphrase bool, cipherName string, marshal func(io.Writer) error) ([]byte, error) {
+	key, err := currentKey(cfg, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("loading key: %w", err)
+	}
+	defer Wipe(key.Key)
+	if cipherName != CipherAESCTR {
+		if os.Getenv("CAL_MAC_KEY") != "" {
+			slog.Warn("CAL_MAC_KEY is only used with aes-ctr; this format is already authenticated", "format", cipherName)
+		}
+		return encryptAEAD(key, aeadCipherID(cipherName), marshal)
+	}
+
+	if key.KDF != nil || key.ID != "" {
+		return nil, fmt.Errorf("aes-ctr has no header to record a key ID or KDF parameters in")
+	}
+
+	// optional encrypt-then-MAC key for the CTR format
+	var macKey []byte
+	v, err := secretEnv("CAL_MAC_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if v != "" {
+		macKey, err = hex.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("decoding MAC key: %w", err)
+		}
+		defer Wipe(macKey)
+		if len(macKey) < 16 {
+			return nil, fmt.Errorf("CAL_MAC_KEY must be at least 128 bits")
+		}
+		if bytes.Equal(macKey, key.Key) {
+			return nil, fmt.Errorf("CAL_MAC_KEY must differ from CAL_KEY")
+		}
+	}
+
+	return encryptCTR(key.Key, macKey, marshal)
+}
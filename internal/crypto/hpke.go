@@ -0,0 +1,185 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"filippo.io/hpke"
+)
+
+// HPKE (RFC 9180) recipients get the envelope data key sealed to their
+// public key, so the machine producing cal.aes holds nothing that can read
+// it. Each recipient entry records its ciphersuite, so readers pick the
+// KEM, KDF and AEAD from the header and new suites need no format change.
+
+// hpkeInfo binds wrapped keys to this use, so they can't be replayed into
+// some other protocol using the same recipient key.
+var hpkeInfo = []byte("cal.aes data key")
+
+// HPKEConfig is one HPKE recipient. KEM, KDF and AEAD default to the
+// RFC 9180 X25519/HKDF-SHA256 base suite with AES-256-GCM.
+type HPKEConfig struct {
+	// PublicKey is the KEM's serialized public key in hex.
+	PublicKey string `json:"publicKey"`
+	// KEM is x25519, p256, p384 or p521.
+	KEM string `json:"kem,omitempty"`
+	// KDF is hkdf-sha256, hkdf-sha384 or hkdf-sha512.
+	KDF string `json:"kdf,omitempty"`
+	// AEAD is aes-128-gcm, aes-256-gcm or chacha20-poly1305.
+	AEAD string `json:"aead,omitempty"`
+}
+
+var (
+	hpkeKEMs  = map[string]uint16{"": 0x0020, "x25519": 0x0020, "p256": 0x0010, "p384": 0x0011, "p521": 0x0012}
+	hpkeKDFs  = map[string]uint16{"": 0x0001, "hkdf-sha256": 0x0001, "hkdf-sha384": 0x0002, "hkdf-sha512": 0x0003}
+	hpkeAEADs = map[string]uint16{"": 0x0002, "aes-128-gcm": 0x0001, "aes-256-gcm": 0x0002, "chacha20-poly1305": 0x0003}
+)
+
+// hpkeSuite is a KEM, KDF and AEAD ID triple, written to the header as
+// three big-endian uint16s.
+type hpkeSuite struct {
+	KEM, KDF, AEAD uint16
+}
+
+func (s hpkeSuite) marshal() []byte {
+	out := binary.BigEndian.AppendUint16(nil, s.KEM)
+	out = binary.BigEndian.AppendUint16(out, s.KDF)
+	return binary.BigEndian.AppendUint16(out, s.AEAD)
+}
+
+func parseHPKESuite(b []byte) (*hpkeSuite, error) {
+	if len(b) != 6 {
+		return nil, fmt.Errorf("bad HPKE suite field")
+	}
+	return &hpkeSuite{
+		KEM:  binary.BigEndian.Uint16(b[0:2]),
+		KDF:  binary.BigEndian.Uint16(b[2:4]),
+		AEAD: binary.BigEndian.Uint16(b[4:6]),
+	}, nil
+}
+
+func (s hpkeSuite) algorithms() (hpke.KEM, hpke.KDF, hpke.AEAD, error) {
+	kem, err := hpke.NewKEM(s.KEM)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	kdf, err := hpke.NewKDF(s.KDF)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	aead, err := hpke.NewAEAD(s.AEAD)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return kem, kdf, aead, nil
+}
+
+// hpkeKeyID names a recipient by a short hash of its public key, which is
+// how a reader finds the entry meant for it.
+func hpkeKeyID(pub []byte) string {
+	sum := sha256.Sum256(pub)
+	return "hpke:" + hex.EncodeToString(sum[:8])
+}
+
+type hpkeKey struct {
+	Suite hpkeSuite
+	Pub   hpke.PublicKey
+}
+
+func newHPKEKey(c HPKEConfig) (hpkeKey, error) {
+	suite := hpkeSuite{hpkeKEMs[c.KEM], hpkeKDFs[c.KDF], hpkeAEADs[c.AEAD]}
+	kem, _, _, err := suite.algorithms()
+	if err != nil {
+		return hpkeKey{}, err
+	}
+	b, err := hex.DecodeString(c.PublicKey)
+	if err != nil {
+		return hpkeKey{}, fmt.Errorf("decoding HPKE public key: %w", err)
+	}
+	pub, err := kem.NewPublicKey(b)
+	if err != nil {
+		return hpkeKey{}, fmt.Errorf("HPKE public key: %w", err)
+	}
+	return hpkeKey{Suite: suite, Pub: pub}, nil
+}
+
+func (k hpkeKey) wrap(dataKey []byte) (wrappedKey, error) {
+	_, kdf, aead, err := k.Suite.algorithms()
+	if err != nil {
+		return wrappedKey{}, err
+	}
+	// single-shot Seal returns enc || ciphertext
+	sealed, err := hpke.Seal(k.Pub, kdf, aead, hpkeInfo, dataKey)
+	if err != nil {
+		return wrappedKey{}, fmt.Errorf("sealing data key with HPKE: %w", err)
+	}
+	suite := k.Suite
+	return wrappedKey{KeyID: hpkeKeyID(k.Pub.Bytes()), Wrapped: sealed, HPKE: &suite}, nil
+}
+
+// hpkeUnwrap opens an HPKE recipient entry with CAL_HPKE_PRIVATE_KEY, a
+// comma-separated list of hex private keys for the KEM named in the entry.
+func hpkeUnwrap(r wrappedKey) ([]byte, error) {
+	v, err := secretEnv("CAL_HPKE_PRIVATE_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if v == "" {
+		return nil, errNoKey
+	}
+
+	kem, kdf, aead, err := r.HPKE.algorithms()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range strings.Split(v, ",") {
+		b, err := hex.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("CAL_HPKE_PRIVATE_KEY must be hex: %w", err)
+		}
+		priv, err := kem.NewPrivateKey(b)
+		Wipe(b)
+		if err != nil {
+			continue
+		}
+		pub := priv.PublicKey().Bytes()
+		if hpkeKeyID(pub) != r.KeyID {
+			continue
+		}
+
+		// Split enc off ourselves rather than using hpke.Open: the DHKEM
+		// decap appends to enc, which would overwrite the ciphertext
+		// behind it in the same buffer.
+		n := len(pub)
+		if len(r.Wrapped) < n {
+			return nil, fmt.Errorf("HPKE recipient entry too short")
+		}
+		enc := bytes.Clone(r.Wrapped[:n])
+		rc, err := hpke.NewRecipient(enc, priv, kdf, aead, hpkeInfo)
+		if err != nil {
+			return nil, err
+		}
+		return rc.Open(nil, r.Wrapped[n:])
+	}
+	return nil, errNoKey
+}
+
+// hpkeRecipients lists the config's HPKE recipients plus any hex X25519
+// public keys in CAL_HPKE_RECIPIENTS (comma-separated).
+func hpkeRecipients(cfg *Config) []HPKEConfig {
+	var out []HPKEConfig
+	if cfg != nil {
+		out = append(out, cfg.HPKE...)
+	}
+	for _, pk := range strings.Split(os.Getenv("CAL_HPKE_RECIPIENTS"), ",") {
+		if pk = strings.TrimSpace(pk); pk != "" {
+			out = append(out, HPKEConfig{PublicKey: pk})
+		}
+	}
+	return out
+}
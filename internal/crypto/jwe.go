@@ -0,0 +1,77 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// jweHeader is the protected header of the -cipher=jwe output.
+type jweHeader struct {
+	Alg   string `json:"alg"`
+	Enc   string `json:"enc"`
+	KeyID string `json:"kid,omitempty"`
+	Cty   string `json:"cty"`
+}
+
+// encryptJWE writes RFC 7516 compact serialization using direct encryption
+// ("dir") with the shared key as the AES-GCM content encryption key, so any
+// JOSE library holding the key can decrypt it.
+func encryptJWE(k encryptionKey, plaintext []byte) ([]byte, error) {
+	if k.KDF != nil {
+		return nil, fmt.Errorf("jwe output needs a raw key, not a passphrase")
+	}
+
+	var enc string
+	switch len(k.Key) {
+	case 16:
+		enc = "A128GCM"
+	case 24:
+		enc = "A192GCM"
+	case 32:
+		enc = "A256GCM"
+	default:
+		return nil, fmt.Errorf("jwe output needs a 128, 192 or 256-bit key, got %d bits", len(k.Key)*8)
+	}
+
+	header, err := json.Marshal(jweHeader{Alg: "dir", Enc: enc, KeyID: k.ID, Cty: "json"})
+	if err != nil {
+		return nil, fmt.Errorf("marshalling header: %w", err)
+	}
+
+	block, err := aes.NewCipher(k.Key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating GCM: %w", err)
+	}
+
+	iv := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, fmt.Errorf("generating IV: %w", err)
+	}
+
+	// the additional data is the encoded protected header (RFC 7516 §5.1)
+	b64 := base64.RawURLEncoding
+	protected := b64.EncodeToString(header)
+	sealed := aead.Seal(nil, iv, plaintext, []byte(protected))
+	ciphertext, tag := sealed[:len(plaintext)], sealed[len(plaintext):]
+
+	// "dir" has an empty encrypted key part
+	parts := []string{
+		protected,
+		"",
+		b64.EncodeToString(iv),
+		b64.EncodeToString(ciphertext),
+		b64.EncodeToString(tag),
+	}
+	return []byte(strings.Join(parts, ".")), nil
+}
@@ -0,0 +1,214 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// encryptionKey is a resolved AES key plus the metadata that has to travel
+// with it in the file header.
+type encryptionKey struct {
+	ID  string
+	Key []byte
+	KDF *kdfParams
+}
+
+// currentKey picks the key to encrypt with: the config keyring's current
+// key if there is one, otherwise CAL_KEY (tagged with CAL_KEY_ID if set).
+func currentKey(cfg *Config, passphrase bool) (encryptionKey, error) {
+	k, err := currentKeyConfig(cfg, passphrase)
+	if err != nil {
+		return encryptionKey{}, err
+	}
+	return resolveKey(k)
+}
+
+func currentKeyConfig(cfg *Config, passphrase bool) (KeyConfig, error) {
+	if cfg != nil && cfg.CurrentKey != "" {
+		k, _ := cfg.key(cfg.CurrentKey)
+		return k, nil
+	}
+
+	v, err := secretEnv("CAL_KEY")
+	if err != nil {
+		return KeyConfig{}, err
+	}
+
+	k := KeyConfig{ID: os.Getenv("CAL_KEY_ID")}
+	if passphrase {
+		k.Passphrase = v
+	} else {
+		k.Key = v
+	}
+	return k, nil
+}
+
+// CheckKeyStrength validates the keys new output will be encrypted with,
+// so a bad CAL_KEY fails before any calendars are fetched. Raw keys must be
+// hex AES-128 or AES-256 keys of at least minBits; passphrases always
+// derive 256-bit keys.
+func CheckKeyStrength(cfg *Config, passphrase bool, minBits int) error {
+	var ids []string
+	switch {
+	case cfg != nil && len(cfg.Tiers) > 0:
+		for _, t := range cfg.Tiers {
+			ids = append(ids, t.Key)
+		}
+	case useEnvelope(cfg):
+		if cfg != nil {
+			ids = cfg.Recipients
+		}
+	case cfg != nil && cfg.CurrentKey != "":
+		ids = []string{cfg.CurrentKey}
+	default:
+		k, err := currentKeyConfig(cfg, passphrase)
+		if err != nil {
+			return err
+		}
+		return checkAESKey("CAL_KEY", k, minBits)
+	}
+
+	for _, id := range ids {
+		k, _ := cfg.key(id)
+		if err := checkAESKey(fmt.Sprintf("key %q", id), k, minBits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkAESKey(name string, k KeyConfig, minBits int) error {
+	if k.Passphrase != "" {
+		return nil
+	}
+	if k.Key == "" {
+		return fmt.Errorf("%s is not set", name)
+	}
+
+	b, err := hex.DecodeString(k.Key)
+	if err != nil {
+		return fmt.Errorf("%s must be hex (32 or 64 characters for AES-128 or AES-256): %w", name, err)
+	}
+	bits := 8 * len(b)
+	Wipe(b)
+	if bits != 128 && bits != 256 {
+		return fmt.Errorf("%s is %d bits; use a 128- or 256-bit key", name, bits)
+	}
+	if bits < minBits {
+		return fmt.Errorf("%s is %d bits but at least %d are required", name, bits, minBits)
+	}
+	return nil
+}
+
+// useEnvelope reports whether output is sealed with a data key wrapped for
+// recipients rather than with a single current key.
+func useEnvelope(cfg *Config) bool {
+	return cfg != nil && (len(cfg.Recipients) > 0 || len(cfg.KMS) > 0) || len(hpkeRecipients(cfg)) > 0
+}
+
+// recipientKeys resolves every key listed in the config's recipients, plus
+// any KMS keys and HPKE public keys.
+func recipientKeys(cfg *Config) ([]keyWrapper, error) {
+	var keys []keyWrapper
+	if cfg != nil {
+		for _, id := range cfg.Recipients {
+			k, _ := cfg.key(id)
+			key, err := resolveKey(k)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+		for _, k := range cfg.KMS {
+			keys = append(keys, kmsKey{Provider: k.Provider, KeyID: k.KeyID, Region: k.Region})
+		}
+	}
+	for _, h := range hpkeRecipients(cfg) {
+		key, err := newHPKEKey(h)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func resolveKey(k KeyConfig) (encryptionKey, error) {
+	if k.Passphrase != "" {
+		key, kdf, err := deriveKey([]byte(k.Passphrase))
+		if err != nil {
+			return encryptionKey{}, err
+		}
+		return encryptionKey{ID: k.ID, Key: key, KDF: kdf}, nil
+	}
+
+	key, err := hex.DecodeString(k.Key)
+	if err != nil {
+		return encryptionKey{}, fmt.Errorf("decoding key %q: %w", k.ID, err)
+	}
+	return encryptionKey{ID: k.ID, Key: key}, nil
+}
+
+// KeyWarnings checks CAL_KEY, CAL_MAC_KEY and any raw keys in the config
+// keyring for values that are malformed, truncated or obviously weak.
+func KeyWarnings(cfg *Config) []string {
+	var warnings []string
+	check := func(name, value string, sizes ...int) {
+		if value == "" {
+			return
+		}
+		value, err := ResolveSecret(value)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", name, err))
+			return
+		}
+		if w := checkHexKey(value, sizes); w != "" {
+			warnings = append(warnings, name+": "+w)
+		}
+	}
+
+	check("CAL_KEY", os.Getenv("CAL_KEY"), 16, 32)
+	check("CAL_MAC_KEY", os.Getenv("CAL_MAC_KEY"), 16, 32, 64)
+	check("CAL_CONFIG_KEY", os.Getenv("CAL_CONFIG_KEY"), 16, 24, 32)
+	if cfg != nil {
+		for _, k := range cfg.Keys {
+			check(fmt.Sprintf("key %q", k.ID), k.Key, 16, 32)
+			if k.Passphrase != "" && len(k.Passphrase) < 12 {
+				warnings = append(warnings, fmt.Sprintf("key %q: passphrase is shorter than 12 characters", k.ID))
+			}
+		}
+	}
+	return warnings
+}
+
+// checkHexKey describes what's wrong with a hex key, or returns "" if it
+// decodes to one of the expected sizes and isn't trivially guessable.
+func checkHexKey(value string, sizes []int) string {
+	if len(value)%2 != 0 {
+		return fmt.Sprintf("odd number of hex digits (%d), probably truncated", len(value))
+	}
+
+	b, err := hex.DecodeString(value)
+	if err != nil {
+		return "not valid hex (if this is a passphrase, use -passphrase)"
+	}
+
+	ok := false
+	for _, n := range sizes {
+		ok = ok || len(b) == n
+	}
+	if !ok {
+		return fmt.Sprintf("%d bits is not a supported length, probably truncated or padded", len(b)*8)
+	}
+
+	// a random key of this size will essentially never repeat this much
+	distinct := make(map[byte]bool)
+	for _, c := range b {
+		distinct[c] = true
+	}
+	if len(distinct) < len(b)/2 {
+		return fmt.Sprintf("only %d distinct bytes in %d, looks hand-made rather than random", len(distinct), len(b))
+	}
+	return ""
+}
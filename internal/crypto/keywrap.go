@@ -0,0 +1,81 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+)
+
+// defaultIV is the RFC 3394 initial value.
+var defaultIV = [8]byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// wrapKey implements AES Key Wrap (RFC 3394), which is what WebCrypto's
+// AES-KW and JOSE's A*KW algorithms expect.
+func wrapKey(kek, cek []byte) ([]byte, error) {
+	if len(cek) < 16 || len(cek)%8 != 0 {
+		return nil, fmt.Errorf("key to wrap must be a multiple of 64 bits and at least 128 bits")
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(cek) / 8
+	a := defaultIV
+	r := make([]byte, len(cek))
+	copy(r, cek)
+
+	var buf [aes.BlockSize]byte
+	for j := 0; j < 6; j++ {
+		for i := 0; i < n; i++ {
+			copy(buf[:8], a[:])
+			copy(buf[8:], r[i*8:i*8+8])
+			block.Encrypt(buf[:], buf[:])
+
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(a[:], binary.BigEndian.Uint64(buf[:8])^t)
+			copy(r[i*8:i*8+8], buf[8:])
+		}
+	}
+
+	return append(a[:], r...), nil
+}
+
+// unwrapKey reverses wrapKey, failing if the integrity check value doesn't
+// match (wrong KEK or corrupted input).
+func unwrapKey(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, fmt.Errorf("wrapped key has bad length %d", len(wrapped))
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(wrapped)/8 - 1
+	var a [8]byte
+	copy(a[:], wrapped[:8])
+	r := make([]byte, n*8)
+	copy(r, wrapped[8:])
+
+	var buf [aes.BlockSize]byte
+	for j := 5; j >= 0; j-- {
+		for i := n - 1; i >= 0; i-- {
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(buf[:8], binary.BigEndian.Uint64(a[:])^t)
+			copy(buf[8:], r[i*8:i*8+8])
+			block.Decrypt(buf[:], buf[:])
+
+			copy(a[:], buf[:8])
+			copy(r[i*8:i*8+8], buf[8:])
+		}
+	}
+
+	if subtle.ConstantTimeCompare(a[:], defaultIV[:]) != 1 {
+		return nil, fmt.Errorf("key unwrap integrity check failed")
+	}
+	return r, nil
+}
@@ -0,0 +1,262 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	kmsAWS = "aws"
+	kmsGCP = "gcp"
+)
+
+var kmsClient = &http.Client{Timeout: 30 * time.Second}
+
+// kmsKey wraps the data key with a cloud KMS key, so the build machine only
+// ever holds credentials to call KMS, never a long-lived decryption key.
+type kmsKey struct {
+	Provider string
+	KeyID    string
+	Region   string
+}
+
+func (k kmsKey) wrap(dataKey []byte) (wrappedKey, error) {
+	var blob []byte
+	var err error
+	switch k.Provider {
+	case kmsAWS:
+		blob, err = awsKMSCall(k.KeyID, k.Region, "Encrypt", map[string]string{
+			"KeyId":     k.KeyID,
+			"Plaintext": base64.StdEncoding.EncodeToString(dataKey),
+		}, "CiphertextBlob")
+	case kmsGCP:
+		blob, err = gcpKMSCall(k.KeyID, "encrypt", "plaintext", dataKey, "ciphertext")
+	default:
+		err = fmt.Errorf("unknown KMS provider %q", k.Provider)
+	}
+	if err != nil {
+		return wrappedKey{}, fmt.Errorf("wrapping data key with %s KMS: %w", k.Provider, err)
+	}
+
+	return wrappedKey{KeyID: k.KeyID, Wrapped: blob, KMS: k.Provider}, nil
+}
+
+// kmsUnwrap asks the provider recorded in the header to decrypt a wrapped
+// data key. The key ID in the header is enough to find the key.
+func kmsUnwrap(r wrappedKey) ([]byte, error) {
+	switch r.KMS {
+	case kmsAWS:
+		return awsKMSCall(r.KeyID, "", "Decrypt", map[string]string{
+			"KeyId":          r.KeyID,
+			"CiphertextBlob": base64.StdEncoding.EncodeToString(r.Wrapped),
+		}, "Plaintext")
+	case kmsGCP:
+		return gcpKMSCall(r.KeyID, "decrypt", "ciphertext", r.Wrapped, "plaintext")
+	}
+	return nil, fmt.Errorf("unknown KMS provider %q", r.KMS)
+}
+
+// awsKMSCall makes a KMS JSON API call and returns the base64 field named
+// result from the response. The region comes from the key ARN unless given.
+func awsKMSCall(keyID, region, action string, params map[string]string, result string) ([]byte, error) {
+	if region == "" {
+		// arn:aws:kms:<region>:<account>:key/<id>
+		if parts := strings.Split(keyID, ":"); len(parts) > 3 && parts[0] == "arn" {
+			region = parts[3]
+		} else if region = os.Getenv("AWS_REGION"); region == "" {
+			return nil, fmt.Errorf("no region: use a key ARN or set AWS_REGION")
+		}
+	}
+
+	creds, err := AWSCredentialsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// AWS_ENDPOINT_URL_KMS is the SDKs' override, handy for LocalStack
+	endpoint := "https://kms." + region + ".amazonaws.com/"
+	if v := os.Getenv("AWS_ENDPOINT_URL_KMS"); v != "" {
+		endpoint = v
+	}
+
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	SignAWSRequest(req, body, "kms", region, creds, time.Now())
+
+	return kmsResult(req, result)
+}
+
+// gcpKMSCall calls projects.locations.keyRings.cryptoKeys.{encrypt,decrypt}
+// on the key resource name and returns the base64 field named result.
+func gcpKMSCall(keyName, method, field string, value []byte, result string) ([]byte, error) {
+	token, err := gcpAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(map[string]string{field: base64.StdEncoding.EncodeToString(value)})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", "https://cloudkms.googleapis.com/v1/"+keyName+":"+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return kmsResult(req, result)
+}
+
+func kmsResult(req *http.Request, result string) ([]byte, error) {
+	resp, err := kmsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("parsing response: %w", err)
+	}
+	s, ok := fields[result].(string)
+	if !ok {
+		return nil, fmt.Errorf("response has no %s", result)
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// gcpAccessToken finds an OAuth token for Cloud KMS, trying in order
+// GOOGLE_OAUTH_ACCESS_TOKEN (which may be e.g. "cmd:gcloud auth
+// print-access-token"), a service account key in
+// GOOGLE_APPLICATION_CREDENTIALS, and the GCE metadata server.
+func gcpAccessToken() (string, error) {
+	token, err := secretEnv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	if err != nil || token != "" {
+		return token, err
+	}
+
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		return gcpServiceAccountToken(path)
+	}
+
+	req, err := http.NewRequest("GET", "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	return gcpTokenResponse(req)
+}
+
+// gcpServiceAccountToken exchanges a self-signed JWT for an access token
+// (the OAuth 2.0 JWT bearer flow service account keys use).
+func gcpServiceAccountToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	var sa struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return "", fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("%s: no PEM private key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("%s: private key is not RSA", path)
+	}
+
+	now := time.Now()
+	b64 := base64.RawURLEncoding
+	header := b64.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]any{
+		"iss":   sa.ClientEmail,
+		"scope": "https://www.googleapis.com/auth/cloudkms",
+		"aud":   sa.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + b64.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + b64.EncodeToString(sig)},
+	}
+	req, err := http.NewRequest("POST", sa.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return gcpTokenResponse(req)
+}
+
+func gcpTokenResponse(req *http.Request) (string, error) {
+	resp, err := kmsClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching GCP access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching GCP access token: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", fmt.Errorf("parsing GCP token response: %w", err)
+	}
+	return tok.AccessToken, nil
+}
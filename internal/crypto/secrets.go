@@ -0,0 +1,94 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Secrets (CAL_KEY, CAL_MAC_KEY, keyring entries, ...) can be given as a
+// reference instead of the value itself, so the secret doesn't sit in the
+// environment or the config file:
+//
+//	file:/run/secrets/cal_key    contents of the file
+//	cmd:pass show cal/key        stdout of the command, run with sh -c
+//	keychain:service/account     macOS Keychain or Secret Service entry
+//	env:OTHER_VAR                another environment variable
+//	vault:secret/calendar#key    field of a Vault KV v2 secret (see vault.go)
+//	literal:env:not-a-ref        the rest, as is
+//
+// Only these schemes are references: anything else, like a passphrase
+// "word:word", is taken literally. A secret that does begin with one of
+// them is escaped with literal:. Trailing newlines are trimmed from files
+// and commands.
+func ResolveSecret(ref string) (string, error) {
+	scheme, rest, ok := strings.Cut(ref, ":")
+	if !ok {
+		return ref, nil
+	}
+
+	switch scheme {
+	case "file":
+		b, err := os.ReadFile(rest)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+
+	case "cmd":
+		return runSecretCommand(exec.Command("sh", "-c", rest))
+
+	case "keychain":
+		service, account, _ := strings.Cut(rest, "/")
+		if runtime.GOOS == "darwin" {
+			args := []string{"find-generic-password", "-w", "-s", service}
+			if account != "" {
+				args = append(args, "-a", account)
+			}
+			return runSecretCommand(exec.Command("security", args...))
+		}
+
+		args := []string{"lookup", "service", service}
+		if account != "" {
+			args = append(args, "account", account)
+		}
+		return runSecretCommand(exec.Command("secret-tool", args...))
+
+	case "env":
+		return os.Getenv(rest), nil
+
+	case "vault":
+		return readVaultSecret(rest)
+
+	case "literal":
+		return rest, nil
+	}
+
+	return ref, nil
+}
+
+func runSecretCommand(cmd *exec.Cmd) (string, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %w: %s", cmd.Args[0], err, msg)
+		}
+		return "", fmt.Errorf("%s: %w", cmd.Args[0], err)
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// secretEnv reads an environment variable holding a secret or a reference
+// to one.
+func secretEnv(name string) (string, error) {
+	v, err := ResolveSecret(os.Getenv(name))
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", name, err)
+	}
+	return v, nil
+}
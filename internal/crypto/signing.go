@@ -0,0 +1,74 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The signature of an output file is published next to it as <file>.sig,
+// a hex Ed25519 signature over the exact bytes of the file. It's separate
+// from the encryption key, so a reader can check the file came from the
+// build pipeline without being able to forge one.
+const signatureSuffix = ".sig"
+
+// signingKey reads CAL_SIGNING_KEY, a hex Ed25519 seed (or full private
+// key), or returns nil if signing isn't configured.
+func SigningKey() (ed25519.PrivateKey, error) {
+	v, err := secretEnv("CAL_SIGNING_KEY")
+	if err != nil || v == "" {
+		return nil, err
+	}
+
+	b, err := hex.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("decoding CAL_SIGNING_KEY: %w", err)
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	}
+	return nil, fmt.Errorf("CAL_SIGNING_KEY must be a %d-byte seed or %d-byte private key", ed25519.SeedSize, ed25519.PrivateKeySize)
+}
+
+// Sign returns the contents of data's .sig file.
+func Sign(key ed25519.PrivateKey, data []byte) []byte {
+	return []byte(hex.EncodeToString(ed25519.Sign(key, data)) + "\n")
+}
+
+// SignaturePath is where the signature of the output at path goes.
+func SignaturePath(path string) string {
+	return path + signatureSuffix
+}
+
+// VerifySignature checks path's .sig file against CAL_SIGNING_PUBLIC_KEY.
+// It reports false without an error when no public key is configured.
+func VerifySignature(path string, data []byte) (bool, error) {
+	v := os.Getenv("CAL_SIGNING_PUBLIC_KEY")
+	if v == "" {
+		return false, nil
+	}
+
+	pub, err := hex.DecodeString(v)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return false, fmt.Errorf("CAL_SIGNING_PUBLIC_KEY must be a hex %d-byte Ed25519 public key", ed25519.PublicKeySize)
+	}
+
+	sigHex, err := os.ReadFile(SignaturePath(path))
+	if err != nil {
+		return false, fmt.Errorf("reading signature: %w", err)
+	}
+	sig, err := hex.DecodeString(strings.TrimSpace(string(sigHex)))
+	if err != nil {
+		return false, fmt.Errorf("decoding signature: %w", err)
+	}
+
+	if !ed25519.Verify(pub, data, sig) {
+		return false, fmt.Errorf("signature does not match %s", path)
+	}
+	return true, nil
+}
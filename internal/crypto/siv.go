@@ -0,0 +1,175 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io"
+)
+
+// AES-SIV (RFC 5297) is deterministic authenticated encryption: the IV is
+// a MAC of the inputs, so a repeated or broken nonce costs nothing worse
+// than revealing that two files are identical. A random nonce is still
+// written to the header, and the header is authenticated data, so normal
+// runs don't produce the same output twice.
+
+// sivKey derives the double-length SIV key from the configured AES key, so
+// the same CAL_KEY works for every cipher.
+func sivKey(key []byte) ([]byte, error) {
+	return hkdf.Key(sha256.New, key, nil, "cal.aes AES-SIV", 2*len(key))
+}
+
+func encryptSIV(k encryptionKey, plaintext []byte) ([]byte, error) {
+	key, err := sivKey(k.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(key)
+
+	nonce := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+
+	h := fileHeader{Cipher: cipherIDAESSIV, Nonce: nonce, KeyID: k.ID, KDF: k.KDF}
+	header, err := h.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	return sivSeal(header, key, plaintext, header)
+}
+
+// sivSeal appends V || C to dst.
+func sivSeal(dst, key, plaintext []byte, ad ...[]byte) ([]byte, error) {
+	macKey, ctrKey := key[:len(key)/2], key[len(key)/2:]
+
+	v, err := s2v(macKey, append(append([][]byte(nil), ad...), plaintext)...)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append(dst, v...)
+	ct := make([]byte, len(plaintext))
+	if err := sivCTR(ctrKey, v, ct, plaintext); err != nil {
+		return nil, err
+	}
+	return append(out, ct...), nil
+}
+
+func sivOpen(key, sealed []byte, ad ...[]byte) ([]byte, error) {
+	if len(sealed) < aes.BlockSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	macKey, ctrKey := key[:len(key)/2], key[len(key)/2:]
+	v, ct := sealed[:aes.BlockSize], sealed[aes.BlockSize:]
+
+	plaintext := make([]byte, len(ct))
+	if err := sivCTR(ctrKey, v, plaintext, ct); err != nil {
+		return nil, err
+	}
+
+	expected, err := s2v(macKey, append(append([][]byte(nil), ad...), plaintext)...)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(expected, v) != 1 {
+		return nil, fmt.Errorf("message authentication failed")
+	}
+	return plaintext, nil
+}
+
+// sivCTR runs AES-CTR from the synthetic IV with the two bits RFC 5297
+// §2.6 clears, so implementations can use 32-bit or 64-bit counters.
+func sivCTR(key, v, dst, src []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	q := make([]byte, aes.BlockSize)
+	copy(q, v)
+	q[8] &= 0x7f
+	q[12] &= 0x7f
+	cipher.NewCTR(block, q).XORKeyStream(dst, src)
+	return nil
+}
+
+// s2v is the RFC 5297 §2.4 string-to-vector PRF over the given inputs, the
+// last of which is the plaintext.
+func s2v(key []byte, inputs ...[]byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	d := cmac(block, make([]byte, aes.BlockSize))
+	for _, s := range inputs[:len(inputs)-1] {
+		d = dbl(d)
+		subtle.XORBytes(d, d, cmac(block, s))
+	}
+
+	last := inputs[len(inputs)-1]
+	var t []byte
+	if len(last) >= aes.BlockSize {
+		t = append([]byte(nil), last...)
+		tail := t[len(t)-aes.BlockSize:]
+		subtle.XORBytes(tail, tail, d)
+	} else {
+		t = dbl(d)
+		padded := make([]byte, aes.BlockSize)
+		copy(padded, last)
+		padded[len(last)] = 0x80
+		subtle.XORBytes(t, t, padded)
+	}
+	return cmac(block, t), nil
+}
+
+// cmac is AES-CMAC (RFC 4493).
+func cmac(block cipher.Block, msg []byte) []byte {
+	l := make([]byte, aes.BlockSize)
+	block.Encrypt(l, l)
+	k1 := dbl(l)
+	k2 := dbl(k1)
+
+	n := (len(msg) + aes.BlockSize - 1) / aes.BlockSize
+	last := make([]byte, aes.BlockSize)
+	if n > 0 && len(msg)%aes.BlockSize == 0 {
+		subtle.XORBytes(last, msg[(n-1)*aes.BlockSize:], k1)
+	} else {
+		if n == 0 {
+			n = 1
+		}
+		rest := msg[(n-1)*aes.BlockSize:]
+		copy(last, rest)
+		last[len(rest)] = 0x80
+		subtle.XORBytes(last, last, k2)
+	}
+
+	x := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		subtle.XORBytes(x, x, msg[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		block.Encrypt(x, x)
+	}
+	subtle.XORBytes(x, x, last)
+	block.Encrypt(x, x)
+	return x
+}
+
+// dbl multiplies by x in GF(2^128), as CMAC subkey generation and S2V use.
+func dbl(b []byte) []byte {
+	out := make([]byte, len(b))
+	var carry byte
+	for i := len(b) - 1; i >= 0; i-- {
+		out[i] = b[i]<<1 | carry
+		carry = b[i] >> 7
+	}
+	if carry != 0 {
+		out[len(out)-1] ^= 0x87
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Tier visibility levels.
+const (
+	ShowAll    = "all"    // every event with its title
+	ShowPublic = "public" // titles only for events not marked private
+	ShowBusy   = "busy"   // start and end times only
+)
+
+// TierConfig is one output tier. Each tier is encrypted with its own key,
+// so handing out the "friends" key never exposes the private tier.
+type TierConfig struct {
+	Name string `json:"name"`
+	// Key is the ID of the tier's key in keys. No two tiers may share one.
+	Key string `json:"key"`
+	// Show is "all" (the default), "public" or "busy".
+	Show string `json:"show,omitempty"`
+	// Output defaults to docs/cal-<name>.aes.
+	Output string `json:"output,omitempty"`
+}
+
+// ForTier is the config a tier's output is encrypted with: the same
+// keyring, with the tier's key as the current one and no recipients.
+func (c *Config) ForTier(t TierConfig) *Config {
+	tc := *c
+	tc.CurrentKey = t.Key
+	tc.Recipients = nil
+	tc.KMS = nil
+	tc.HPKE = nil
+	return &tc
+}
+
+func (c *Config) validateTiers() error {
+	if len(c.Tiers) > 0 && (len(c.Recipients) > 0 || len(c.KMS) > 0 || len(c.HPKE) > 0) {
+		return fmt.Errorf("tiers can't be combined with recipients, kms or hpke")
+	}
+
+	names := make(map[string]bool)
+	keys := make(map[string]string)
+	for i, t := range c.Tiers {
+		if t.Name == "" || strings.ContainsAny(t.Name, `/\`) {
+			return fmt.Errorf("tiers[%d]: missing or invalid name", i)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("tiers[%d]: duplicate name %q", i, t.Name)
+		}
+		names[t.Name] = true
+
+		switch t.Show {
+		case "", ShowAll, ShowPublic, ShowBusy:
+		default:
+			return fmt.Errorf("tier %q: show must be %q, %q or %q", t.Name, ShowAll, ShowPublic, ShowBusy)
+		}
+
+		k, ok := c.key(t.Key)
+		if !ok {
+			return fmt.Errorf("tier %q: key %q is not in keys", t.Name, t.Key)
+		}
+		// compare the key material too, so one key listed under two IDs,
+		// even in hex of another case, can't slip through
+		material := []byte(k.Key)
+		if b, err := hex.DecodeString(k.Key); err == nil {
+			material = b
+		}
+		secret := string(material) + "\x00" + k.Passphrase
+		Wipe(material)
+		if other, ok := keys[secret]; ok {
+			return fmt.Errorf("tier %q uses the same key as tier %q", t.Name, other)
+		}
+		keys[secret] = t.Name
+	}
+	return nil
+}
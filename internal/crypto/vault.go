@@ -0,0 +1,184 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// VaultConfig is the config's vault section. Empty fields fall back to the
+// usual VAULT_ADDR, VAULT_NAMESPACE, VAULT_TOKEN, VAULT_ROLE_ID and
+// VAULT_SECRET_ID variables.
+type VaultConfig struct {
+	Address   string `json:"address,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	// Auth is "token" (the default) or "approle".
+	Auth string `json:"auth,omitempty"`
+	// Token, RoleID and SecretID may be file:/cmd:/keychain:/env:
+	// references.
+	Token        string `json:"token,omitempty"`
+	RoleID       string `json:"roleId,omitempty"`
+	SecretID     string `json:"secretId,omitempty"`
+	AppRoleMount string `json:"appRoleMount,omitempty"`
+}
+
+type vaultClient struct {
+	cfg   VaultConfig
+	token string
+	http  *http.Client
+}
+
+// vault is set from the config file's vault section by Resolve, or from
+// the environment the first time a vault: reference is resolved.
+var vault *vaultClient
+
+func newVaultClient(cfg VaultConfig) *vaultClient {
+	env := func(v *string, name string) {
+		if *v == "" {
+			*v = os.Getenv(name)
+		}
+	}
+	env(&cfg.Address, "VAULT_ADDR")
+	env(&cfg.Namespace, "VAULT_NAMESPACE")
+	env(&cfg.Token, "VAULT_TOKEN")
+	env(&cfg.RoleID, "VAULT_ROLE_ID")
+	env(&cfg.SecretID, "VAULT_SECRET_ID")
+	if cfg.Auth == "" {
+		cfg.Auth = "token"
+		if cfg.Token == "" && cfg.RoleID != "" {
+			cfg.Auth = "approle"
+		}
+	}
+	if cfg.AppRoleMount == "" {
+		cfg.AppRoleMount = "approle"
+	}
+
+	return &vaultClient{cfg: cfg, http: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// readVaultSecret resolves a vault:<mount>/<path>#<field> reference against
+// a KV v2 secrets engine, e.g. vault:secret/calendar#cal_key.
+func readVaultSecret(ref string) (string, error) {
+	if vault == nil {
+		vault = newVaultClient(VaultConfig{})
+	}
+
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || field == "" {
+		return "", fmt.Errorf("vault reference %q needs a #field", ref)
+	}
+	mount, rest, ok := strings.Cut(path, "/")
+	if !ok {
+		return "", fmt.Errorf("vault reference %q needs a mount and a path", ref)
+	}
+
+	var resp struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := vault.do("GET", mount+"/data/"+rest, nil, &resp); err != nil {
+		return "", err
+	}
+
+	v, ok := resp.Data.Data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no string field %q", path, field)
+	}
+	return v, nil
+}
+
+func (c *vaultClient) login() error {
+	if c.token != "" {
+		return nil
+	}
+	if c.cfg.Address == "" {
+		return fmt.Errorf("no vault address: set vault.address or VAULT_ADDR")
+	}
+
+	switch c.cfg.Auth {
+	case "token":
+		token, err := ResolveSecret(c.cfg.Token)
+		if err != nil {
+			return fmt.Errorf("resolving vault token: %w", err)
+		}
+		if token == "" {
+			return fmt.Errorf("no vault token: set vault.token or VAULT_TOKEN")
+		}
+		c.token = token
+		return nil
+
+	case "approle":
+		roleID, err := ResolveSecret(c.cfg.RoleID)
+		if err != nil {
+			return fmt.Errorf("resolving vault role ID: %w", err)
+		}
+		secretID, err := ResolveSecret(c.cfg.SecretID)
+		if err != nil {
+			return fmt.Errorf("resolving vault secret ID: %w", err)
+		}
+
+		var resp struct {
+			Auth struct {
+				ClientToken string `json:"client_token"`
+			} `json:"auth"`
+		}
+		body := map[string]string{"role_id": roleID, "secret_id": secretID}
+		if err := c.request("POST", "auth/"+c.cfg.AppRoleMount+"/login", body, &resp); err != nil {
+			return fmt.Errorf("vault approle login: %w", err)
+		}
+		c.token = resp.Auth.ClientToken
+		return nil
+	}
+
+	return fmt.Errorf("unknown vault auth method %q", c.cfg.Auth)
+}
+
+func (c *vaultClient) do(method, path string, body, out any) error {
+	if err := c.login(); err != nil {
+		return err
+	}
+	return c.request(method, path, body, out)
+}
+
+func (c *vaultClient) request(method, path string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(c.cfg.Address, "/")+"/v1/"+path, r)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+	if c.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.cfg.Namespace)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,62 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// webCryptoPayload is the -cipher=webcrypto output. Its fields map one to
+// one onto SubtleCrypto, with no byte slicing on the browser side:
+//
+//	const key = await crypto.subtle.importKey("raw", keyBytes, "AES-GCM", false, ["decrypt"]);
+//	const plain = await crypto.subtle.decrypt(
+//		{ name: p.name, iv: b64(p.iv), tagLength: p.tagLength }, key, b64(p.data));
+//
+// data is the ciphertext with the tag appended, which is the layout
+// SubtleCrypto both produces and expects.
+type webCryptoPayload struct {
+	Name      string `json:"name"`
+	IV        string `json:"iv"`
+	TagLength int    `json:"tagLength"`
+	KeyID     string `json:"kid,omitempty"`
+	Data      string `json:"data"`
+}
+
+func encryptWebCrypto(k encryptionKey, plaintext []byte) ([]byte, error) {
+	if k.KDF != nil {
+		return nil, fmt.Errorf("webcrypto output needs a raw key; SubtleCrypto has no Argon2id")
+	}
+
+	block, err := aes.NewCipher(k.Key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating GCM: %w", err)
+	}
+
+	iv := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, fmt.Errorf("generating IV: %w", err)
+	}
+	return sealWebCrypto(k, aead, iv, plaintext)
+}
+
+// sealWebCrypto is encryptWebCrypto with the IV given, so the output can
+// be checked against SubtleCrypto's.
+func sealWebCrypto(k encryptionKey, aead cipher.AEAD, iv, plaintext []byte) ([]byte, error) {
+	return json.Marshal(webCryptoPayload{
+		Name:      "AES-GCM",
+		IV:        base64.StdEncoding.EncodeToString(iv),
+		TagLength: aead.Overhead() * 8,
+		KeyID:     k.ID,
+		Data:      base64.StdEncoding.EncodeToString(aead.Seal(nil, iv, plaintext, nil)),
+	})
+}
@@ -0,0 +1,61 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+// The vector was made with SubtleCrypto (Node 20's crypto.subtle):
+//
+//	const k = await crypto.subtle.importKey("raw", key, "AES-GCM", false, ["encrypt"]);
+//	await crypto.subtle.encrypt({ name: "AES-GCM", iv, tagLength: 128 }, k, plaintext);
+//
+// with key the bytes 0x00 to 0x1f and iv 0xa0 to 0xab.
+const (
+	webCryptoKey       = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	webCryptoIV        = "a0a1a2a3a4a5a6a7a8a9aaab"
+	webCryptoPlaintext = `{"events":[{"title":"Standup","start":"2026-10-15T09:30:00Z","end":"2026-10-15T09:45:00Z"}]}`
+	webCryptoData      = "nToZWyCldsxAX9yoJQ6pqhzJeyqw5DYN8mpT9l2HV3KmFzWLjRhxD2+uMuU4Sq7Ick92cVjjKkRxblF8iFLg3tCev00CldbW2dPPNb77mIr0MZ+R//GpIMwIY+eV2KFNYQW/T55gCwYuezRv"
+)
+
+func TestWebCryptoMatchesSubtleCrypto(t *testing.T) {
+	key, _ := hex.DecodeString(webCryptoKey)
+	iv, _ := hex.DecodeString(webCryptoIV)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := sealWebCrypto(encryptionKey{Key: key}, aead, iv, []byte(webCryptoPlaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p webCryptoPayload
+	if err := json.Unmarshal(out, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "AES-GCM" || p.IV != "oKGio6Slpqeoqaqr" || p.TagLength != 128 {
+		t.Errorf("got name %q, iv %q, tagLength %d; want AES-GCM, oKGio6Slpqeoqaqr, 128", p.Name, p.IV, p.TagLength)
+	}
+	if p.Data != webCryptoData {
+		t.Errorf("data doesn't match SubtleCrypto's:\n got %s\nwant %s", p.Data, webCryptoData)
+	}
+
+	// and what SubtleCrypto encrypted decrypts
+	p.Data = webCryptoData
+	payload, _ := json.Marshal(p)
+	plain, err := decryptWebCrypto([]KeyConfig{{Key: webCryptoKey}}, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plain) != webCryptoPlaintext {
+		t.Errorf("decrypted %q, want %q", plain, webCryptoPlaintext)
+	}
+}
@@ -0,0 +1,13 @@
+package crypto
+
+// Wipe zeroes a buffer once it's no longer needed: the decoded copy of a
+// key, a derived or data key, or a plaintext. It's best effort, to make a
+// core dump or swapped page from a long-running process less likely to hold
+// them. It can't reach the configured keys themselves, which stay in
+// KeyConfig as hex strings for as long as the config does, nor copies the GC
+// has already made.
+func Wipe(bufs ...[]byte) {
+	for _, b := range bufs {
+		clear(b)
+	}
+}
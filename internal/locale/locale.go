@@ -0,0 +1,206 @@
+// Package locale provides the day and month names, clock and first day of
+// the week the human-readable outputs are written with: status.txt, the
+// week summary, the timeline and week images, and output templates. It's
+// English with a 24-hour clock and weeks from Monday unless set with Set.
+package locale
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// Locale is a language's names and words, and a region's clock and week.
+type Locale struct {
+	Tag language.Tag
+	// Days and ShortDays are indexed by time.Weekday, Sunday first.
+	Days, ShortDays     [7]string
+	Months, ShortMonths [12]string
+	Hour12              bool
+	FirstDay            time.Weekday
+	Words               Words
+}
+
+// Words are the phrases of status.txt, the week summary and the timeline,
+// as fmt formats.
+type Words struct {
+	Free, BusyUntil, Next                  string
+	Busy, BusyMorning, BusyAfternoon, Away string
+	// Meeting is the total of meetings when it's singular, Meetings
+	// otherwise; ZeroIsOne says 0 is singular, as in French.
+	Meeting, Meetings string
+	ZeroIsOne         bool
+	// Timeline labels the timeline image, from one day to another.
+	Timeline string
+}
+
+// supported are the languages with names, matched against the tag Parse
+// is given, in the order of languages.
+var supported = []language.Tag{language.English, language.French, language.German, language.Spanish}
+
+var languages = []Locale{
+	{
+		Days:        [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"},
+		ShortDays:   [7]string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+		Months:      [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+		ShortMonths: [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"},
+		Words: Words{
+			Free: "free", BusyUntil: "busy until %s", Next: "next: %s at %s",
+			Busy: "busy %s", BusyMorning: "busy %s morning", BusyAfternoon: "busy %s afternoon", Away: "away %s",
+			Meeting: "%d meeting total", Meetings: "%d meetings total",
+			Timeline: "Busy times, %s to %s",
+		},
+	},
+	{
+		Days:        [7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		ShortDays:   [7]string{"dim.", "lun.", "mar.", "mer.", "jeu.", "ven.", "sam."},
+		Months:      [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		ShortMonths: [12]string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+		Words: Words{
+			Free: "libre", BusyUntil: "occupé jusqu'à %s", Next: "ensuite : %s à %s",
+			Busy: "occupé %s", BusyMorning: "occupé %s matin", BusyAfternoon: "occupé %s après-midi", Away: "absent %s",
+			Meeting: "%d réunion au total", Meetings: "%d réunions au total", ZeroIsOne: true,
+			Timeline: "Plages occupées, du %s au %s",
+		},
+	},
+	{
+		Days:        [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		ShortDays:   [7]string{"So.", "Mo.", "Di.", "Mi.", "Do.", "Fr.", "Sa."},
+		Months:      [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		ShortMonths: [12]string{"Jan.", "Feb.", "März", "Apr.", "Mai", "Juni", "Juli", "Aug.", "Sept.", "Okt.", "Nov.", "Dez."},
+		Words: Words{
+			Free: "frei", BusyUntil: "beschäftigt bis %s", Next: "als Nächstes: %s um %s",
+			Busy: "%s beschäftigt", BusyMorning: "%s vormittags beschäftigt", BusyAfternoon: "%s nachmittags beschäftigt", Away: "%s abwesend",
+			Meeting: "%d Termin insgesamt", Meetings: "%d Termine insgesamt",
+			Timeline: "Belegte Zeiten, %s bis %s",
+		},
+	},
+	{
+		Days:        [7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		ShortDays:   [7]string{"dom.", "lun.", "mar.", "mié.", "jue.", "vie.", "sáb."},
+		Months:      [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		ShortMonths: [12]string{"ene.", "feb.", "mar.", "abr.", "may.", "jun.", "jul.", "ago.", "sept.", "oct.", "nov.", "dic."},
+		Words: Words{
+			Free: "libre", BusyUntil: "ocupado hasta las %s", Next: "a continuación: %s a las %s",
+			Busy: "ocupado el %s", BusyMorning: "ocupado el %s por la mañana", BusyAfternoon: "ocupado el %s por la tarde", Away: "fuera el %s",
+			Meeting: "%d reunión en total", Meetings: "%d reuniones en total",
+			Timeline: "Horas ocupadas, del %s al %s",
+		},
+	},
+}
+
+// hour12 are the regions that use a 12-hour clock, and sundays those whose
+// weeks start on Sunday.
+var (
+	hour12  = []string{"US", "AU", "NZ", "PH", "IN"}
+	sundays = []string{"US", "CA", "MX", "BR", "JP", "IL", "PH"}
+)
+
+// Default is the locale the outputs were always written in.
+var Default = func() *Locale {
+	l := languages[0]
+	l.Tag = language.English
+	l.FirstDay = time.Monday
+	return &l
+}()
+
+var current = Default
+
+// Parse returns the locale for a BCP 47 tag like fr or en-US: the names
+// of the closest supported language, with the clock and first day of the
+// week of the tag's region, or its language's usual region.
+func Parse(s string) (*Locale, error) {
+	tag, err := language.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid locale %q: %w", s, err)
+	}
+	_, i, conf := language.NewMatcher(supported).Match(tag)
+	if conf == language.No {
+		return nil, fmt.Errorf("unsupported locale %q: want one of en, fr, de or es", s)
+	}
+	l := languages[i]
+	l.Tag = tag
+	region, _ := tag.Region()
+	base, _ := tag.Base()
+	l.Hour12 = has(hour12, region.String()) || region.String() == "CA" && base.String() == "en"
+	l.FirstDay = time.Monday
+	if has(sundays, region.String()) {
+		l.FirstDay = time.Sunday
+	}
+	return &l, nil
+}
+
+func has(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Current returns the locale set with Set, or Default.
+func Current() *Locale {
+	return current
+}
+
+// Set makes l the locale outputs are written in. It's meant to be called
+// at startup, before any are.
+func Set(l *Locale) {
+	current = l
+}
+
+// LastDay is the day the week ends on.
+func (l *Locale) LastDay() time.Weekday {
+	return (l.FirstDay + 6) % 7
+}
+
+// Clock formats the time of day of t, as 15:04 or 3:04 PM.
+func (l *Locale) Clock(t time.Time) string {
+	if l.Hour12 {
+		return t.Format("3:04 PM")
+	}
+	return t.Format("15:04")
+}
+
+// names are the layout elements Format translates.
+var names = []string{"Monday", "January", "Mon", "Jan"}
+
+// Format is t.Format(layout) with the day and month names in l's
+// language. Each name is formatted on its own, so a translated name is
+// never read as part of the layout.
+func (l *Locale) Format(t time.Time, layout string) string {
+	var b strings.Builder
+	for layout != "" {
+		i, name := len(layout), ""
+		for _, n := range names {
+			if j := strings.Index(layout, n); j >= 0 && (j < i || j == i && len(n) > len(name)) {
+				i, name = j, n
+			}
+		}
+		b.WriteString(t.Format(layout[:i]))
+		switch name {
+		case "Monday":
+			b.WriteString(l.Days[t.Weekday()])
+		case "Mon":
+			b.WriteString(l.ShortDays[t.Weekday()])
+		case "January":
+			b.WriteString(l.Months[t.Month()-1])
+		case "Jan":
+			b.WriteString(l.ShortMonths[t.Month()-1])
+		}
+		layout = layout[i+len(name):]
+	}
+	return b.String()
+}
+
+// Meetings says how many meetings there are in all, as in "11 meetings
+// total".
+func (l *Locale) Meetings(n int) string {
+	if n == 1 || n == 0 && l.Words.ZeroIsOne {
+		return fmt.Sprintf(l.Words.Meeting, n)
+	}
+	return fmt.Sprintf(l.Words.Meetings, n)
+}
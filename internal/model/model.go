@@ -0,0 +1,111 @@
+// Package model defines the calendar document that gets encrypted and
+// published.
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type Calendar struct {
+	Events      []Event   `json:"events"`
+	DateCreated time.Time `json:"dateCreated"`
+	// Version is the build of calendar-setup that generated the calendar.
+	Version string `json:"version,omitempty"`
+	// Sources are the configured calendars as of the run, so a page can
+	// say one is out of date rather than silently showing fewer events.
+	Sources []Source `json:"sources,omitempty"`
+	// Degraded is set if some of the calendars failed, so the events are
+	// only those of the rest, or older ones; Failed lists them.
+	Degraded bool  `json:"degraded,omitempty"`
+	Failed   []int `json:"failed,omitempty"`
+}
+
+// SetSources sets c's sources, marking it degraded if any of them failed.
+func (c *Calendar) SetSources(sources []Source) {
+	c.Sources, c.Failed = sources, nil
+	for _, s := range sources {
+		if s.Status != SourceOK {
+			c.Failed = append(c.Failed, s.Calendar)
+		}
+	}
+	c.Degraded = len(c.Failed) > 0
+}
+
+// Source is the state of one configured calendar.
+type Source struct {
+	// Calendar counts from 1, like CALENDAR_<n>.
+	Calendar int    `json:"calendar"`
+	Status   string `json:"status"`
+	// Updated is when a stale calendar was last fetched.
+	Updated time.Time `json:"updated,omitzero"`
+	// Events is how many of the calendar's events were published.
+	Events int `json:"events"`
+	// Skipped is how many of its events couldn't be read, and Truncated
+	// the limits it was cut short by, named by their flags.
+	Skipped   int      `json:"skipped,omitempty"`
+	Truncated []string `json:"truncated,omitempty"`
+}
+
+// The statuses of a Source.
+const (
+	// SourceOK is a calendar fetched by the run.
+	SourceOK = "ok"
+	// SourceStale is one the run couldn't fetch, whose events are from
+	// the last run that did.
+	SourceStale = "stale"
+	// SourceFailed is one the run couldn't fetch, or some of whose feeds
+	// it couldn't, with nothing to fall back on.
+	SourceFailed = "failed"
+)
+
+type Event struct {
+	Title string    `json:"title"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// Private is set for CLASS:PRIVATE and CONFIDENTIAL events, whose
+	// titles are hidden from "public" tiers. It survives render's JSON so
+	// encrypt can still build the tiers, but is never published.
+	Private bool `json:"private,omitempty"`
+
+	// Categories are the VEVENT's CATEGORIES, for icon rules. Like
+	// Private they survive render's JSON but are never published.
+	Categories []string `json:"categories,omitempty"`
+	// Icon is set by the config's icon rules, like ✈️ for travel.
+	Icon string `json:"icon,omitempty"`
+
+	// Calendar is the number of the feed the event came from, for serve's
+	// API and icon rules. Like Private it survives render's JSON but is
+	// never published.
+	Calendar int `json:"calendar,omitempty"`
+	// UID is the UID of the VEVENT the event came from, which with its
+	// start identifies it in the store. It's never published either.
+	UID string `json:"-"`
+
+	// Times are the start and end in each of encrypt's -viewer-zones, so a
+	// page can show "19:00 CET / 13:00 EST" without a time zone database.
+	Times []ZonedTimes `json:"times,omitempty"`
+}
+
+// ZonedTimes are an event's start and end in one time zone, marshalled
+// with its offset.
+type ZonedTimes struct {
+	// Zone is the zone's name, like America/New_York, and Abbr its
+	// abbreviation at the start, like EST.
+	Zone  string    `json:"zone"`
+	Abbr  string    `json:"abbr"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Normalize decodes a decrypted payload into a Calendar and re-encodes it,
+// so readers only ever see fields the encoder knows about.
+func Normalize(plaintext []byte) ([]byte, error) {
+	var cal Calendar
+	if err := json.Unmarshal(plaintext, &cal); err != nil {
+		return nil, fmt.Errorf("decrypted payload is not a calendar: %w", err)
+	}
+	return json.Marshal(cal)
+}
@@ -0,0 +1,182 @@
+// Package notify tells other systems how a run went.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+// Services with built-in notifiers.
+const (
+	ServiceNtfy     = "ntfy"
+	ServicePushover = "pushover"
+	ServiceSlack    = "slack"
+	ServiceEmail    = "email"
+)
+
+// When a notifier is sent a message.
+const (
+	OnFailure   = "failure"   // after a run that didn't fully succeed (the default)
+	OnAlways    = "always"    // after every run
+	OnDaily     = "daily"     // a summary after the first run of each day
+	OnUnchanged = "unchanged" // when a feed is first found not to have changed in -unchanged-days
+	OnEmpty     = "empty"     // when a feed that usually has events first parses to none
+)
+
+// Config is one notifier in the config file's notify list.
+type Config struct {
+	// Service is "ntfy", "pushover", "slack" or "email".
+	Service string `json:"service"`
+	// URL is the ntfy topic URL or the Slack incoming-webhook URL.
+	URL string `json:"url,omitempty"`
+	// Token is the Pushover application token. User is the Pushover user
+	// key, or the SMTP username.
+	Token string `json:"token,omitempty"`
+	User  string `json:"user,omitempty"`
+
+	// SMTP is the mail server's host:port. From and To are the sender
+	// and the (comma-separated) recipients; Password goes with User.
+	SMTP     string `json:"smtp,omitempty"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// On is "failure", "always", "daily", "unchanged" or "empty".
+	On string `json:"on,omitempty"`
+	// Agenda adds the next day's events to daily messages.
+	Agenda bool `json:"agenda,omitempty"`
+}
+
+// Validate checks c has what its service needs.
+func (c Config) Validate() error {
+	switch c.On {
+	case "", OnFailure, OnAlways, OnDaily, OnUnchanged, OnEmpty:
+	default:
+		return fmt.Errorf("on must be %q, %q, %q, %q or %q", OnFailure, OnAlways, OnDaily, OnUnchanged, OnEmpty)
+	}
+	switch c.Service {
+	case ServiceNtfy, ServiceSlack:
+		if c.URL == "" {
+			return fmt.Errorf("%s needs a url", c.Service)
+		}
+	case ServicePushover:
+		if c.Token == "" || c.User == "" {
+			return fmt.Errorf("pushover needs a token and user")
+		}
+	case ServiceEmail:
+		if c.SMTP == "" || c.From == "" || c.To == "" {
+			return fmt.Errorf("email needs smtp, from and to")
+		}
+		if _, _, err := net.SplitHostPort(c.SMTP); err != nil {
+			return fmt.Errorf("smtp: %w", err)
+		}
+		if _, err := mail.ParseAddress(c.From); err != nil {
+			return fmt.Errorf("from: %w", err)
+		}
+		if _, err := mail.ParseAddressList(c.To); err != nil {
+			return fmt.Errorf("to: %w", err)
+		}
+	default:
+		return fmt.Errorf("service must be %q, %q, %q or %q", ServiceNtfy, ServicePushover, ServiceSlack, ServiceEmail)
+	}
+	return nil
+}
+
+// When returns c.On, defaulted.
+func (c Config) When() string {
+	if c.On == "" {
+		return OnFailure
+	}
+	return c.On
+}
+
+// Message is a notification.
+type Message struct {
+	Title string
+	Body  string
+	// Urgent marks failures, which services can show more prominently.
+	Urgent bool
+}
+
+// pushoverURL is Pushover's message API.
+const pushoverURL = "https://api.pushover.net/1/messages.json"
+
+// Send sends msg with the notifier c.
+func Send(ctx context.Context, c Config, msg Message) error {
+	switch c.Service {
+	case ServiceNtfy:
+		header := http.Header{"Title": {msg.Title}}
+		if msg.Urgent {
+			header.Set("Priority", "high")
+			header.Set("Tags", "warning")
+		}
+		return post(ctx, c.URL, "text/plain; charset=utf-8", []byte(msg.Body), header)
+
+	case ServicePushover:
+		form := url.Values{"token": {c.Token}, "user": {c.User}, "title": {msg.Title}, "message": {msg.Body}}
+		if msg.Urgent {
+			form.Set("priority", "1")
+		}
+		return post(ctx, pushoverURL, "application/x-www-form-urlencoded", []byte(form.Encode()), nil)
+
+	case ServiceSlack:
+		payload, err := json.Marshal(map[string]string{"text": "*" + msg.Title + "*\n" + msg.Body})
+		if err != nil {
+			return err
+		}
+		return post(ctx, c.URL, "application/json", payload, nil)
+
+	case ServiceEmail:
+		return sendEmail(ctx, c, msg)
+	}
+	return fmt.Errorf("unknown service %q", c.Service)
+}
+
+// Webhook POSTs payload to rawURL as JSON.
+func Webhook(ctx context.Context, rawURL string, payload []byte) error {
+	return post(ctx, rawURL, "application/json", payload, nil)
+}
+
+// post POSTs body to rawURL. Errors name only the URL's scheme and host,
+// since webhook URLs usually embed a secret.
+func post(ctx context.Context, rawURL, contentType string, body []byte, header http.Header) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid URL")
+	}
+	name := u.Scheme + "://" + u.Host + "/..."
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("posting to %s: %w", name, err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// *url.Error quotes the whole URL
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return fmt.Errorf("posting to %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("posting to %s: %s %s", name, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
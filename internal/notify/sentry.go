@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// SentryConfig is the config file's sentry section: the Sentry project
+// that panics and failed calendars are reported to.
+type SentryConfig struct {
+	// DSN is the project's client key, https://<key>@<host>/<project>. It
+	// may be a secret reference.
+	DSN string `json:"dsn"`
+	// Environment, if set, is reported with every event, like
+	// "production".
+	Environment string `json:"environment,omitempty"`
+}
+
+// Validate checks c's DSN.
+func (c SentryConfig) Validate() error {
+	_, _, err := c.endpoint()
+	return err
+}
+
+// endpoint returns the envelope URL and public key the DSN names.
+func (c SentryConfig) endpoint() (endpoint, key string, err error) {
+	u, err := url.Parse(c.DSN)
+	if err != nil || u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return "", "", fmt.Errorf("dsn must look like https://<key>@<host>/<project>")
+	}
+	prefix, project := "", strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	if project == "" {
+		return "", "", fmt.Errorf("dsn has no project ID")
+	}
+	return u.Scheme + "://" + u.Host + prefix + "/api/" + project + "/envelope/", u.User.Username(), nil
+}
+
+// SentryEvent is an error reported to Sentry.
+type SentryEvent struct {
+	Message string
+	// Level is "error", or "fatal" for a panic.
+	Level string
+	// Tags are indexed by Sentry, for searching and grouping.
+	Tags map[string]string
+	// Fingerprint, if set, groups events into issues instead of Sentry's
+	// grouping by message, which would split on every changing detail.
+	Fingerprint []string
+	// Stack is a panic's stack trace, as runtime/debug.Stack gives it.
+	Stack string
+	// Release is the build that sent the event.
+	Release string
+}
+
+// SendSentry reports ev to the project in c.
+func SendSentry(ctx context.Context, c SentryConfig, ev SentryEvent) error {
+	endpoint, key, err := c.endpoint()
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	event := map[string]any{
+		"event_id":  hex.EncodeToString(id),
+		"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+		"platform":  "go",
+		"logger":    "calendar-setup",
+		"level":     ev.Level,
+		"message":   map[string]string{"formatted": ev.Message},
+		"tags":      ev.Tags,
+	}
+	if host, err := os.Hostname(); err == nil {
+		event["server_name"] = host
+	}
+	if c.Environment != "" {
+		event["environment"] = c.Environment
+	}
+	if ev.Release != "" {
+		event["release"] = ev.Release
+	}
+	if len(ev.Fingerprint) > 0 {
+		event["fingerprint"] = ev.Fingerprint
+	}
+	if ev.Stack != "" {
+		event["extra"] = map[string]string{"stack": ev.Stack}
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	// an envelope: its header, then one item's header and payload
+	var body bytes.Buffer
+	fmt.Fprintf(&body, `{"event_id":%q,"sent_at":%q}`+"\n", event["event_id"], event["timestamp"])
+	fmt.Fprintf(&body, `{"type":"event","length":%d}`+"\n", len(payload))
+	body.Write(payload)
+	body.WriteByte('\n')
+	header := http.Header{"X-Sentry-Auth": {"Sentry sentry_version=7, sentry_client=calendar-setup, sentry_key=" + key}}
+	return post(ctx, endpoint, "application/x-sentry-envelope", body.Bytes(), header)
+}
@@ -0,0 +1,56 @@
+package output
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jackdorland/www/internal/model"
+)
+
+// Availability is availability.json: for each slot from the run to the end
+// of the window, how many of the people whose calendars are published are
+// free, for a team page. It's published unencrypted, so it says nothing of
+// who is busy or with what.
+type Availability struct {
+	Generated time.Time `json:"generated"`
+	// People is how many calendars are counted; one that failed isn't,
+	// since it would look free.
+	People      int    `json:"people"`
+	SlotMinutes int    `json:"slotMinutes"`
+	Slots       []Slot `json:"slots"`
+}
+
+// Slot is one slot of Availability.
+type Slot struct {
+	Start time.Time `json:"start"`
+	Free  int       `json:"free"`
+}
+
+// TeamAvailability counts, for each slot of length slot from now (rounded
+// down to a slot) until to, how many of people's calendars have no event
+// in it. people are the calendars' numbers, and events, which should
+// include any under way, must say which calendar each is from.
+func TeamAvailability(events []model.Event, people []int, now, to time.Time, slot time.Duration) (Availability, error) {
+	counted := make(map[int]bool)
+	for _, p := range people {
+		counted[p] = true
+	}
+	for _, e := range events {
+		if e.Calendar == 0 {
+			return Availability{}, errors.New("the events don't say which calendar each is from")
+		}
+	}
+
+	a := Availability{Generated: now, People: len(people), SlotMinutes: int(slot / time.Minute), Slots: []Slot{}}
+	for start := now.Truncate(slot); start.Before(to); start = start.Add(slot) {
+		end := start.Add(slot)
+		busy := make(map[int]bool)
+		for _, e := range events {
+			if counted[e.Calendar] && e.Start.Before(end) && e.End.After(start) {
+				busy[e.Calendar] = true
+			}
+		}
+		a.Slots = append(a.Slots, Slot{Start: start.UTC(), Free: len(people) - len(busy)})
+	}
+	return a, nil
+}
@@ -0,0 +1,30 @@
+package output
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jackdorland/www/internal/model"
+)
+
+// Badge is a status badge in the shields.io endpoint schema, for
+// https://img.shields.io/endpoint?url=<where it's served>.
+type Badge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+}
+
+// StatusBadge says whether one of events is on at now: "In a meeting
+// until 15:00", the end in loc, or "Free". Back-to-back and overlapping
+// events count as one meeting. Like TimelineSVG it never shows titles.
+func StatusBadge(events []model.Event, now time.Time, loc *time.Location) Badge {
+	sorted := append([]model.Event(nil), events...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+	until := busyUntil(sorted, now)
+	if until.IsZero() {
+		return Badge{SchemaVersion: 1, Label: "status", Message: "Free", Color: "brightgreen"}
+	}
+	return Badge{SchemaVersion: 1, Label: "status", Message: "In a meeting until " + clockTime(until, now, loc), Color: "red"}
+}
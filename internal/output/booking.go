@@ -0,0 +1,84 @@
+package output
+
+import (
+	"time"
+
+	"github.com/jackdorland/www/internal/model"
+)
+
+// BookingRules are what BookingSlots offers: meetings of Length, in Hours on
+// weekdays, with Buffer free either side, starting no sooner than Notice
+// from now, on days with fewer than MaxPerDay events in working hours (0
+// for no limit).
+type BookingRules struct {
+	Length    time.Duration
+	Buffer    time.Duration
+	Notice    time.Duration
+	MaxPerDay int
+	Hours     WorkingHours
+}
+
+// Booking is booking.json: the times a visitor may propose a meeting, for
+// a booking page. Like Availability it's unencrypted, and says nothing of
+// the events around them.
+type Booking struct {
+	Generated    time.Time    `json:"generated"`
+	Minutes      int          `json:"minutes"`
+	WorkingHours string       `json:"workingHours"`
+	TimeZone     string       `json:"timeZone"`
+	Days         []BookingDay `json:"days"`
+}
+
+// BookingDay is a weekday's bookable slots, if it has any.
+type BookingDay struct {
+	Date  string `json:"date"`
+	Slots []Free `json:"slots"`
+}
+
+// BookingSlots finds the slots in loc from now until to that rules allow,
+// starting each day at the start of working hours and every Length after,
+// against all of events, whichever calendar they're from.
+func BookingSlots(events []model.Event, now, to time.Time, rules BookingRules, loc *time.Location) Booking {
+	b := Booking{Generated: now, Minutes: int(rules.Length / time.Minute), WorkingHours: rules.Hours.String(), TimeZone: loc.String(), Days: []BookingDay{}}
+	earliest := now.Add(rules.Notice)
+	local := now.In(loc)
+	for day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			continue
+		}
+		// as in CommonFreeSlots, built from the date for DST's sake
+		opens := time.Date(day.Year(), day.Month(), day.Day(), 0, rules.Hours.Start, 0, 0, loc)
+		closes := time.Date(day.Year(), day.Month(), day.Day(), 0, rules.Hours.End, 0, 0, loc)
+		if rules.MaxPerDay > 0 && meetings(events, opens, closes) >= rules.MaxPerDay {
+			continue
+		}
+		d := BookingDay{Date: day.Format(time.DateOnly)}
+		for start := opens; !start.Add(rules.Length).After(closes); start = start.Add(rules.Length) {
+			end := start.Add(rules.Length)
+			if start.Before(earliest) || end.After(to) || busy(events, start.Add(-rules.Buffer), end.Add(rules.Buffer)) {
+				continue
+			}
+			d.Slots = append(d.Slots, Free{start, end})
+		}
+		if len(d.Slots) > 0 {
+			b.Days = append(b.Days, d)
+		}
+	}
+	return b
+}
+
+// meetings counts the events overlapping from to until.
+func meetings(events []model.Event, from, until time.Time) int {
+	n := 0
+	for _, e := range events {
+		if e.Start.Before(until) && e.End.After(from) {
+			n++
+		}
+	}
+	return n
+}
+
+// busy reports whether any event overlaps from to until.
+func busy(events []model.Event, from, until time.Time) bool {
+	return meetings(events, from, until) > 0
+}
@@ -0,0 +1,106 @@
+package output
+
+import (
+	"bytes"
+	"html/template"
+)
+
+// demoTemplate is demo.html: a standalone page that asks for the key,
+// fetches an output, decrypts it in the browser with decrypt.js and lists
+// the events. It's a reference for reading the format and a quick check
+// that a deploy is readable.
+var demoTemplate = template.Must(template.New("demo.html").Parse(`<!doctype html>
+<!-- Code generated by calendar-setup; DO NOT EDIT. -->
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Calendar decrypt demo</title>
+<style>
+  body { font: 16px/1.4 system-ui, sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; }
+  form { display: flex; gap: .5em; flex-wrap: wrap; }
+  input[type=password] { flex: 1; min-width: 16em; font-family: monospace; }
+  h2 { font-size: 1em; margin: 1.5em 0 .25em; border-bottom: 1px solid #ccc; }
+  ul { list-style: none; padding: 0; margin: 0; }
+  li time { display: inline-block; min-width: 8em; color: #555; font-variant-numeric: tabular-nums; }
+  .error { color: #b00; }
+  .meta { color: #555; font-size: .875em; }
+</style>
+</head>
+<body>
+<h1>Calendar decrypt demo</h1>
+<form id="form">
+  <select id="file"{{if eq (len .Files) 1}} hidden{{end}}>
+    {{range .Files}}<option>{{.}}</option>{{end}}
+  </select>
+  <input id="key" type="password" placeholder="hex key" autocomplete="off" required>
+  <button>Decrypt</button>
+</form>
+<p id="status" class="meta"></p>
+<div id="agenda"></div>
+<script type="module">
+import { DecryptCalendar } from "./decrypt.js";
+
+const $ = (id) => document.getElementById(id);
+const day = new Intl.DateTimeFormat(undefined, { weekday: "long", month: "long", day: "numeric" });
+const time = new Intl.DateTimeFormat(undefined, { hour: "2-digit", minute: "2-digit" });
+
+$("form").addEventListener("submit", async (e) => {
+  e.preventDefault();
+  $("status").className = "meta";
+  $("status").textContent = "Decrypting…";
+  $("agenda").replaceChildren();
+  try {
+    const res = await fetch($("file").value, { cache: "no-cache" });
+    if (!res.ok) throw new Error("fetching " + $("file").value + ": " + res.status);
+    const cal = await DecryptCalendar(await res.arrayBuffer(), $("key").value.trim());
+    render(cal);
+  } catch (err) {
+    $("status").className = "error";
+    $("status").textContent = err.message;
+  }
+});
+
+function render(cal) {
+  const events = (cal.events || []).map((e) => ({ ...e, start: new Date(e.start), end: new Date(e.end) }));
+  events.sort((a, b) => a.start - b.start);
+  $("status").textContent = events.length + " events, generated " + new Date(cal.dateCreated).toLocaleString() + (cal.version ? " by " + cal.version : "");
+  for (const s of cal.sources || []) {
+    if (s.status === "stale") {
+      $("status").append(document.createElement("br"), "Calendar " + s.calendar + " is out of date: it was last fetched " + new Date(s.updated).toLocaleString() + ".");
+    } else if (s.status === "failed") {
+      $("status").append(document.createElement("br"), "Calendar " + s.calendar + " couldn't be fetched; its events may be missing.");
+    }
+  }
+
+  let list, last;
+  for (const e of events) {
+    const heading = day.format(e.start);
+    if (heading !== last) {
+      const h = document.createElement("h2");
+      h.textContent = last = heading;
+      list = document.createElement("ul");
+      $("agenda").append(h, list);
+    }
+    const li = document.createElement("li");
+    const t = document.createElement("time");
+    t.dateTime = e.start.toISOString();
+    t.textContent = time.format(e.start) + "–" + time.format(e.end);
+    li.append(t, " ", e.title);
+    list.append(li);
+  }
+}
+</script>
+</body>
+</html>
+`))
+
+// DemoHTML renders demo.html for the outputs files, given relative to it.
+func DemoHTML(files []string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := demoTemplate.Execute(&buf, struct{ Files []string }{files}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,111 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackdorland/www/internal/model"
+)
+
+// WorkingHours are the hours of a weekday, Monday to Friday, that meetings
+// are suggested in, as minutes since midnight.
+type WorkingHours struct {
+	Start, End int
+}
+
+// ParseWorkingHours parses working hours like "09:00-17:30".
+func ParseWorkingHours(s string) (WorkingHours, error) {
+	var h WorkingHours
+	var h1, m1, h2, m2 int
+	if _, err := fmt.Sscanf(s, "%d:%d-%d:%d", &h1, &m1, &h2, &m2); err != nil || h1 > 24 || h2 > 24 || m1 > 59 || m2 > 59 {
+		return h, fmt.Errorf("invalid working hours %q: want a range like 09:00-17:00", s)
+	}
+	h.Start, h.End = h1*60+m1, h2*60+m2
+	if h.Start >= h.End {
+		return h, fmt.Errorf("invalid working hours %q: they end before they start", s)
+	}
+	return h, nil
+}
+
+// String formats h as ParseWorkingHours reads it.
+func (h WorkingHours) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", h.Start/60, h.Start%60, h.End/60, h.End%60)
+}
+
+// FreeSlots is free-slots.json: the times before the end of the window
+// when everyone is free for a meeting, for a page suggesting them. Like
+// Availability it's unencrypted, and says nothing of anyone's events.
+type FreeSlots struct {
+	Generated time.Time `json:"generated"`
+	// People is how many calendars are counted, as in Availability.
+	People       int    `json:"people"`
+	Minutes      int    `json:"minutes"`
+	WorkingHours string `json:"workingHours"`
+	TimeZone     string `json:"timeZone"`
+	Slots        []Free `json:"slots"`
+}
+
+// Free is a stretch of working hours when everyone is free, at least
+// FreeSlots.Minutes long.
+type Free struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// CommonFreeSlots finds the stretches of hours in loc, on weekdays from
+// now until to, of at least length when none of people's calendars has an
+// event. people and events are as TeamAvailability takes them.
+func CommonFreeSlots(events []model.Event, people []int, now, to time.Time, length time.Duration, hours WorkingHours, loc *time.Location) (FreeSlots, error) {
+	counted := make(map[int]bool)
+	for _, p := range people {
+		counted[p] = true
+	}
+	var busy []model.Event
+	for _, e := range events {
+		if e.Calendar == 0 {
+			return FreeSlots{}, errors.New("the events don't say which calendar each is from")
+		}
+		if counted[e.Calendar] {
+			busy = append(busy, e)
+		}
+	}
+	sort.Slice(busy, func(i, j int) bool { return busy[i].Start.Before(busy[j].Start) })
+
+	fs := FreeSlots{Generated: now, People: len(people), Minutes: int(length / time.Minute), WorkingHours: hours.String(), TimeZone: loc.String(), Slots: []Free{}}
+	local := now.In(loc)
+	for day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			continue
+		}
+		// built from the date, not added to midnight, so a DST change
+		// doesn't shift the hours
+		start := time.Date(day.Year(), day.Month(), day.Day(), 0, hours.Start, 0, 0, loc)
+		end := time.Date(day.Year(), day.Month(), day.Day(), 0, hours.End, 0, 0, loc)
+		if start.Before(now) {
+			start = now
+		}
+		if end.After(to) {
+			end = to
+		}
+		// sweep the day's events, which are sorted by start
+		free := start
+		for _, e := range busy {
+			if !e.Start.Before(end) {
+				break
+			}
+			if !e.End.After(free) {
+				continue
+			}
+			if e.Start.Sub(free) >= length {
+				fs.Slots = append(fs.Slots, Free{free.In(loc), e.Start.In(loc)})
+			}
+			free = e.End
+		}
+		if end.Sub(free) >= length {
+			fs.Slots = append(fs.Slots, Free{free.In(loc), end})
+		}
+	}
+	return fs, nil
+}
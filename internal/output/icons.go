@@ -0,0 +1,77 @@
+package output
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jackdorland/www/internal/model"
+)
+
+// IconRule attaches an icon, usually an emoji, to the events it matches,
+// so a page can show one without rules of its own. An event matches if it
+// meets every condition set; the first rule to match wins.
+type IconRule struct {
+	Icon string `json:"icon"`
+	// Title is a regular expression, matched case-insensitively.
+	Title string `json:"title,omitempty"`
+	// Category is one of the event's CATEGORIES, in any case.
+	Category string `json:"category,omitempty"`
+	// Calendar is the number of the feed the event came from.
+	Calendar int `json:"calendar,omitempty"`
+}
+
+// TitleRegexp compiles Title, which ValidateIcons has checked.
+func (r IconRule) TitleRegexp() *regexp.Regexp {
+	if r.Title == "" {
+		return nil
+	}
+	return regexp.MustCompile("(?i)" + r.Title)
+}
+
+// ValidateIcons checks the config's icon rules.
+func ValidateIcons(rules []IconRule) error {
+	for i, r := range rules {
+		if r.Icon == "" {
+			return fmt.Errorf("icons[%d]: missing icon", i)
+		}
+		if r.Title == "" && r.Category == "" && r.Calendar == 0 {
+			return fmt.Errorf("icons[%d]: set title, category or calendar to match", i)
+		}
+		if _, err := regexp.Compile("(?i)" + r.Title); err != nil {
+			return fmt.Errorf("icons[%d]: invalid title: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// WithIcons returns copies of events with the Icon of the first of rules
+// each matches, if any.
+func WithIcons(events []model.Event, rules []IconRule) []model.Event {
+	titles := make([]*regexp.Regexp, len(rules))
+	for i, r := range rules {
+		titles[i] = r.TitleRegexp()
+	}
+	out := make([]model.Event, len(events))
+	for i, e := range events {
+		for j, r := range rules {
+			if (titles[j] == nil || titles[j].MatchString(e.Title)) &&
+				(r.Category == "" || hasCategory(e, r.Category)) &&
+				(r.Calendar == 0 || r.Calendar == e.Calendar) {
+				e.Icon = r.Icon
+				break
+			}
+		}
+		out[i] = e
+	}
+	return out
+}
+
+func hasCategory(e model.Event, category string) bool {
+	for _, c := range e.Categories {
+		if strings.EqualFold(c, category) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package output
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+
+	"github.com/jackdorland/www/internal/clock"
+	"github.com/jackdorland/www/internal/model"
+)
+
+// ICS renders events as an iCalendar feed for calendar apps to subscribe
+// to, asking them to refetch every refresh. Private events are titled
+// "Busy". UIDs are derived from each event, so an unchanged event keeps
+// its UID from one feed to the next.
+func ICS(events []model.Event, refresh time.Duration) []byte {
+	cal := ics.NewCalendarFor("calendar-setup")
+	cal.SetMethod(ics.MethodPublish)
+	cal.SetXWRCalName("Calendar")
+	cal.SetRefreshInterval(isoDuration(refresh))
+	cal.SetXPublishedTTL(isoDuration(refresh))
+	now := clock.Now()
+	for _, e := range events {
+		title := e.Title
+		if e.Private {
+			title = "Busy"
+		}
+		sum := sha256.Sum256(fmt.Appendf(nil, "%d\x00%d\x00%s", e.Start.Unix(), e.End.Unix(), title))
+		ev := cal.AddEvent(hex.EncodeToString(sum[:16]) + "@calendar-setup")
+		ev.SetDtStampTime(now)
+		ev.SetStartAt(e.Start)
+		ev.SetEndAt(e.End)
+		ev.SetSummary(title)
+		if e.Private {
+			ev.SetClass(ics.ClassificationPrivate)
+		}
+	}
+	return []byte(cal.Serialize())
+}
+
+// isoDuration formats d as an RFC 5545 duration, like PT15M.
+func isoDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	if d <= 0 {
+		return "PT0S"
+	}
+	var b strings.Builder
+	b.WriteString("PT")
+	if h := d / time.Hour; h > 0 {
+		fmt.Fprintf(&b, "%dH", h)
+	}
+	if m := d % time.Hour / time.Minute; m > 0 {
+		fmt.Fprintf(&b, "%dM", m)
+	}
+	if s := d % time.Minute / time.Second; s > 0 {
+		fmt.Fprintf(&b, "%dS", s)
+	}
+	return b.String()
+}
@@ -0,0 +1,100 @@
+package output
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ManifestName is the manifest's file name, next to the outputs.
+const ManifestName = "manifest.json"
+
+// Manifest maps the outputs' logical names (cal.aes) to copies named by
+// their content (cal.3f2a9c0d1e4b.aes), which a CDN can cache forever:
+// a page reads the manifest, with a short cache lifetime, to find the
+// current copy. Names are relative to the manifest.
+type Manifest struct {
+	Generated time.Time         `json:"generated"`
+	Files     map[string]string `json:"files"`
+	// Previous are the files of earlier generations still published,
+	// newest first, so pages loaded before an update keep working.
+	Previous []map[string]string `json:"previous,omitempty"`
+}
+
+// HashedName is path with the first 12 hex digits of data's SHA-256
+// inserted before its extension.
+func HashedName(path string, data []byte) string {
+	sum := sha256.Sum256(data)
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + hex.EncodeToString(sum[:6]) + ext
+}
+
+// Rotate adds prev's generations to m's, keeping keep generations in
+// all, m's included, and returns the files of those dropped that no kept
+// generation still uses.
+func (m *Manifest) Rotate(prev *Manifest, keep int) (stale []string) {
+	gens := []map[string]string{m.Files}
+	if prev != nil {
+		gens = append(gens, prev.Files)
+		gens = append(gens, prev.Previous...)
+	}
+	// an unchanged output has the same name in consecutive generations
+	var kept []map[string]string
+	for _, g := range gens[1:] {
+		if len(g) > 0 && !sameFiles(g, kept, m.Files) {
+			kept = append(kept, g)
+		}
+	}
+	if keep < 1 {
+		keep = 1
+	}
+	var dropped []map[string]string
+	if len(kept) > keep-1 {
+		kept, dropped = kept[:keep-1], kept[keep-1:]
+	}
+	m.Previous = kept
+
+	inUse := make(map[string]bool)
+	for _, g := range append([]map[string]string{m.Files}, kept...) {
+		for _, name := range g {
+			inUse[name] = true
+		}
+	}
+	seen := make(map[string]bool)
+	for _, g := range dropped {
+		for _, name := range g {
+			if !inUse[name] && !seen[name] {
+				seen[name] = true
+				stale = append(stale, name)
+			}
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// sameFiles reports whether g is the same generation as current or one
+// already kept.
+func sameFiles(g map[string]string, kept []map[string]string, current map[string]string) bool {
+	for _, k := range append([]map[string]string{current}, kept...) {
+		if equalFiles(g, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalFiles(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
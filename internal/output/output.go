@@ -0,0 +1,96 @@
+// Package output builds encrypted calendars and decides what each tier
+// publishes. Writing them is left to a sink.
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jackdorland/www/internal/clock"
+	"github.com/jackdorland/www/internal/crypto"
+	"github.com/jackdorland/www/internal/model"
+	"github.com/jackdorland/www/internal/timing"
+	"github.com/jackdorland/www/internal/version"
+)
+
+// Marshal returns the calendar JSON published for events, from sources.
+func Marshal(events []model.Event, sources []model.Source) ([]byte, error) {
+	defer timing.Since(timing.Serialize, time.Now())
+	jsonData, err := json.Marshal(published(events, sources))
+	if err != nil {
+		return nil, fmt.Errorf("marshalling calendar: %w", err)
+	}
+	return jsonData, nil
+}
+
+// published is the calendar published for events.
+func published(events []model.Event, sources []model.Source) model.Calendar {
+	// the tiers and icons have already used these; don't publish them
+	out := make([]model.Event, len(events))
+	for i, e := range events {
+		e.Private, e.Categories, e.Calendar = false, nil, 0
+		out[i] = e
+	}
+	cal := model.Calendar{Events: out, DateCreated: clock.Now(), Version: version.Get().Short()}
+	cal.SetSources(sources)
+	return cal
+}
+
+// Encrypt marshals events into the published calendar JSON and encrypts
+// it. The JSON is written an event at a time straight into the output and
+// encrypted there (see crypto.EncryptCalendar), so a calendar of tens of MB
+// is never also held as a separate plaintext or ciphertext.
+func Encrypt(cfg *crypto.Config, cipherName string, passphrase bool, events []model.Event, sources []model.Source) ([]byte, error) {
+	// the JSON is written as it's encrypted, so the stages are told apart
+	// by timing the writing; age encrypts as it's written, so for age
+	// serialize includes most of the encrypting
+	start := time.Now()
+	var marshalling time.Duration
+	output, err := crypto.EncryptCalendar(cfg, cipherName, passphrase, func(w io.Writer) error {
+		marshalStart := time.Now()
+		defer func() { marshalling += time.Since(marshalStart) }()
+		if err := writeCalendar(w, events, sources); err != nil {
+			return fmt.Errorf("marshalling calendar: %w", err)
+		}
+		return nil
+	})
+	timing.Add(timing.Serialize, marshalling)
+	timing.Add(timing.Encrypt, time.Since(start)-marshalling)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting calendar: %w", err)
+	}
+	return output, nil
+}
+
+// writeCalendar writes what Marshal returns to w, an event at a time.
+func writeCalendar(w io.Writer, events []model.Event, sources []model.Source) error {
+	// the fields around the events, in the order Marshal writes them;
+	// events come first, so theirs is the first []
+	frame, err := json.Marshal(published(nil, sources))
+	if err != nil {
+		return err
+	}
+	head, tail, _ := bytes.Cut(frame, []byte("[]"))
+	head = append(head, '[')
+	tail = append([]byte{']'}, tail...)
+
+	bw := bufio.NewWriter(w)
+	bw.Write(head)
+	for i, e := range events {
+		e.Private, e.Categories, e.Calendar = false, nil, 0
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		bw.Write(data)
+	}
+	bw.Write(tail)
+	return bw.Flush()
+}
@@ -0,0 +1,126 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/jackdorland/www/internal/locale"
+	"github.com/jackdorland/www/internal/model"
+)
+
+// The week view is grayscale, so it reads the same on an e-ink display.
+var (
+	pngBackground = color.Gray{0xff}
+	pngToday      = color.Gray{0xee}
+	pngGrid       = color.Gray{0xcc}
+	pngText       = color.Gray{0x33}
+	pngBusy       = color.Gray{0x55}
+)
+
+// WeekPNG draws the seven days from the one containing start as a grid of
+// busy blocks, in start's location: no titles, just when is taken. The
+// hours shown cover 8:00 to 20:00 and any event outside them.
+func WeekPNG(events []model.Event, start time.Time, width, height int) ([]byte, error) {
+	const header, gutter = 20, 36
+	if width < gutter+7*8 || height < header+24 {
+		return nil, fmt.Errorf("week image %dx%d is too small", width, height)
+	}
+	loc := start.Location()
+	day0 := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+	dayStart := func(i int) time.Time { return day0.AddDate(0, 0, i) }
+
+	// the hours to show
+	first, last := 8, 20
+	for _, e := range events {
+		for i := range 7 {
+			from, to := clip(e, dayStart(i), dayStart(i+1))
+			if !from.Before(to) {
+				continue
+			}
+			first = min(first, from.Hour())
+			last = max(last, to.Hour()+min(1, to.Minute()+to.Second()))
+			if to.Equal(dayStart(i + 1)) {
+				last = 24
+			}
+		}
+	}
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(pngBackground), image.Point{}, draw.Src)
+	fill := func(r image.Rectangle, c color.Color) {
+		draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+	}
+	text := &font.Drawer{Dst: img, Src: image.NewUniform(pngText), Face: basicfont.Face7x13}
+	label := func(s string, x, y int) {
+		text.Dot = fixed.P(x, y)
+		text.DrawString(s)
+	}
+
+	colW := float64(width-gutter) / 7
+	hourH := float64(height-header) / float64(last-first)
+	colX := func(i int) int { return gutter + int(float64(i)*colW) }
+	timeY := func(d time.Duration) int {
+		return header + int((d.Hours()-float64(first))*hourH)
+	}
+
+	fill(image.Rect(colX(0), header, colX(1), height), pngToday)
+	step := 1
+	for hourH*float64(step) < 16 {
+		step++
+	}
+	for h := first; h <= last; h += step {
+		y := timeY(time.Duration(h) * time.Hour)
+		fill(image.Rect(gutter, y, width, y+1), pngGrid)
+		if h < last {
+			label(fmt.Sprintf("%02d", h%24), 4, y+11)
+		}
+	}
+	for i := range 7 {
+		x := colX(i)
+		fill(image.Rect(x, 0, x+1, height), pngGrid)
+		d := dayStart(i)
+		name := locale.Current().Format(d, "Mon 2")
+		if font.MeasureString(text.Face, name).Ceil() > int(colW)-4 {
+			name = string([]rune(locale.Current().Format(d, "Mon"))[:1])
+		}
+		label(name, x+3, 14)
+
+		for _, e := range events {
+			from, to := clip(e, d, dayStart(i+1))
+			if !from.Before(to) {
+				continue
+			}
+			top, bottom := timeY(from.Sub(d)), timeY(to.Sub(d))
+			bottom = max(bottom, top+2)
+			fill(image.Rect(x+2, top+1, colX(i+1)-1, bottom), pngBusy)
+		}
+	}
+	fill(image.Rect(0, header, width, header+1), pngText)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// clip returns the part of e between from and to.
+func clip(e model.Event, from, to time.Time) (time.Time, time.Time) {
+	start, end := e.Start.In(from.Location()), e.End.In(from.Location())
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	return start, end
+}
@@ -0,0 +1,74 @@
+package output
+
+import (
+	"bytes"
+	"text/template"
+)
+
+// snippetTemplate is the embed snippet: one <script> element that fetches
+// the calendar from URL, decrypts it with the decrypt.js published next to
+// it and lists the next Days days of events in the element matching
+// Target. Strings are written as JSON, which escapes "<", so they can't
+// end the script. Tool and Format record what generated it; the snippet refuses a
+// calendar in any other container version rather than misreading it. The
+// key comes from the target's data-key, the page's #key=<hex> fragment,
+// or is asked for once and kept in localStorage.
+var snippetTemplate = template.Must(template.New("snippet").Funcs(templateFuncs).Parse(`<!-- calendar-setup {{.Tool}}, container v{{.Format}} -->
+<script type="module">
+const url = new URL({{json .URL}}, location.href), target = document.querySelector({{json .Target}});
+const FORMAT = {{.Format}}, DAYS = {{.Days}}, STORAGE_KEY = "cal-snippet-key";
+async function show(key) {
+  const { DecryptCalendar } = await import(new URL("decrypt.js?v=" + FORMAT, url));
+  const res = await fetch(url, { cache: "no-cache" });
+  if (!res.ok) throw new Error(res.status + " " + res.statusText);
+  const data = await res.arrayBuffer();
+  if (new Uint8Array(data)[4] !== FORMAT) throw new Error("calendar format changed; regenerate this snippet");
+  const cal = await DecryptCalendar(data, key);
+  const from = new Date(), to = new Date(+from + DAYS * 864e5);
+  const fmt = new Intl.DateTimeFormat(undefined, { weekday: "short", day: "numeric", month: "short", hour: "numeric", minute: "2-digit" });
+  const list = document.createElement("ul");
+  list.className = "cal-upcoming";
+  for (const e of (cal.events || []).filter((e) => new Date(e.end) > from && new Date(e.start) < to).sort((a, b) => a.start.localeCompare(b.start))) {
+    const li = document.createElement("li"), t = document.createElement("time");
+    t.dateTime = e.start;
+    t.textContent = fmt.format(new Date(e.start));
+    li.append(t, " ", e.title);
+    list.append(li);
+  }
+  if (!list.children.length) list.append(Object.assign(document.createElement("li"), { textContent: "Nothing coming up." }));
+  target.replaceChildren(list);
+}
+function ask() {
+  const form = document.createElement("form"), input = document.createElement("input");
+  Object.assign(input, { type: "password", placeholder: "Calendar key", autocomplete: "off" });
+  form.append(input, Object.assign(document.createElement("button"), { textContent: "Show" }));
+  form.onsubmit = (e) => { e.preventDefault(); localStorage.setItem(STORAGE_KEY, input.value.trim()); load(); };
+  target.replaceChildren(form);
+}
+function load() {
+  const key = target.dataset.key || new URLSearchParams(location.hash.slice(1)).get("key") || localStorage.getItem(STORAGE_KEY);
+  if (!key) return ask();
+  show(key).catch((err) => {
+    if (key === localStorage.getItem(STORAGE_KEY)) localStorage.removeItem(STORAGE_KEY);
+    target.textContent = "Calendar unavailable: " + err.message;
+  });
+}
+if (target) load();
+</script>
+`))
+
+// Snippet renders the embed snippet for the calendar published at url,
+// listing the next days days in the element matching the CSS selector
+// target. tool is the generating build's version and format the container
+// version it writes.
+func Snippet(url, target string, days int, tool string, format int) ([]byte, error) {
+	var buf bytes.Buffer
+	err := snippetTemplate.Execute(&buf, struct {
+		URL, Target, Tool string
+		Days, Format      int
+	}{url, target, tool, days, format})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
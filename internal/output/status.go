@@ -0,0 +1,109 @@
+package output
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackdorland/www/internal/locale"
+	"github.com/jackdorland/www/internal/model"
+)
+
+// Status is status.json: whether the calendar's owner is busy, and the
+// next event.
+type Status struct {
+	Generated time.Time `json:"generated"`
+	// State is "busy" or "free", for a widget to switch on.
+	State string `json:"state"`
+	Busy  bool   `json:"busy"`
+	// Until is when the meeting under way ends, if Busy, and NextBusy when
+	// the next one starts: after Until if Busy, so a meeting back-to-back
+	// with this one isn't taken for the next.
+	Until     time.Time  `json:"until,omitzero"`
+	NextBusy  time.Time  `json:"nextBusy,omitzero"`
+	NextEvent *NextEvent `json:"nextEvent,omitempty"`
+}
+
+// NextEvent is the next event to start, enough for a page to count down
+// to it without the rest of the calendar.
+type NextEvent struct {
+	Title string    `json:"title"`
+	Start time.Time `json:"start"`
+	// SecondsUntil is how long after Generated it starts, for clients
+	// whose clocks can't be trusted.
+	SecondsUntil int64 `json:"secondsUntil"`
+}
+
+// CurrentStatus works out the Status at now from events, which should
+// include any under way. Back-to-back and overlapping events count as one
+// meeting. Since the status is published unencrypted, titles are replaced
+// by label if it's set, and private events are titled Busy regardless.
+func CurrentStatus(events []model.Event, now time.Time, label string) Status {
+	sorted := append([]model.Event(nil), events...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+
+	st := Status{Generated: now, State: "free", Until: busyUntil(sorted, now)}
+	st.Busy = !st.Until.IsZero()
+	if st.Busy {
+		st.State = "busy"
+	}
+	for _, e := range sorted {
+		if e.Start.After(now) && e.Start.After(st.Until) {
+			st.NextBusy = e.Start
+			break
+		}
+	}
+	for _, e := range sorted {
+		if e.Start.After(now) {
+			title := e.Title
+			if label != "" {
+				title = label
+			} else if e.Private {
+				title = "Busy"
+			}
+			st.NextEvent = &NextEvent{Title: title, Start: e.Start, SecondsUntil: int64(e.Start.Sub(now) / time.Second)}
+			break
+		}
+	}
+	return st
+}
+
+// Text is status.txt: "busy until 15:00", "next: Standup at 09:30" or
+// "free", in the locale, with times in loc and the day added if it isn't
+// today.
+func (st Status) Text(loc *time.Location) string {
+	words := locale.Current().Words
+	switch {
+	case st.Busy:
+		return fmt.Sprintf(words.BusyUntil, clockTime(st.Until, st.Generated, loc))
+	case st.NextEvent != nil:
+		return fmt.Sprintf(words.Next, st.NextEvent.Title, clockTime(st.NextEvent.Start, st.Generated, loc))
+	}
+	return words.Free
+}
+
+// busyUntil returns when the meeting under way at now ends, or zero if
+// none is. events must be sorted by start.
+func busyUntil(events []model.Event, now time.Time) time.Time {
+	var until time.Time
+	for _, e := range events {
+		if e.Start.After(now) && (until.IsZero() || e.Start.After(until)) {
+			break
+		}
+		if e.End.After(now) && e.End.After(until) {
+			until = e.End
+		}
+	}
+	return until
+}
+
+// clockTime formats t in loc as 15:04, or Mon 15:04 if it isn't on the
+// same day as now, with the locale's names and clock.
+func clockTime(t, now time.Time, loc *time.Location) string {
+	l := locale.Current()
+	t, now = t.In(loc), now.In(loc)
+	if y, m, d := t.Date(); y != now.Year() || m != now.Month() || d != now.Day() {
+		return l.Format(t, "Mon") + " " + l.Clock(t)
+	}
+	return l.Clock(t)
+}
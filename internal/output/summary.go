@@ -0,0 +1,175 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jackdorland/www/internal/locale"
+	"github.com/jackdorland/www/internal/model"
+)
+
+// busyHalfDay is how much of a morning or afternoon's meetings make it
+// busy.
+const busyHalfDay = 2 * time.Hour
+
+// Summary is what a summary template is executed with: the rest of the
+// week, from today to the locale's last day of the week, in a few numbers
+// and phrases for the site's "this week" blurb. Like Availability it says nothing of what the events
+// are.
+type Summary struct {
+	Generated time.Time
+	// Meetings is how many events, not counting all-day ones, start this
+	// week from today.
+	Meetings int
+	Days     []SummaryDay
+	// Phrases describe the week day by day in the locale, like "busy
+	// Tuesday morning" and "away Thursday–Friday", for the default
+	// template to join.
+	Phrases []string
+}
+
+// SummaryDay is one day of Summary.
+type SummaryDay struct {
+	Date     time.Time
+	Meetings int
+	// Morning and Afternoon are set when there's at least busyHalfDay of
+	// meetings before or after noon, and Away when there's an all-day
+	// event, like travel or leave.
+	Morning, Afternoon, Away bool
+}
+
+// DefaultSummaryTemplate makes sentences like "Busy Tuesday morning, away
+// Thursday–Friday, 11 meetings total".
+const DefaultSummaryTemplate = `{{with .Phrases}}{{join . ", " | capitalize}}, {{end}}{{meetings .Meetings}}`
+
+var summaryFuncs = template.FuncMap{
+	"join": strings.Join,
+	// capitalize upper-cases a sentence's first letter.
+	"capitalize": func(s string) string {
+		if s == "" {
+			return s
+		}
+		r, n := utf8.DecodeRuneInString(s)
+		return string(unicode.ToUpper(r)) + s[n:]
+	},
+	// meetings says how many meetings there are in the locale, as in "11
+	// meetings total".
+	"meetings": func(n int) string { return locale.Current().Meetings(n) },
+}
+
+// ParseSummaryTemplate reads the summary template at path, or if it's ""
+// returns DefaultSummaryTemplate.
+func ParseSummaryTemplate(path string) (*template.Template, error) {
+	t := template.New("summary").Funcs(templateFuncs).Funcs(summaryFuncs)
+	var err error
+	if path == "" {
+		t, err = t.Parse(DefaultSummaryTemplate)
+	} else {
+		t, err = t.New(filepath.Base(path)).ParseFiles(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing summary template: %w", err)
+	}
+	return t, nil
+}
+
+// WeekSummary summarizes events, in loc, from the start of now's day to
+// the end of its week.
+func WeekSummary(events []model.Event, now time.Time, loc *time.Location) Summary {
+	s := Summary{Generated: now}
+	local := now.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	left := (int(locale.Current().LastDay()) - int(today.Weekday()) + 7) % 7
+	for i := 0; i <= left; i++ {
+		day := today.AddDate(0, 0, i)
+		next := today.AddDate(0, 0, i+1)
+		noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, loc)
+		d := SummaryDay{Date: day}
+		var morning, afternoon time.Duration
+		for _, e := range events {
+			if !e.Start.Before(next) || !e.End.After(day) {
+				continue
+			}
+			if allDay(e, loc) {
+				d.Away = true
+				continue
+			}
+			if !e.Start.Before(day) {
+				d.Meetings++
+			}
+			morning += overlap(e, day, noon)
+			afternoon += overlap(e, noon, next)
+		}
+		d.Morning, d.Afternoon = morning >= busyHalfDay, afternoon >= busyHalfDay
+		s.Meetings += d.Meetings
+		s.Days = append(s.Days, d)
+	}
+	s.Phrases = phrases(s.Days)
+	return s
+}
+
+// phrases describes days in the locale, running days away together.
+func phrases(days []SummaryDay) []string {
+	l := locale.Current()
+	var out []string
+	for i := 0; i < len(days); i++ {
+		d := days[i]
+		name := l.Days[d.Date.Weekday()]
+		switch {
+		case d.Away:
+			j := i
+			for j+1 < len(days) && days[j+1].Away {
+				j++
+			}
+			if j > i {
+				name += "–" + l.Days[days[j].Date.Weekday()]
+			}
+			out = append(out, fmt.Sprintf(l.Words.Away, name))
+			i = j
+		case d.Morning && d.Afternoon:
+			out = append(out, fmt.Sprintf(l.Words.Busy, name))
+		case d.Morning:
+			out = append(out, fmt.Sprintf(l.Words.BusyMorning, name))
+		case d.Afternoon:
+			out = append(out, fmt.Sprintf(l.Words.BusyAfternoon, name))
+		}
+	}
+	return out
+}
+
+// allDay reports whether e is an all-day event, running midnight to
+// midnight in loc, or at least a day long.
+func allDay(e model.Event, loc *time.Location) bool {
+	if e.End.Sub(e.Start) >= 24*time.Hour {
+		return true
+	}
+	start, end := e.Start.In(loc), e.End.In(loc)
+	return start.Hour() == 0 && start.Minute() == 0 && end.Hour() == 0 && end.Minute() == 0 && end.After(start)
+}
+
+// overlap is how much of e falls from from to until.
+func overlap(e model.Event, from, until time.Time) time.Duration {
+	start, end := e.Start, e.End
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(until) {
+		end = until
+	}
+	return max(end.Sub(start), 0)
+}
+
+// RenderSummary executes t with s.
+func RenderSummary(t *template.Template, s Summary) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, s); err != nil {
+		return nil, fmt.Errorf("executing summary template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,97 @@
+package output
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackdorland/www/internal/locale"
+	"github.com/jackdorland/www/internal/model"
+)
+
+// timelineStyle styles the timeline through CSS variables, so a page that
+// inlines it can restyle it: --cal-busy, --cal-grid, --cal-text, --cal-font.
+const timelineStyle = `.cal-grid { stroke: var(--cal-grid, #ccc); stroke-width: 1; }
+.cal-label { fill: var(--cal-text, currentColor); font: 12px var(--cal-font, system-ui, sans-serif); }
+.cal-busy { fill: var(--cal-busy, currentColor); opacity: .75; }
+.cal-weekend { fill: var(--cal-grid, #ccc); opacity: .25; }`
+
+// TimelineSVG draws events as a timeline, one column per day of the
+// window in loc, with events that overlap stacked in lanes. Like
+// WeekPNG it shows only when is busy, never titles, since the page that
+// inlines it isn't encrypted.
+func TimelineSVG(events []model.Event, now time.Time, loc *time.Location) []byte {
+	const dayW, laneH, gap, header = 120, 18, 4, 20
+
+	sorted := append([]model.Event(nil), events...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+
+	// the days to draw: from today, or the first event, to the last event
+	now = now.In(loc)
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	to := from.AddDate(0, 0, 1)
+	for _, e := range sorted {
+		if s := e.Start.In(loc); s.Before(from) {
+			from = time.Date(s.Year(), s.Month(), s.Day(), 0, 0, 0, 0, loc)
+		}
+		if end := e.End.In(loc); end.After(to) {
+			to = time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, loc)
+			if to.Before(end) {
+				to = to.AddDate(0, 0, 1)
+			}
+		}
+	}
+	days := 0
+	for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+		days++
+	}
+	x := func(t time.Time) float64 {
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		i := 0
+		for d := from; d.Before(day); d = d.AddDate(0, 0, 1) {
+			i++
+		}
+		return float64(i*dayW) + t.Sub(day).Hours()/24*dayW
+	}
+
+	// greedy lanes: each event goes in the first lane free at its start
+	var laneEnds []time.Time
+	lanes := make([]int, len(sorted))
+	for i, e := range sorted {
+		lane := 0
+		for lane < len(laneEnds) && laneEnds[lane].After(e.Start) {
+			lane++
+		}
+		if lane == len(laneEnds) {
+			laneEnds = append(laneEnds, e.End)
+		} else {
+			laneEnds[lane] = e.End
+		}
+		lanes[i] = lane
+	}
+
+	width := days * dayW
+	height := header + max(1, len(laneEnds))*(laneH+gap) + gap
+	l := locale.Current()
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" class="cal-timeline" viewBox="0 0 %d %d" width="100%%" role="img" aria-label="%s">`+"\n",
+		width, height, fmt.Sprintf(l.Words.Timeline, l.Format(from, "Mon 2 Jan"), l.Format(to.AddDate(0, 0, -1), "Mon 2 Jan")))
+	fmt.Fprintf(&b, "<style>\n%s\n</style>\n", timelineStyle)
+	for i := range days {
+		d := from.AddDate(0, 0, i)
+		if wd := d.Weekday(); wd == time.Saturday || wd == time.Sunday {
+			fmt.Fprintf(&b, `<rect class="cal-weekend" x="%d" y="%d" width="%d" height="%d"/>`+"\n", i*dayW, header, dayW, height-header)
+		}
+		fmt.Fprintf(&b, `<line class="cal-grid" x1="%d" y1="0" x2="%d" y2="%d"/>`+"\n", i*dayW, i*dayW, height)
+		fmt.Fprintf(&b, `<text class="cal-label" x="%d" y="14">%s</text>`+"\n", i*dayW+4, l.Format(d, "Mon 2"))
+	}
+	fmt.Fprintf(&b, `<line class="cal-grid" x1="0" y1="%d" x2="%d" y2="%d"/>`+"\n", header, width, header)
+	for i, e := range sorted {
+		x0, x1 := x(e.Start.In(loc)), x(e.End.In(loc))
+		fmt.Fprintf(&b, `<rect class="cal-busy" x="%.1f" y="%d" width="%.1f" height="%d" rx="2"><title>%s–%s</title></rect>`+"\n",
+			x0, header+gap+lanes[i]*(laneH+gap), max(x1-x0, 2), laneH, l.Format(e.Start.In(loc), "Mon")+" "+l.Clock(e.Start.In(loc)), l.Clock(e.End.In(loc)))
+	}
+	b.WriteString("</svg>\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,89 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jackdorland/www/internal/clock"
+	"github.com/jackdorland/www/internal/locale"
+	"github.com/jackdorland/www/internal/model"
+	"github.com/jackdorland/www/internal/version"
+)
+
+// TemplatePrefix selects a user-supplied text/template instead of an
+// encrypted calendar: -format=template:path/to/file.tmpl. The output is
+// published unencrypted.
+const TemplatePrefix = "template:"
+
+// TemplateData is what an output template is executed with. Since the
+// output is published unencrypted, private events are titled Busy, as in
+// the ICS feed, unless RenderTemplate is told otherwise; they still carry
+// Private, so a template can leave them out.
+type TemplateData struct {
+	Events    []model.Event
+	Generated time.Time
+	Version   string
+	Sources   []model.Source
+	// Degraded and Failed are as in model.Calendar.
+	Degraded bool
+	Failed   []int
+	// Weeks are the events grouped by ISO week, in the time zone
+	// RenderTemplate is given.
+	Weeks []Week
+}
+
+var templateFuncs = template.FuncMap{
+	// json renders a value as JSON, for data files.
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// date formats a time like time.Format, with the locale's day and
+	// month names, and clock its time of day with the locale's clock.
+	"date":  func(t time.Time, layout string) string { return locale.Current().Format(t, layout) },
+	"clock": func(t time.Time) string { return locale.Current().Clock(t) },
+	// zoned formats an event's start in each of -viewer-zones.
+	"zoned": zoned,
+	// toml quotes a string as a TOML basic string, for front matter.
+	"toml": func(s string) string {
+		r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+		return `"` + r.Replace(s) + `"`
+	},
+}
+
+// ParseTemplate reads the template file at path.
+func ParseTemplate(path string) (*template.Template, error) {
+	t, err := template.New(filepath.Base(path)).Funcs(templateFuncs).ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %w", err)
+	}
+	return t, nil
+}
+
+// RenderTemplate executes t with events, from sources, grouping them into
+// weeks in loc. Private events are redacted unless showPrivate is set.
+func RenderTemplate(t *template.Template, events []model.Event, sources []model.Source, loc *time.Location, showPrivate bool) ([]byte, error) {
+	if !showPrivate {
+		redacted := make([]model.Event, len(events))
+		for i, e := range events {
+			if e.Private {
+				// the icon and categories give it away as well
+				e.Title, e.Icon, e.Categories = "Busy", "", nil
+			}
+			redacted[i] = e
+		}
+		events = redacted
+	}
+	var buf bytes.Buffer
+	var cal model.Calendar
+	cal.SetSources(sources)
+	if err := t.Execute(&buf, TemplateData{Events: events, Generated: clock.Now(), Version: version.Get().Short(), Sources: sources, Degraded: cal.Degraded, Failed: cal.Failed, Weeks: ISOWeeks(events, loc)}); err != nil {
+		return nil, fmt.Errorf("executing template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,43 @@
+package output
+
+import (
+	"github.com/jackdorland/www/internal/crypto"
+	"github.com/jackdorland/www/internal/model"
+)
+
+// TierPath is where a tier's output is written: its configured output, or
+// docs/cal-<name>.aes.
+func TierPath(t crypto.TierConfig) string {
+	if t.Output != "" {
+		return t.Output
+	}
+	return "docs/cal-" + t.Name + ".aes"
+}
+
+// LeastTier returns the tier of tiers that shows the least, whose view is
+// safe for outputs published unencrypted.
+func LeastTier(tiers []crypto.TierConfig) crypto.TierConfig {
+	rank := map[string]int{crypto.ShowBusy: 0, crypto.ShowPublic: 1, crypto.ShowAll: 2, "": 2}
+	least := tiers[0]
+	for _, t := range tiers[1:] {
+		if rank[t.Show] < rank[least.Show] {
+			least = t
+		}
+	}
+	return least
+}
+
+// TierEvents returns the tier's view of the calendar. Events the source
+// marks CLASS:PRIVATE or CONFIDENTIAL are only titled in "all" tiers; an
+// event's icon, which gives it away as well, is hidden with its title.
+func TierEvents(t crypto.TierConfig, all []model.Event) []model.Event {
+	out := make([]model.Event, len(all))
+	for i, e := range all {
+		out[i] = e
+		switch {
+		case t.Show == crypto.ShowBusy, t.Show == crypto.ShowPublic && e.Private:
+			out[i].Title, out[i].Icon = "Busy", ""
+		}
+	}
+	return out
+}
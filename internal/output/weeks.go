@@ -0,0 +1,54 @@
+package output
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackdorland/www/internal/model"
+)
+
+// Week is an ISO week of events, for pages that page through the calendar
+// a week at a time.
+type Week struct {
+	// Year and Number are the ISO year and week number, and ID the two as
+	// in 2026-W42, which the ISO year makes unique.
+	Year   int    `json:"year"`
+	Number int    `json:"week"`
+	ID     string `json:"id"`
+	// Start is the Monday and End the Sunday of the week.
+	Start  time.Time     `json:"start"`
+	End    time.Time     `json:"end"`
+	Events []model.Event `json:"events"`
+}
+
+// ISOWeeks groups events by the ISO week, in loc, they start in, from the
+// first event's week to the last's, including any weeks between with no
+// events.
+func ISOWeeks(events []model.Event, loc *time.Location) []Week {
+	if len(events) == 0 {
+		return nil
+	}
+	sorted := append([]model.Event(nil), events...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+
+	monday := func(t time.Time) time.Time {
+		t = t.In(loc)
+		return time.Date(t.Year(), t.Month(), t.Day()-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc)
+	}
+	var weeks []Week
+	last := monday(sorted[len(sorted)-1].Start)
+	for start := monday(sorted[0].Start); !start.After(last); start = start.AddDate(0, 0, 7) {
+		year, number := start.ISOWeek()
+		weeks = append(weeks, Week{Year: year, Number: number, ID: fmt.Sprintf("%d-W%02d", year, number), Start: start, End: start.AddDate(0, 0, 6), Events: []model.Event{}})
+	}
+	first := weeks[0].Start
+	for _, e := range sorted {
+		// counted in days, not hours, for DST's sake
+		s := e.Start.In(loc)
+		day := time.Date(s.Year(), s.Month(), s.Day(), 0, 0, 0, 0, loc)
+		i := int(day.Sub(first).Hours()+12) / 24 / 7
+		weeks[i].Events = append(weeks[i].Events, e)
+	}
+	return weeks
+}
@@ -0,0 +1,156 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"strconv"
+	ttemplate "text/template"
+
+	"github.com/jackdorland/www/internal/locale"
+)
+
+// The widget renders the calendar as a strip of the next seven days on
+// any page. widget.html is the fragment to include; it loads widget.js,
+// which decrypts with decrypt.js and styles itself with widget.css.
+var (
+	widgetHTMLTemplate = template.Must(template.New("widget.html").Parse(`<div class="cal-widget" data-src="{{.Src}}"></div>
+<script type="module" src="{{.Base}}widget.js"></script>
+`))
+
+	widgetJSTemplate = ttemplate.Must(ttemplate.New("widget.js").Parse(`// Code generated by calendar-setup; DO NOT EDIT.
+//
+// Renders the calendar as a week strip in every element with class
+// "cal-widget" (see widget.html). data-src names the calendar file,
+// relative to this script. The key is taken from data-key, the page's
+// #key=<hex> fragment, or asked for once and kept in localStorage.
+
+import { DecryptCalendar } from "./decrypt.js";
+
+const DEFAULT_SRC = {{.Src}};
+const LOCALE = {{.Locale}};
+const STORAGE_KEY = "cal-widget-key";
+const base = new URL(".", import.meta.url);
+
+if (!document.querySelector("link[data-cal-widget]")) {
+  const link = document.createElement("link");
+  link.rel = "stylesheet";
+  link.href = new URL("widget.css", base);
+  link.dataset.calWidget = "";
+  document.head.append(link);
+}
+
+const el = (tag, className, text) => {
+  const e = document.createElement(tag);
+  if (className) e.className = className;
+  if (text) e.textContent = text;
+  return e;
+};
+
+function keyFor(widget) {
+  const hash = new URLSearchParams(location.hash.slice(1)).get("key");
+  return widget.dataset.key || hash || localStorage.getItem(STORAGE_KEY);
+}
+
+async function load(widget) {
+  const key = keyFor(widget);
+  if (!key) return unlock(widget);
+  try {
+    const res = await fetch(new URL(widget.dataset.src || DEFAULT_SRC, base), { cache: "no-cache" });
+    if (!res.ok) throw new Error(res.status + " " + res.statusText);
+    render(widget, await DecryptCalendar(await res.arrayBuffer(), key));
+  } catch (err) {
+    if (key === localStorage.getItem(STORAGE_KEY)) localStorage.removeItem(STORAGE_KEY);
+    widget.replaceChildren(el("p", "cal-widget-error", "Calendar unavailable: " + err.message));
+  }
+}
+
+function unlock(widget) {
+  const form = el("form", "cal-widget-unlock");
+  const input = el("input");
+  input.type = "password";
+  input.placeholder = "Calendar key";
+  input.autocomplete = "off";
+  form.append(input, el("button", "", "Show"));
+  form.addEventListener("submit", (e) => {
+    e.preventDefault();
+    localStorage.setItem(STORAGE_KEY, input.value.trim());
+    load(widget);
+  });
+  widget.replaceChildren(form);
+}
+
+function render(widget, cal) {
+  const weekday = new Intl.DateTimeFormat(LOCALE, { weekday: "short" });
+  const date = new Intl.DateTimeFormat(LOCALE, { day: "numeric" });
+  const time = new Intl.DateTimeFormat(LOCALE, { hour: "numeric", minute: "2-digit" });
+  const events = (cal.events || []).map((e) => ({ ...e, start: new Date(e.start), end: new Date(e.end) }));
+  events.sort((a, b) => a.start - b.start);
+
+  const strip = el("ol", "cal-widget-week");
+  const today = new Date();
+  today.setHours(0, 0, 0, 0);
+  for (let i = 0; i < 7; i++) {
+    const start = new Date(today.getFullYear(), today.getMonth(), today.getDate() + i);
+    const end = new Date(today.getFullYear(), today.getMonth(), today.getDate() + i + 1);
+    const day = el("li", i === 0 ? "cal-widget-day cal-widget-today" : "cal-widget-day");
+    const head = el("div", "cal-widget-date");
+    head.append(el("span", "cal-widget-weekday", weekday.format(start)), " ", el("span", "", date.format(start)));
+    const list = el("ul", "cal-widget-events");
+    for (const e of events.filter((e) => e.start < end && e.end > start)) {
+      const item = el("li", "cal-widget-event");
+      item.append(el("time", "", time.format(e.start)), " ", el("span", "", e.title));
+      list.append(item);
+    }
+    day.append(head, list);
+    strip.append(day);
+  }
+  widget.replaceChildren(strip);
+}
+
+document.querySelectorAll(".cal-widget").forEach(load);
+`))
+)
+
+// widgetCSS is widget.css. Colours come from the page where it can.
+const widgetCSS = `/* Code generated by calendar-setup; DO NOT EDIT. */
+.cal-widget-week { display: grid; grid-template-columns: repeat(7, minmax(0, 1fr)); gap: .25rem; list-style: none; margin: 0; padding: 0; font-size: .875em; }
+.cal-widget-day { border: 1px solid color-mix(in srgb, currentColor 20%, transparent); border-radius: .25rem; padding: .25rem; min-height: 4rem; }
+.cal-widget-today { border-color: currentColor; }
+.cal-widget-date { font-weight: bold; margin-bottom: .25rem; }
+.cal-widget-weekday { text-transform: uppercase; font-size: .75em; opacity: .7; }
+.cal-widget-events { list-style: none; margin: 0; padding: 0; }
+.cal-widget-event { margin: .125rem 0; overflow-wrap: anywhere; }
+.cal-widget-event time { display: block; font-size: .75em; opacity: .7; font-variant-numeric: tabular-nums; }
+.cal-widget-unlock { display: flex; gap: .25rem; }
+.cal-widget-error { opacity: .7; }
+@media (max-width: 40em) { .cal-widget-week { grid-template-columns: 1fr; } .cal-widget-day { min-height: 0; } }
+`
+
+// WidgetFiles returns the widget's files by name: widget.html, widget.js
+// and widget.css. src is the calendar file relative to them, and base is
+// the URL path of their directory as the including page sees it (say
+// "/docs/"), for widget.html's script tag.
+func WidgetFiles(src, base string) (map[string][]byte, error) {
+	srcJSON, err := json.Marshal(src)
+	if err != nil {
+		return nil, err
+	}
+	var html, js bytes.Buffer
+	if err := widgetHTMLTemplate.Execute(&html, struct{ Src, Base string }{src, base}); err != nil {
+		return nil, err
+	}
+	// the browser's own locale, unless one is set
+	localeJSON := "undefined"
+	if l := locale.Current(); l != locale.Default {
+		localeJSON = strconv.Quote(l.Tag.String())
+	}
+	if err := widgetJSTemplate.Execute(&js, struct{ Src, Locale string }{string(srcJSON), localeJSON}); err != nil {
+		return nil, err
+	}
+	return map[string][]byte{
+		"widget.html": html.Bytes(),
+		"widget.js":   js.Bytes(),
+		"widget.css":  []byte(widgetCSS),
+	}, nil
+}
@@ -0,0 +1,59 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackdorland/www/internal/locale"
+	"github.com/jackdorland/www/internal/model"
+)
+
+// ParseZones parses a comma-separated list of time zones, like
+// "local,UTC,America/New_York"; local is returned as time.Local, for the
+// caller to replace with its own.
+func ParseZones(s string) ([]*time.Location, error) {
+	var zones []*time.Location
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if strings.EqualFold(name, "local") {
+			zones = append(zones, time.Local)
+			continue
+		}
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", name, err)
+		}
+		zones = append(zones, loc)
+	}
+	return zones, nil
+}
+
+// InZones returns copies of events with their Times in each of zones.
+func InZones(events []model.Event, zones []*time.Location) []model.Event {
+	out := make([]model.Event, len(events))
+	for i, e := range events {
+		e.Times = make([]model.ZonedTimes, len(zones))
+		for j, loc := range zones {
+			start := e.Start.In(loc)
+			abbr, _ := start.Zone()
+			e.Times[j] = model.ZonedTimes{Zone: loc.String(), Abbr: abbr, Start: start, End: e.End.In(loc)}
+		}
+		out[i] = e
+	}
+	return out
+}
+
+// zoned formats e's start in each of its Times with the locale's clock,
+// like "19:00 CET / 13:00 EST", for templates.
+func zoned(e model.Event) string {
+	l := locale.Current()
+	parts := make([]string, len(e.Times))
+	for i, t := range e.Times {
+		parts[i] = l.Clock(t.Start) + " " + t.Abbr
+	}
+	return strings.Join(parts, " / ")
+}
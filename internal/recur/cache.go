@@ -0,0 +1,126 @@
+package recur
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+// maxCached is how many expansions the cache holds before it's emptied.
+const maxCached = 10000
+
+// cache remembers RRULE expansions across calendars, since mirrored feeds
+// repeat the same series, and across a daemon's runs, since their windows
+// overlap. An expansion covers the window widened to whole UTC days, so
+// runs later the same day reuse it though the window has moved on.
+var cache = struct {
+	sync.Mutex
+	entries map[cacheKey]*cacheEntry
+}{entries: make(map[cacheKey]*cacheEntry)}
+
+type cacheKey struct {
+	rule     string
+	dtstart  string
+	loc      string
+	from, to int64
+	limit    int
+}
+
+// cacheEntry is an expansion, done once it's finished, so feeds expanded
+// at the same time wait for each other instead of repeating the work.
+type cacheEntry struct {
+	done   chan struct{}
+	starts []time.Time
+	err    error
+}
+
+// occurrences returns the starts of the series with rule and dtstart,
+// read in loc, that fall between windowStart and windowEnd: no more than
+// one over limit, if it's positive, so Expand can tell it was reached.
+func occurrences(ctx context.Context, rule string, dtstart time.Time, loc *time.Location, windowStart, windowEnd time.Time, limit int) ([]time.Time, error) {
+	from := windowStart.UTC().Truncate(24 * time.Hour)
+	to := windowEnd.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	key := cacheKey{rule, dtstart.Format(time.RFC3339Nano) + " " + dtstart.Location().String(), loc.String(), from.Unix(), to.Unix(), limit}
+
+	cache.Lock()
+	e, ok := cache.entries[key]
+	if !ok {
+		if len(cache.entries) >= maxCached {
+			clear(cache.entries)
+		}
+		e = &cacheEntry{done: make(chan struct{})}
+		cache.entries[key] = e
+	}
+	cache.Unlock()
+	if ok {
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	} else {
+		e.starts, e.err = expandRule(ctx, rule, dtstart, loc, from, to, limit)
+		if e.err != nil {
+			// cancelled, say; the next run tries again
+			cache.Lock()
+			if cache.entries[key] == e {
+				delete(cache.entries, key)
+			}
+			cache.Unlock()
+		}
+		close(e.done)
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	span := e.starts
+	if limit > 0 && len(span) > limit {
+		// capped, perhaps before the window: expand just the window
+		return expandRule(ctx, rule, dtstart, loc, windowStart, windowEnd, limit)
+	}
+
+	var starts []time.Time
+	for _, t := range span {
+		if !t.Before(windowStart) && !t.After(windowEnd) {
+			starts = append(starts, t)
+		}
+	}
+	return starts, nil
+}
+
+// expandRule expands a series between from and to, like r.Between(from,
+// to, true) but cancellable, stopping one past limit if it's positive.
+func expandRule(ctx context.Context, rule string, dtstart time.Time, loc *time.Location, from, to time.Time, limit int) ([]time.Time, error) {
+	opt, err := rrule.StrToROptionInLocation(rule, loc)
+	if err != nil {
+		return nil, err
+	}
+	opt.Dtstart = dtstart
+	r, err := rrule.NewRRule(*opt)
+	if err != nil {
+		return nil, err
+	}
+
+	var starts []time.Time
+	next := r.Iterator()
+	for i := 1; ; i++ {
+		if i%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		occurrence, ok := next()
+		if !ok || occurrence.After(to) {
+			break
+		}
+		if !occurrence.Before(from) {
+			starts = append(starts, occurrence)
+			if limit > 0 && len(starts) > limit {
+				break
+			}
+		}
+	}
+	return starts, nil
+}
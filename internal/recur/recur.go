@@ -0,0 +1,175 @@
+// Package recur turns parsed iCalendar events into the concrete
+// occurrences that fall inside a time window, expanding RRULEs.
+package recur
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/teambition/rrule-go"
+
+	"github.com/jackdorland/www/internal/model"
+)
+
+func ParseICalDate(prop *ics.IANAProperty, defaultLoc *time.Location) (time.Time, error) {
+	if prop == nil || prop.Value == "" {
+		return time.Time{}, fmt.Errorf("missing date value")
+	}
+
+	if tzid := getTZID(prop); tzid != "" {
+		return rrule.StrToDtStart("TZID="+tzid+":"+prop.Value, defaultLoc)
+	}
+
+	return rrule.StrToDtStart(prop.Value, defaultLoc)
+}
+
+func getTZID(prop *ics.IANAProperty) string {
+	if prop == nil || prop.ICalParameters == nil {
+		return ""
+	}
+
+	if values, ok := prop.ICalParameters["TZID"]; ok && len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// exdates returns the starts, as Unix times, that event's EXDATEs leave
+// out of its RRULE. Each EXDATE may list several, separated by commas.
+func exdates(event *ics.VEvent, loc *time.Location) map[int64]bool {
+	var excluded map[int64]bool
+	for _, prop := range event.GetProperties(ics.ComponentPropertyExdate) {
+		for _, value := range strings.Split(prop.Value, ",") {
+			p := *prop
+			p.Value = value
+			t, err := ParseICalDate(&p, loc)
+			if err != nil {
+				continue
+			}
+			if excluded == nil {
+				excluded = make(map[int64]bool)
+			}
+			excluded[t.Unix()] = true
+		}
+	}
+	return excluded
+}
+
+// ErrLimit is returned by Expand, with the occurrences so far, when a feed
+// has more than its limit.
+var ErrLimit = errors.New("too many occurrences")
+
+// Expand returns the events of cal that start between windowStart and
+// windowEnd, with recurring events expanded to one entry per occurrence
+// (less those their EXDATEs leave out), and the number of events skipped
+// because their DTSTART or RRULE couldn't be read. Times without a TZID or
+// UTC marker are read in loc. It stops with ctx's error if ctx is
+// cancelled, which matters for rules with many occurrences before the
+// window. If limit is positive it stops after that many occurrences,
+// returning them with ErrLimit.
+func Expand(ctx context.Context, cal *ics.Calendar, windowStart, windowEnd time.Time, loc *time.Location, limit int) (events []model.Event, skipped int, err error) {
+	for _, event := range cal.Events() {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+
+		// check each event for proximity to current date
+		// if event is within the window, save to new format
+		componentDate := event.GetProperty(ics.ComponentPropertyDtStart)
+		parsedDate, err := ParseICalDate(componentDate, loc)
+		if err != nil {
+			skipped++
+			continue
+		}
+
+		// most of a feed's events are single ones long past, so those
+		// outside the window are dropped before reading anything else
+		rruleProp := event.GetProperty(ics.ComponentProperty("RRULE"))
+		if rruleProp == nil && !(parsedDate.Before(windowEnd) && parsedDate.After(windowStart)) {
+			continue
+		}
+
+		duration := time.Duration(0)
+		endProp := event.GetProperty(ics.ComponentPropertyDtEnd)
+		if endProp != nil {
+			parsedEndDate, err := ParseICalDate(endProp, loc)
+			if err == nil {
+				duration = parsedEndDate.Sub(parsedDate)
+			}
+		}
+
+		summaryProp := event.GetProperty(ics.ComponentPropertySummary)
+		title := ""
+		if summaryProp != nil {
+			title = summaryProp.Value
+		}
+
+		uid := ""
+		if uidProp := event.GetProperty(ics.ComponentPropertyUniqueId); uidProp != nil {
+			uid = uidProp.Value
+		}
+
+		private := false
+		if classProp := event.GetProperty(ics.ComponentPropertyClass); classProp != nil {
+			private = classProp.Value == "PRIVATE" || classProp.Value == "CONFIDENTIAL"
+		}
+
+		var categories []string
+		for _, p := range event.GetProperties(ics.ComponentPropertyCategories) {
+			for _, c := range strings.Split(p.Value, ",") {
+				if c = strings.TrimSpace(c); c != "" {
+					categories = append(categories, c)
+				}
+			}
+		}
+
+		if rruleProp != nil {
+			starts, err := occurrences(ctx, rruleProp.Value, parsedDate, loc, windowStart, windowEnd, limit)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, 0, err
+				}
+				skipped++
+				continue
+			}
+			excluded := exdates(event, loc)
+			for _, occurrence := range starts {
+				if excluded[occurrence.Unix()] {
+					continue
+				}
+				if limit > 0 && len(events) == limit {
+					return events, skipped, ErrLimit
+				}
+				parsedEvent := model.Event{
+					Title:      title,
+					Start:      occurrence,
+					End:        occurrence.Add(duration),
+					Private:    private,
+					Categories: categories,
+					UID:        uid,
+				}
+				events = append(events, parsedEvent)
+			}
+			continue
+		}
+
+		if limit > 0 && len(events) == limit {
+			return events, skipped, ErrLimit
+		}
+		parsedEvent := model.Event{
+			Title:      title,
+			Start:      parsedDate,
+			End:        parsedDate.Add(duration),
+			Private:    private,
+			Categories: categories,
+			UID:        uid,
+		}
+		events = append(events, parsedEvent)
+	}
+	return events, skipped, nil
+}
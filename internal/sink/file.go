@@ -0,0 +1,74 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	Register("file", openFile)
+}
+
+// fileSink writes outputs to their paths, relative to an optional root
+// ("file:/srv/www").
+type fileSink struct {
+	root string
+}
+
+func openFile(spec string) (Sink, error) {
+	return &fileSink{root: strings.TrimPrefix(spec, "file:")}, nil
+}
+
+func (s *fileSink) Write(ctx context.Context, out Output) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path := filepath.Join(s.root, out.Path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFile(path, out.Data, 0644)
+}
+
+func (s *fileSink) Read(ctx context.Context, path string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.root, path))
+}
+
+func (s *fileSink) Remove(ctx context.Context, path string) error {
+	if err := os.Remove(filepath.Join(s.root, path)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// writeFile writes data to path atomically: to a temporary file in the
+// same directory, renamed over path once complete, so a reader (or a
+// shutdown mid-write) never sees a partial file.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // no-op after the rename
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
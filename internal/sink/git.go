@@ -0,0 +1,171 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jackdorland/www/internal/clock"
+	"github.com/jackdorland/www/internal/version"
+)
+
+func init() {
+	Register("git", openGit)
+}
+
+// defaultGitMessage is the commit message unless the spec sets one.
+const defaultGitMessage = "chore: refresh calendar"
+
+// gitSink writes outputs into a git work tree, like file:, then commits
+// the ones that changed and pushes them:
+//
+//	git:<work tree>?remote=origin&branch=main&message=<template>
+//
+// The work tree defaults to the current directory, remote to origin and
+// branch to the current one; remote= (empty) commits without pushing.
+// message is a text/template executed with GitCommit. Only the outputs
+// are committed, whatever else is staged or changed. A push rejected
+// because the remote moved on is retried once after a rebase. The
+// committer is whoever git is configured with.
+type gitSink struct {
+	files   fileSink
+	remote  string
+	branch  string
+	message *template.Template
+	written []string
+}
+
+// GitCommit is what a git: sink's message template is executed with.
+type GitCommit struct {
+	// Files are the outputs that changed, relative to the work tree.
+	Files   []string
+	Date    time.Time
+	Version string
+}
+
+func openGit(spec string) (Sink, error) {
+	root, query, _ := strings.Cut(strings.TrimPrefix(spec, "git:"), "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("sink %q: %w", spec, err)
+	}
+	if root == "" {
+		root = "."
+	}
+	s := &gitSink{files: fileSink{root: root}, remote: "origin", branch: params.Get("branch")}
+	if params.Has("remote") {
+		s.remote = params.Get("remote")
+	}
+	message := defaultGitMessage
+	if params.Has("message") {
+		message = params.Get("message")
+	}
+	if s.message, err = template.New("message").Parse(message); err != nil {
+		return nil, fmt.Errorf("sink %q: %w", spec, err)
+	}
+	if _, err := s.git(context.Background(), "rev-parse", "--is-inside-work-tree"); err != nil {
+		return nil, fmt.Errorf("sink %q: %w", spec, err)
+	}
+	return s, nil
+}
+
+func (s *gitSink) Write(ctx context.Context, out Output) error {
+	if err := s.files.Write(ctx, out); err != nil {
+		return err
+	}
+	s.written = append(s.written, out.Path)
+	return nil
+}
+
+func (s *gitSink) Read(ctx context.Context, path string) ([]byte, error) {
+	return s.files.Read(ctx, path)
+}
+
+// Remove deletes path from the work tree; Commit commits the deletion.
+func (s *gitSink) Remove(ctx context.Context, path string) error {
+	if err := s.files.Remove(ctx, path); err != nil {
+		return err
+	}
+	s.written = append(s.written, path)
+	return nil
+}
+
+// Commit commits the outputs written since the last Commit, if any
+// changed, and pushes.
+func (s *gitSink) Commit(ctx context.Context) error {
+	paths := s.written
+	s.written = nil
+	if len(paths) == 0 {
+		return nil
+	}
+	if _, err := s.git(ctx, append([]string{"add", "--"}, paths...)...); err != nil {
+		return err
+	}
+	changed, err := s.git(ctx, append([]string{"diff", "--cached", "--name-only", "--"}, paths...)...)
+	if err != nil {
+		return err
+	}
+	files := strings.Fields(changed)
+	if len(files) == 0 {
+		slog.Debug("Outputs unchanged; nothing to commit")
+		return nil
+	}
+
+	var msg bytes.Buffer
+	if err := s.message.Execute(&msg, GitCommit{Files: files, Date: clock.Now(), Version: version.Get().Short()}); err != nil {
+		return fmt.Errorf("commit message: %w", err)
+	}
+	if _, err := s.git(ctx, append([]string{"commit", "--quiet", "-m", msg.String(), "--"}, paths...)...); err != nil {
+		return err
+	}
+	slog.Info("Committed outputs", "files", len(files))
+	if s.remote == "" {
+		return nil
+	}
+
+	ref := "HEAD"
+	if s.branch != "" {
+		ref = "HEAD:" + s.branch
+	}
+	if _, err := s.git(ctx, "push", "--quiet", s.remote, ref); err != nil {
+		slog.Warn("Push failed; rebasing and retrying", "remote", s.remote, "err", err)
+		pull := []string{"pull", "--quiet", "--rebase", "--autostash", s.remote}
+		if s.branch != "" {
+			pull = append(pull, s.branch)
+		}
+		if _, err := s.git(ctx, pull...); err != nil {
+			return err
+		}
+		if _, err := s.git(ctx, "push", "--quiet", s.remote, ref); err != nil {
+			return err
+		}
+	}
+	slog.Info("Pushed outputs", "remote", s.remote, "ref", ref)
+	return nil
+}
+
+// git runs git in the work tree and returns its output.
+func (s *gitSink) git(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", s.files.root}, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		var msg []string
+		for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+			if !strings.HasPrefix(line, "hint:") {
+				msg = append(msg, line)
+			}
+		}
+		if len(msg) > 0 && msg[0] != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.Join(msg, "; "))
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,162 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackdorland/www/internal/crypto"
+)
+
+func init() {
+	Register("kv", openKV)
+}
+
+// kvSink writes each output as a value in a Cloudflare Workers KV
+// namespace, keyed by its base name under an optional prefix, so a
+// worker can serve it from the edge:
+//
+//	kv:<account id>/<namespace id>?token=env:CF_KV_TOKEN&prefix=cal/
+//
+// token is a secret reference (see crypto.ResolveSecret) to an API token
+// with Workers KV Storage:Edit, by default $CLOUDFLARE_API_TOKEN (which
+// may hold a reference too). Each
+// value's metadata carries its contentType and cacheControl, for the
+// worker's response headers.
+type kvSink struct {
+	endpoint string
+	prefix   string
+	token    string
+}
+
+// kvMetadata is stored with each value.
+type kvMetadata struct {
+	ContentType  string `json:"contentType"`
+	CacheControl string `json:"cacheControl"`
+}
+
+func openKV(spec string) (Sink, error) {
+	rest, query, _ := strings.Cut(strings.TrimPrefix(spec, "kv:"), "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("kv sink: %w", err)
+	}
+	account, namespace, ok := strings.Cut(rest, "/")
+	if !ok || account == "" || namespace == "" {
+		return nil, fmt.Errorf("kv sink: want kv:<account id>/<namespace id>")
+	}
+	ref := params.Get("token")
+	if ref == "" {
+		ref = os.Getenv("CLOUDFLARE_API_TOKEN")
+	}
+	token, err := crypto.ResolveSecret(ref)
+	if err != nil {
+		return nil, fmt.Errorf("kv sink: token: %w", err)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("kv sink: no token: add ?token= or set CLOUDFLARE_API_TOKEN")
+	}
+
+	// CLOUDFLARE_API_BASE_URL is wrangler's override
+	base := os.Getenv("CLOUDFLARE_API_BASE_URL")
+	if base == "" {
+		base = "https://api.cloudflare.com/client/v4"
+	}
+	return &kvSink{
+		endpoint: strings.TrimSuffix(base, "/") + "/accounts/" + url.PathEscape(account) + "/storage/kv/namespaces/" + url.PathEscape(namespace) + "/values/",
+		prefix:   params.Get("prefix"),
+		token:    token,
+	}, nil
+}
+
+func (s *kvSink) Write(ctx context.Context, out Output) error {
+	meta, err := json.Marshal(kvMetadata{ContentType: ContentType(out.Path), CacheControl: CacheControl(out, "public, no-cache")})
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("metadata", string(meta))
+	fw, err := mw.CreateFormFile("value", filepath.Base(out.Path))
+	if err != nil {
+		return err
+	}
+	fw.Write(out.Data)
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	_, err = s.do(ctx, "PUT", out.Path, &body, mw.FormDataContentType())
+	return err
+}
+
+func (s *kvSink) Read(ctx context.Context, path string) ([]byte, error) {
+	return s.do(ctx, "GET", path, nil, "")
+}
+
+func (s *kvSink) Remove(ctx context.Context, path string) error {
+	_, err := s.do(ctx, "DELETE", path, nil, "")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// do sends a request for the value path names. A GET returns the value
+// itself; anything else the API's JSON result, checked here. A missing
+// value is fs.ErrNotExist.
+func (s *kvSink) do(ctx context.Context, method, path string, body io.Reader, contentType string) ([]byte, error) {
+	key := s.prefix + filepath.Base(path)
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint+url.PathEscape(key), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fs.ErrNotExist
+	}
+	if method == "GET" && resp.StatusCode == http.StatusOK {
+		return data, nil
+	}
+
+	var result struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("%s: %w", resp.Status, err)
+	}
+	if !result.Success {
+		var msgs []string
+		for _, e := range result.Errors {
+			msgs = append(msgs, fmt.Sprintf("%s (%d)", e.Message, e.Code))
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.Join(msgs, "; "))
+	}
+	return nil, nil
+}
@@ -0,0 +1,143 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackdorland/www/internal/crypto"
+)
+
+func init() {
+	Register("s3", openS3)
+}
+
+// s3Sink uploads outputs to an S3-compatible bucket (AWS S3, Cloudflare
+// R2, MinIO), named by their base name under an optional prefix:
+//
+//	s3:bucket/prefix?region=auto&endpoint=https://<account>.r2.cloudflarestorage.com
+//
+// The query may also set cache-control for the encrypted outputs (see
+// CacheControl for the others) and type.<ext>=<media type> to
+// override ContentType. region defaults to $AWS_REGION and endpoint to
+// $AWS_ENDPOINT_URL_S3, then AWS's own; requests use path-style URLs,
+// which every implementation accepts. Credentials come from the usual
+// AWS_* variables.
+type s3Sink struct {
+	endpoint     *url.URL
+	bucket       string
+	prefix       string
+	region       string
+	cacheControl string
+	types        map[string]string
+	creds        crypto.AWSCredentials
+}
+
+func openS3(spec string) (Sink, error) {
+	rest, query, _ := strings.Cut(strings.TrimPrefix(spec, "s3:"), "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("sink %q: %w", spec, err)
+	}
+	bucket, prefix, _ := strings.Cut(strings.Trim(rest, "/"), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("sink %q: no bucket (s3:bucket/prefix)", spec)
+	}
+	s := &s3Sink{
+		bucket:       bucket,
+		prefix:       prefix,
+		region:       params.Get("region"),
+		cacheControl: params.Get("cache-control"),
+		types:        make(map[string]string),
+	}
+	if s.region == "" {
+		if s.region = os.Getenv("AWS_REGION"); s.region == "" {
+			return nil, fmt.Errorf("sink %q: no region: add ?region= or set AWS_REGION", spec)
+		}
+	}
+	if s.cacheControl == "" {
+		s.cacheControl = "public, no-cache"
+	}
+	for k, v := range params {
+		if ext, ok := strings.CutPrefix(k, "type."); ok {
+			s.types["."+ext] = v[0]
+		}
+	}
+
+	endpoint := params.Get("endpoint")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL_S3")
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + s.region + ".amazonaws.com"
+	}
+	if s.endpoint, err = url.Parse(endpoint); err != nil || s.endpoint.Host == "" {
+		return nil, fmt.Errorf("sink %q: invalid endpoint %q", spec, endpoint)
+	}
+	if s.creds, err = crypto.AWSCredentialsFromEnv(); err != nil {
+		return nil, fmt.Errorf("sink %q: %w", spec, err)
+	}
+	return s, nil
+}
+
+func (s *s3Sink) Write(ctx context.Context, out Output) error {
+	contentType, ok := s.types[filepath.Ext(out.Path)]
+	if !ok {
+		contentType = ContentType(out.Path)
+	}
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	header.Set("Cache-Control", CacheControl(out, s.cacheControl))
+	_, err := s.do(ctx, "PUT", out.Path, out.Data, header)
+	return err
+}
+
+func (s *s3Sink) Read(ctx context.Context, path string) ([]byte, error) {
+	return s.do(ctx, "GET", path, nil, nil)
+}
+
+func (s *s3Sink) Remove(ctx context.Context, path string) error {
+	_, err := s.do(ctx, "DELETE", path, nil, nil)
+	return err
+}
+
+// do sends a signed request for the object path names and returns the
+// response body. A missing object is fs.ErrNotExist.
+func (s *s3Sink) do(ctx context.Context, method, name string, body []byte, header http.Header) ([]byte, error) {
+	u := *s.endpoint
+	u.Path = path.Join("/", u.Path, s.bucket, s.prefix, filepath.Base(name))
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	crypto.SignAWSRequest(req, body, "s3", s.region, s.creds, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fs.ErrNotExist
+	}
+	if resp.StatusCode/100 != 2 {
+		if len(data) > 512 {
+			data = data[:512]
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	return data, err
+}
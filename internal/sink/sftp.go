@@ -0,0 +1,266 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func init() {
+	Register("sftp", openSFTP)
+}
+
+// sftpSink uploads outputs over SSH to a directory on a server, like a
+// shared host's webroot, at their paths under it, as the file sink writes
+// them under its root:
+//
+//	sftp://user@host[:port]/path/to/webroot?key=~/.ssh/id_ed25519
+//
+// It authenticates with the key (unencrypted) or, without key=, with the
+// agent at $SSH_AUTH_SOCK. The server must be in known_hosts=, by default
+// ~/.ssh/known_hosts. Each file is uploaded beside its destination and
+// renamed over it, so the site never serves a partial file. One connection
+// serves a whole run.
+type sftpSink struct {
+	addr   string
+	dir    string
+	config *ssh.ClientConfig
+	// agentSock is $SSH_AUTH_SOCK, if the agent authenticates; it's
+	// dialled for each connection and closed once the handshake is done.
+	agentSock string
+
+	conn   *ssh.Client
+	client *sftp.Client
+	// done is the context of the run that connected, which closes the
+	// connection when it ends, in case the run never commits.
+	done <-chan struct{}
+}
+
+func openSFTP(spec string) (Sink, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("sink %q: %w", spec, err)
+	}
+	if u.Host == "" || u.User == nil || u.Path == "" {
+		return nil, fmt.Errorf("sink %q: want sftp://user@host/dir", spec)
+	}
+	q := u.Query()
+
+	var auth []ssh.AuthMethod
+	var agentSock string
+	if key := q.Get("key"); key != "" {
+		pem, err := os.ReadFile(expandHome(key))
+		if err != nil {
+			return nil, fmt.Errorf("sink %q: %w", spec, err)
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, fmt.Errorf("sink %q: key %s: %w", spec, key, err)
+		}
+		auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+	} else if agentSock = os.Getenv("SSH_AUTH_SOCK"); agentSock == "" {
+		return nil, fmt.Errorf("sink %q: no key: add ?key= or run an SSH agent", spec)
+	}
+
+	hosts := q.Get("known_hosts")
+	if hosts == "" {
+		hosts = "~/.ssh/known_hosts"
+	}
+	hostKey, err := knownhosts.New(expandHome(hosts))
+	if err != nil {
+		return nil, fmt.Errorf("sink %q: known hosts: %w", spec, err)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	return &sftpSink{
+		addr: addr,
+		dir:  u.Path,
+		config: &ssh.ClientConfig{
+			User:            u.User.Username(),
+			Auth:            auth,
+			HostKeyCallback: hostKey,
+			Timeout:         30 * time.Second,
+		},
+		agentSock: agentSock,
+	}, nil
+}
+
+// expandHome expands a leading ~/ to the home directory.
+func expandHome(p string) string {
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return p
+}
+
+// connect dials the server, unless already connected. Closing the SSH
+// connection when ctx is done interrupts a transfer in progress.
+func (s *sftpSink) connect(ctx context.Context) error {
+	if s.client != nil {
+		select {
+		case <-s.done:
+			s.close()
+		default:
+			return nil
+		}
+	}
+	config := s.config
+	if s.agentSock != "" {
+		var d net.Dialer
+		ac, err := d.DialContext(ctx, "unix", s.agentSock)
+		if err != nil {
+			return fmt.Errorf("ssh agent: %w", err)
+		}
+		// the signers are only used in the handshake
+		defer ac.Close()
+		withAgent := *s.config
+		withAgent.Auth = []ssh.AuthMethod{ssh.PublicKeysCallback(agent.NewClient(ac).Signers)}
+		config = &withAgent
+	}
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(nc, s.addr, config)
+	if err != nil {
+		nc.Close()
+		return err
+	}
+	s.conn = ssh.NewClient(c, chans, reqs)
+	if s.client, err = sftp.NewClient(s.conn); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	conn := s.conn
+	s.done = ctx.Done()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	return nil
+}
+
+func (s *sftpSink) Write(ctx context.Context, out Output) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := s.connect(ctx); err != nil {
+		return err
+	}
+	if err := s.upload(out); err != nil {
+		// the connection may be what failed; the next Write redials
+		s.close()
+		return err
+	}
+	return nil
+}
+
+// remote is where the output at p goes on the server: p under the
+// directory, so outputs in different local directories, like tiers, don't
+// overwrite each other.
+func (s *sftpSink) remote(p string) (string, error) {
+	rel := path.Clean("/" + filepath.ToSlash(p))
+	if filepath.IsLocal(p) || filepath.IsAbs(p) {
+		return path.Join(s.dir, rel), nil
+	}
+	return "", fmt.Errorf("output %s is outside the sink's directory", p)
+}
+
+func (s *sftpSink) upload(out Output) error {
+	name, err := s.remote(out.Path)
+	if err != nil {
+		return err
+	}
+	dir := path.Dir(name)
+	if err := s.client.MkdirAll(dir); err != nil {
+		return err
+	}
+	tmp := path.Join(dir, "."+path.Base(name)+".tmp")
+	f, err := s.client.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(out.Data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = s.client.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		// plain SFTP rename won't replace a file; the OpenSSH extension will
+		err = s.client.PosixRename(tmp, name)
+	}
+	if err != nil {
+		s.client.Remove(tmp)
+	}
+	return err
+}
+
+func (s *sftpSink) Read(ctx context.Context, name string) ([]byte, error) {
+	if err := s.connect(ctx); err != nil {
+		return nil, err
+	}
+	name, err := s.remote(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.client.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (s *sftpSink) Remove(ctx context.Context, name string) error {
+	if err := s.connect(ctx); err != nil {
+		return err
+	}
+	name, err := s.remote(name)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Commit closes the connection; the next run opens a new one.
+func (s *sftpSink) Commit(ctx context.Context) error {
+	return s.close()
+}
+
+func (s *sftpSink) close() error {
+	if s.client == nil {
+		return nil
+	}
+	err := errors.Join(s.client.Close(), s.conn.Close())
+	s.client, s.conn = nil, nil
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
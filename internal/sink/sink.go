@@ -0,0 +1,141 @@
+// Package sink publishes the generated outputs.
+//
+// A sink is named by a spec whose scheme picks the Sink; "file:" (the
+// default) writes to the local filesystem, "s3:" uploads to a bucket,
+// "sftp:" to a server over SSH and "kv:" to Cloudflare Workers KV, and
+// "git:" commits to the repository.
+// New publish targets are added by
+// calling Register from an init function, without changing the pipeline.
+package sink
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Output is one file to publish.
+type Output struct {
+	// Path is where the file goes, e.g. "docs/cal.aes". Sinks that don't
+	// write to a filesystem may only use its base name.
+	Path string
+	Data []byte
+	// Private is set for output that isn't encrypted and mustn't be kept
+	// by shared caches.
+	Private bool
+	// Immutable is set for output named by its content, which may be
+	// cached forever.
+	Immutable bool
+}
+
+// CacheControl is the Cache-Control header for out, given the one for
+// ordinary public outputs.
+func CacheControl(out Output, public string) string {
+	switch {
+	case out.Private:
+		return "private, no-cache"
+	case out.Immutable:
+		return "public, max-age=31536000, immutable"
+	}
+	return public
+}
+
+// A Sink publishes outputs.
+type Sink interface {
+	Write(ctx context.Context, out Output) error
+}
+
+// Opener creates the Sink for a spec.
+type Opener func(spec string) (Sink, error)
+
+var (
+	mu      sync.RWMutex
+	openers = make(map[string]Opener)
+)
+
+// Register makes a Sink available for specs with the given scheme. It
+// panics if the scheme is already registered.
+func Register(scheme string, open Opener) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := openers[scheme]; dup {
+		panic("sink: Register called twice for scheme " + scheme)
+	}
+	openers[scheme] = open
+}
+
+// Schemes lists the registered schemes.
+func Schemes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	var schemes []string
+	for s := range openers {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open returns the Sink for spec, which is "scheme:" followed by anything
+// the sink needs.
+func Open(spec string) (Sink, error) {
+	scheme, _, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("sink %q has no scheme", spec)
+	}
+	mu.RLock()
+	open, ok := openers[scheme]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown sink scheme %q (have %s)", scheme, strings.Join(Schemes(), ", "))
+	}
+	return open(spec)
+}
+
+// ContentType is the media type to publish an output at path with.
+func ContentType(path string) string {
+	switch filepath.Ext(path) {
+	case ".json":
+		return "application/json"
+	case ".js":
+		return "text/javascript; charset=utf-8"
+	case ".html":
+		return "text/html; charset=utf-8"
+	case ".css":
+		return "text/css; charset=utf-8"
+	case ".sig":
+		return "text/plain; charset=utf-8"
+	case ".ics":
+		return "text/calendar; charset=utf-8"
+	case ".png":
+		return "image/png"
+	case ".svg":
+		return "image/svg+xml"
+	}
+	return "application/octet-stream"
+}
+
+// A Reader is a Sink that can read back a file it published, like the
+// last manifest. A file that isn't there is an error matching
+// fs.ErrNotExist.
+type Reader interface {
+	Sink
+	Read(ctx context.Context, path string) ([]byte, error)
+}
+
+// A Remover is a Sink that can delete a file it published. Removing a
+// file that isn't there isn't an error.
+type Remover interface {
+	Sink
+	Remove(ctx context.Context, path string) error
+}
+
+// A Committer is a Sink that publishes what was written to it in one step,
+// when Commit is called after the last Write of a run.
+type Committer interface {
+	Sink
+	Commit(ctx context.Context) error
+}
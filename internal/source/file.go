@@ -0,0 +1,68 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	Register("file", openFile)
+}
+
+// fileSource reads saved feeds, such as those written by the fetch
+// command. The path may be a glob.
+type fileSource struct {
+	pattern string
+}
+
+func openFile(spec string) (Source, error) {
+	pattern := strings.TrimPrefix(spec, "file:")
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("bad pattern %q: %w", pattern, err)
+	}
+	return &fileSource{pattern}, nil
+}
+
+func (s *fileSource) Fetch(ctx context.Context) ([]RawCalendar, error) {
+	var cals []RawCalendar
+	err := s.Stream(ctx, func(name string, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		cals = append(cals, RawCalendar{Name: name, Data: data})
+		return nil
+	})
+	return cals, err
+}
+
+func (s *fileSource) Stream(ctx context.Context, read func(name string, r io.Reader) error) error {
+	paths, err := filepath.Glob(s.pattern)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		// not a glob, or one matching nothing: report the path
+		paths = []string{s.pattern}
+	}
+
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = read(path, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+func init() {
+	Register("http", openHTTP)
+	Register("https", openHTTP)
+	Register("webcal", openHTTP)
+}
+
+type clientKey struct{}
+
+// WithClient returns a context that has HTTP sources fetch with client
+// rather than http.DefaultClient, so callers can give each worker its own
+// connections.
+func WithClient(ctx context.Context, client *http.Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+func httpClient(ctx context.Context) *http.Client {
+	if c, ok := ctx.Value(clientKey{}).(*http.Client); ok {
+		return c
+	}
+	return http.DefaultClient
+}
+
+// httpSource fetches a feed over HTTP. webcal: URLs are fetched with
+// https.
+type httpSource struct {
+	url string
+	u   *url.URL
+}
+
+func openHTTP(spec string) (Source, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "webcal" {
+		u.Scheme = "https"
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("feed URL has no host")
+	}
+	return &httpSource{url: u.String(), u: u}, nil
+}
+
+func (s *httpSource) Fetch(ctx context.Context) ([]RawCalendar, error) {
+	var cals []RawCalendar
+	err := s.Stream(ctx, func(name string, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", name, err)
+		}
+		cals = append(cals, RawCalendar{Name: name, Data: data})
+		return nil
+	})
+	return cals, err
+}
+
+// Stream fetches the feed, retrying and sharing a Breaker as ctx says
+// (see WithRetries and WithBreaker).
+func (s *httpSource) Stream(ctx context.Context, read func(name string, r io.Reader) error) error {
+	name := s.u.Scheme + "://" + s.u.Host + "/..."
+	b := breaker(ctx)
+	if err := b.allow(s.u.Host); err != nil {
+		return fmt.Errorf("fetching %s: %w", name, err)
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := s.get(ctx)
+		if err != nil && ctx.Err() != nil {
+			// the run's timeout, not the host's fault
+			return fmt.Errorf("fetching %s: %w", name, err)
+		}
+		failed := err != nil || transient(resp.StatusCode)
+		b.record(s.u.Host, failed)
+		if !failed {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("fetching %s: %s", name, resp.Status)
+			}
+			return read(name, resp.Body)
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = errors.New(resp.Status)
+		}
+		// give up after the last retry, or once this feed's failures,
+		// or others', have opened the host's circuit
+		if attempt >= retries(ctx) || b.allow(s.u.Host) != nil {
+			return fmt.Errorf("fetching %s: %w", name, err)
+		}
+		wait := backoff(attempt, resp)
+		slog.Warn("Fetch failed; retrying", "name", name, "err", err, "in", wait.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("fetching %s: %w", name, err)
+		case <-time.After(wait):
+		}
+	}
+}
+
+// get requests the feed once.
+func (s *httpSource) get(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient(ctx).Do(req)
+	if err != nil {
+		// *url.Error quotes the whole URL, secret token and all
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,134 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The backoff between retries: random, up to retryBase doubled for each
+// attempt so far, and no more than retryMax even if the server asks for
+// longer with Retry-After.
+const (
+	retryBase = time.Second
+	retryMax  = 30 * time.Second
+)
+
+type retriesKey struct{}
+
+// WithRetries returns a context that has HTTP sources retry a fetch up to
+// n times if it fails in a way that may not last: a network error, a 5xx
+// or a 429. Only the request is retried, never a body that's been read
+// from.
+func WithRetries(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, retriesKey{}, n)
+}
+
+func retries(ctx context.Context) int {
+	n, _ := ctx.Value(retriesKey{}).(int)
+	return n
+}
+
+// transient reports whether a response with status is worth retrying.
+func transient(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// backoff is how long to wait before retrying after attempt (counting
+// from 0), given resp's Retry-After if it has one.
+func backoff(attempt int, resp *http.Response) time.Duration {
+	d := time.Duration(rand.Int64N(int64(min(retryBase<<attempt, retryMax)) + 1))
+	if resp != nil {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			d = max(d, time.Duration(secs)*time.Second)
+		}
+	}
+	return min(d, retryMax)
+}
+
+// ErrCircuitOpen is returned for a fetch from a host whose circuit a
+// Breaker has opened.
+var ErrCircuitOpen = errors.New("circuit open: the host kept failing, so it isn't tried again this run")
+
+type breakerKey struct{}
+
+// A Breaker stops HTTP sources fetching from a host once it has failed
+// threshold times in a row, retries included, so that a provider that's
+// down isn't hammered by every feed it hosts. Failures are the transient
+// ones retried; a 404 says nothing about the host. A circuit stays open
+// for as long as the Breaker is used, which is meant to be one run.
+type Breaker struct {
+	threshold int
+	mu        sync.Mutex
+	failures  map[string]int
+}
+
+// NewBreaker returns a Breaker that opens a host's circuit after
+// threshold failures in a row, or never if threshold isn't positive.
+func NewBreaker(threshold int) *Breaker {
+	return &Breaker{threshold: threshold, failures: make(map[string]int)}
+}
+
+// WithBreaker returns a context that has HTTP sources share b.
+func WithBreaker(ctx context.Context, b *Breaker) context.Context {
+	return context.WithValue(ctx, breakerKey{}, b)
+}
+
+func breaker(ctx context.Context) *Breaker {
+	b, _ := ctx.Value(breakerKey{}).(*Breaker)
+	return b
+}
+
+// allow returns ErrCircuitOpen if host's circuit is open.
+func (b *Breaker) allow(host string) error {
+	if b == nil || b.threshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures[host] >= b.threshold {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// record notes whether a request to host failed, which counts towards
+// opening its circuit, or got an answer, which starts the count again.
+func (b *Breaker) record(host string, failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if failed {
+		b.failures[host]++
+		if b.failures[host] == b.threshold {
+			slog.Warn("Host keeps failing; not fetching from it again this run", "host", host, "failures", b.threshold)
+		}
+	} else if b.failures[host] < b.threshold {
+		delete(b.failures, host)
+	}
+}
+
+// Open returns the hosts whose circuits are open, sorted.
+func (b *Breaker) Open() []string {
+	if b == nil || b.threshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var hosts []string
+	for host, n := range b.failures {
+		if n >= b.threshold {
+			hosts = append(hosts, host)
+		}
+	}
+	slices.Sort(hosts)
+	return hosts
+}
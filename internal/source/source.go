@@ -0,0 +1,100 @@
+// Package source finds and fetches the iCalendar feeds to publish.
+//
+// A feed is named by a spec, usually a URL, whose scheme picks the Source
+// that fetches it. New kinds of feed are added by calling Register from an
+// init function, without changing the pipeline.
+package source
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// RawCalendar is one feed as fetched, before parsing.
+type RawCalendar struct {
+	// Name identifies the feed, e.g. a file path. It may be logged, so
+	// it never contains a URL's secret path.
+	Name string
+	Data []byte
+}
+
+// Parse parses the feed.
+func (r RawCalendar) Parse() (*ics.Calendar, error) {
+	cal, err := ics.ParseCalendar(bytes.NewReader(r.Data))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", r.Name, err)
+	}
+	return cal, nil
+}
+
+// A Source fetches one or more feeds.
+type Source interface {
+	Fetch(ctx context.Context) ([]RawCalendar, error)
+}
+
+// Opener creates the Source for a spec.
+type Opener func(spec string) (Source, error)
+
+var (
+	mu      sync.RWMutex
+	openers = make(map[string]Opener)
+)
+
+// Register makes a Source available for specs with the given URL scheme.
+// It panics if the scheme is already registered.
+func Register(scheme string, open Opener) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := openers[scheme]; dup {
+		panic("source: Register called twice for scheme " + scheme)
+	}
+	openers[scheme] = open
+}
+
+// Schemes lists the registered schemes.
+func Schemes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	var schemes []string
+	for s := range openers {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open returns the Source for spec. A spec without a scheme is a file
+// path.
+func Open(spec string) (Source, error) {
+	if spec == "" {
+		return nil, fmt.Errorf("no feed given")
+	}
+	scheme, _, ok := strings.Cut(spec, ":")
+	if !ok || strings.ContainsAny(scheme, `/\.`) || len(scheme) == 1 {
+		// a path (or a Windows drive letter)
+		scheme = "file"
+	}
+	mu.RLock()
+	open, ok := openers[scheme]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown feed scheme %q (have %s)", scheme, strings.Join(Schemes(), ", "))
+	}
+	return open(spec)
+}
+
+// URLs returns the feed specs from CALENDAR_1 to CALENDAR_3.
+func URLs() []string {
+	return []string{
+		os.Getenv("CALENDAR_1"),
+		os.Getenv("CALENDAR_2"),
+		os.Getenv("CALENDAR_3"),
+	}
+}
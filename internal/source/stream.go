@@ -0,0 +1,136 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// A Streamer is a Source that can pass its feeds on as they're read,
+// rather than holding each in memory.
+type Streamer interface {
+	Source
+	// Stream calls read with each feed's body, which is only valid
+	// until read returns.
+	Stream(ctx context.Context, read func(name string, r io.Reader) error) error
+}
+
+// FilterWindow copies a feed from r to w, leaving out the events that
+// can't fall between from and to: it keeps recurring ones, and those whose
+// DTSTART is within a day of the window, which covers any time zone.
+// Events are dropped as they're read, without parsing anything but
+// DTSTART, so memory doesn't grow with the size of the feed and the many
+// past events of a long-lived feed cost next to nothing. If max is
+// positive no more than max events are kept. It returns how many events
+// the feed had, and whether any in the window were dropped for max.
+func FilterWindow(w io.Writer, r io.Reader, from, to time.Time, max int) (events int, truncated bool, err error) {
+	from, to = from.Add(-24*time.Hour), to.Add(24*time.Hour)
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+	// the event being read, with CRLFs; it's reused from one to the next
+	var event bytes.Buffer
+	inEvent := false
+	var long []byte
+	kept := 0
+	for {
+		line, err := readLine(br, &long)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, false, err
+		}
+		if len(line) > 0 || err == nil {
+			switch {
+			case !inEvent && bytes.EqualFold(line, beginVEvent):
+				inEvent = true
+				event.Reset()
+				event.Write(line)
+				event.WriteString("\r\n")
+			case inEvent:
+				event.Write(line)
+				event.WriteString("\r\n")
+				if bytes.EqualFold(line, endVEvent) {
+					inEvent = false
+					events++
+					switch {
+					case !inWindow(event.Bytes(), from, to):
+					case max > 0 && kept == max:
+						truncated = true
+					default:
+						kept++
+						bw.Write(event.Bytes())
+					}
+				}
+			default:
+				bw.Write(line)
+				bw.WriteString("\r\n")
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return events, truncated, bw.Flush()
+}
+
+var (
+	beginVEvent = []byte("BEGIN:VEVENT")
+	endVEvent   = []byte("END:VEVENT")
+	crlf        = []byte("\r\n")
+)
+
+// readLine returns br's next line without its line ending. It's only
+// valid until the next read, unless it was longer than br's buffer and had
+// to be collected in long.
+func readLine(br *bufio.Reader, long *[]byte) ([]byte, error) {
+	line, err := br.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		*long = append((*long)[:0], line...)
+		for errors.Is(err, bufio.ErrBufferFull) {
+			line, err = br.ReadSlice('\n')
+			*long = append(*long, line...)
+		}
+		line = *long
+	}
+	return bytes.TrimRight(line, "\r\n"), err
+}
+
+// inWindow reports whether the VEVENT in event, lines ended with CRLF,
+// recurs or starts between from and to. An event whose DTSTART can't be
+// read is kept, for the expansion to report.
+func inWindow(event []byte, from, to time.Time) bool {
+	var buf [64]byte
+	dtstart := buf[:0]
+	unfolding := false
+	for len(event) > 0 {
+		var line []byte
+		line, event, _ = bytes.Cut(event, crlf)
+		if unfolding && len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			dtstart = append(dtstart, line[1:]...)
+			continue
+		}
+		unfolding = false
+		name := line
+		if i := bytes.IndexAny(line, ":;"); i >= 0 {
+			name = line[:i]
+		}
+		switch {
+		case bytes.EqualFold(name, []byte("RRULE")), bytes.EqualFold(name, []byte("RDATE")):
+			return true
+		case bytes.EqualFold(name, []byte("DTSTART")):
+			dtstart = append(dtstart[:0], line...)
+			unfolding = true
+		}
+	}
+	value := dtstart[bytes.LastIndexByte(dtstart, ':')+1:]
+	value = bytes.TrimSuffix(value, []byte("Z"))
+	for _, layout := range []string{"20060102T150405", "20060102"} {
+		// floating and TZID times are read as UTC; the day's margin
+		// allows for that
+		if t, err := time.Parse(layout, string(value)); err == nil {
+			return t.After(from) && t.Before(to)
+		}
+	}
+	return true
+}
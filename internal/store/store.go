@@ -0,0 +1,295 @@
+//go:build !js
+
+// Package store keeps every feed's expanded events in a bbolt database, so
+// a run can skip a feed that hasn't changed since the last one, even one in
+// another process, and events can still be looked up once they've dropped
+// out of the window.
+//
+// The database has a bucket per feed, holding the feed's metadata under
+// "meta" and its events in an "events" bucket keyed by start time (UTC, so
+// they sort) and UID: an event is one instance of one VEVENT.
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/jackdorland/www/internal/model"
+)
+
+var (
+	feedsBucket  = []byte("feeds")
+	eventsBucket = []byte("events")
+	metaKey      = []byte("meta")
+)
+
+// keyTime is the layout of the start times in event keys: fixed width, so
+// they sort.
+const keyTime = "2006-01-02T15:04:05.000000000Z"
+
+// Store is an open event store.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens the store at path, creating it if need be. Only one process
+// can have it open at a time; Open waits up to 10 seconds for another to
+// close it.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening store: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(feedsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening store: %w", err)
+	}
+	return &Store{db}, nil
+}
+
+// OpenReadOnly opens an existing store for Events only.
+func OpenReadOnly(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("opening store: %w", err)
+	}
+	return &Store{db}, nil
+}
+
+// Close closes the store.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Feed is what the store knows about a feed as of the run that last
+// expanded it.
+type Feed struct {
+	Calendar int    `json:"calendar"`
+	Name     string `json:"name"`
+	// SHA256 is the hash of the feed's body.
+	SHA256 string `json:"sha256"`
+	// From and To are the span the events were expanded over, and Params
+	// anything else they depend on, like the time zone; the events are
+	// only reused for the same ones.
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Params string    `json:"params"`
+
+	Events    int      `json:"events"`
+	Skipped   int      `json:"skipped"`
+	Truncated []string `json:"truncated,omitempty"`
+	// Updated is when the feed was last fetched.
+	Updated time.Time `json:"updated"`
+}
+
+// feedKey names the calendar'th spec's feed name.
+func feedKey(calendar int, name string) []byte {
+	return []byte(strconv.Itoa(calendar) + " " + name)
+}
+
+// eventKey identifies e among its feed's events.
+func eventKey(e model.Event) []byte {
+	return []byte(e.Start.UTC().Format(keyTime) + " " + e.UID)
+}
+
+// event is how an event is stored; model.Event leaves out the UID. Seq is
+// its place in the events Put was given, so Feed returns them in the same
+// order and a reused feed hashes the same as a reexpanded one.
+type event struct {
+	Title   string    `json:"title"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Private bool      `json:"private,omitempty"`
+	UID     string    `json:"uid,omitempty"`
+	Seq     int       `json:"seq"`
+	// Categories were added later, so they come last.
+	Categories []string `json:"categories,omitempty"`
+}
+
+// Feed returns the metadata of the calendar'th spec's feed name, and its
+// events starting between its From and To, if the store has it.
+func (s *Store) Feed(calendar int, name string) (f Feed, events []model.Event, ok bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(feedsBucket).Bucket(feedKey(calendar, name))
+		if b == nil {
+			return nil
+		}
+		f, events, err = readFeed(b)
+		ok = err == nil
+		return err
+	})
+	if err != nil {
+		return Feed{}, nil, false, fmt.Errorf("reading store: %w", err)
+	}
+	return f, events, ok, nil
+}
+
+// Calendar returns what Feed does for each of the calendar'th spec's
+// feeds in the store, in order of name.
+func (s *Store) Calendar(calendar int) (feeds []Feed, events [][]model.Event, err error) {
+	prefix := feedKey(calendar, "")
+	err = s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(feedsBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			f, fe, err := readFeed(tx.Bucket(feedsBucket).Bucket(k))
+			if err != nil {
+				return err
+			}
+			feeds, events = append(feeds, f), append(events, fe)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading store: %w", err)
+	}
+	return feeds, events, nil
+}
+
+// readFeed reads the feed bucket b's metadata, and its events between its
+// From and To.
+func readFeed(b *bolt.Bucket) (f Feed, events []model.Event, err error) {
+	if err := json.Unmarshal(b.Get(metaKey), &f); err != nil {
+		return Feed{}, nil, err
+	}
+	var stored []event
+	if err := feedEvents(b, f.From, f.To, func(e event) { stored = append(stored, e) }); err != nil {
+		return Feed{}, nil, err
+	}
+	// back in the order they were put
+	slices.SortFunc(stored, func(a, b event) int { return a.Seq - b.Seq })
+	for _, e := range stored {
+		events = append(events, e.model(f.Calendar))
+	}
+	return f, events, nil
+}
+
+// Touch sets the Updated time of the calendar'th spec's feed name, for a
+// run that fetched it and found it unchanged.
+func (s *Store) Touch(calendar int, name string, updated time.Time) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(feedsBucket).Bucket(feedKey(calendar, name))
+		if b == nil {
+			return nil
+		}
+		var f Feed
+		if err := json.Unmarshal(b.Get(metaKey), &f); err != nil {
+			return err
+		}
+		f.Updated = updated
+		meta, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		return b.Put(metaKey, meta)
+	})
+	if err != nil {
+		return fmt.Errorf("writing store: %w", err)
+	}
+	return nil
+}
+
+// Put records f and its events, expanded over f.From to f.To. They replace
+// the feed's events from f.From on; those before it are kept, as history.
+func (s *Store) Put(f Feed, events []model.Event) error {
+	meta, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(feedsBucket).CreateBucketIfNotExists(feedKey(f.Calendar, f.Name))
+		if err != nil {
+			return err
+		}
+		if err := b.Put(metaKey, meta); err != nil {
+			return err
+		}
+		eb, err := b.CreateBucketIfNotExists(eventsBucket)
+		if err != nil {
+			return err
+		}
+		c := eb.Cursor()
+		from := []byte(f.From.UTC().Format(keyTime))
+		for k, _ := c.Seek(from); k != nil; k, _ = c.Seek(from) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		for i, e := range events {
+			data, err := json.Marshal(event{e.Title, e.Start, e.End, e.Private, e.UID, i, e.Categories})
+			if err != nil {
+				return err
+			}
+			key := eventKey(e)
+			// events with no UID, or repeated ones, at the same time
+			for n := 2; eb.Get(key) != nil; n++ {
+				key = fmt.Appendf(eventKey(e), " %d", n)
+			}
+			if err := eb.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("writing store: %w", err)
+	}
+	return nil
+}
+
+// Events returns every feed's events that start between from and to, in
+// order of start.
+func (s *Store) Events(from, to time.Time) ([]model.Event, error) {
+	var events []model.Event
+	err := s.db.View(func(tx *bolt.Tx) error {
+		feeds := tx.Bucket(feedsBucket)
+		if feeds == nil {
+			return nil
+		}
+		return feeds.ForEachBucket(func(k []byte) error {
+			b := feeds.Bucket(k)
+			var f Feed
+			if err := json.Unmarshal(b.Get(metaKey), &f); err != nil {
+				return err
+			}
+			return feedEvents(b, from, to, func(e event) { events = append(events, e.model(f.Calendar)) })
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading store: %w", err)
+	}
+	slices.SortStableFunc(events, func(a, b model.Event) int { return a.Start.Compare(b.Start) })
+	return events, nil
+}
+
+func (e event) model(calendar int) model.Event {
+	return model.Event{Title: e.Title, Start: e.Start, End: e.End, Private: e.Private, Categories: e.Categories, Calendar: calendar, UID: e.UID}
+}
+
+// feedEvents calls add with each event in the feed bucket b that starts
+// between from and to.
+func feedEvents(b *bolt.Bucket, from, to time.Time, add func(event)) error {
+	eb := b.Bucket(eventsBucket)
+	if eb == nil {
+		return nil
+	}
+	c := eb.Cursor()
+	end := []byte(to.UTC().Format(keyTime))
+	for k, v := c.Seek([]byte(from.UTC().Format(keyTime))); k != nil && bytes.Compare(k, end) < 0; k, v = c.Next() {
+		var e event
+		if err := json.Unmarshal(v, &e); err != nil {
+			return err
+		}
+		add(e)
+	}
+	return nil
+}
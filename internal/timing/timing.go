@@ -0,0 +1,71 @@
+// Package timing adds up how long the pipeline spends in each stage, for
+// the breakdown -pprof writes. It does nothing until Enable is called,
+// which like clock.Set is meant to happen once at startup.
+package timing
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// The stages, in the order Write lists them.
+const (
+	Fetch     = "fetch"     // downloading and filtering feeds
+	Parse     = "parse"     // parsing what's kept of them
+	Expand    = "expand"    // expanding events and RRULEs
+	Serialize = "serialize" // marshalling the calendar JSON
+	Encrypt   = "encrypt"   // encrypting it, keys included
+)
+
+var stages = []string{Fetch, Parse, Expand, Serialize, Encrypt}
+
+var (
+	enabled bool
+	mu      sync.Mutex
+	totals  = make(map[string]total)
+)
+
+type total struct {
+	d time.Duration
+	n int
+}
+
+// Enable starts recording.
+func Enable() {
+	enabled = true
+}
+
+// Add adds d to stage's total.
+func Add(stage string, d time.Duration) {
+	if !enabled {
+		return
+	}
+	mu.Lock()
+	t := totals[stage]
+	totals[stage] = total{t.d + d, t.n + 1}
+	mu.Unlock()
+}
+
+// Since adds the time since start to stage's total, as in
+//
+//	defer timing.Since(timing.Parse, time.Now())
+func Since(stage string, start time.Time) {
+	Add(stage, time.Since(start))
+}
+
+// Write writes each stage's total time and how many times it ran. Feeds
+// are fetched and expanded concurrently, so the totals can add up to more
+// than the time the run took.
+func Write(w io.Writer) error {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, stage := range stages {
+		t := totals[stage]
+		if _, err := fmt.Fprintf(w, "%-10s %12s %6d\n", stage, t.d.Round(time.Microsecond), t.n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+// Package tracing records the pipeline's stages as OpenTelemetry spans and
+// exports them over OTLP/HTTP, in its JSON encoding, to a collector or a
+// tracing backend. Like timing it does nothing until Enable is called,
+// which is meant to happen once at startup; until then Start returns a nil
+// *Span, whose methods do nothing.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exporter is where spans are sent.
+type Exporter struct {
+	// Endpoint is the collector's base URL, as in
+	// OTEL_EXPORTER_OTLP_ENDPOINT; spans are posted to its /v1/traces.
+	Endpoint string
+	// Header is sent with each export, for a backend's API key.
+	Header http.Header
+	// Service is the service.name the spans are reported under.
+	Service string
+}
+
+var (
+	exporter *Exporter
+	mu       sync.Mutex
+	ended    []*Span
+)
+
+// Enable starts recording spans for e.
+func Enable(e Exporter) {
+	exporter = &e
+}
+
+// Enabled reports whether Enable has been called.
+func Enabled() bool {
+	return exporter != nil
+}
+
+// A Span is one stage of a run, from Start to End.
+type Span struct {
+	name    string
+	traceID [16]byte
+	id      [8]byte
+	parent  [8]byte
+	start   time.Time
+	end     time.Time
+	mu      sync.Mutex
+	attrs   map[string]any
+	err     error
+}
+
+type spanKey struct{}
+
+// Start starts a span named name, a child of the span in ctx if there is
+// one, and returns a context carrying it. attrs are key, value pairs as
+// SetAttr takes them.
+func Start(ctx context.Context, name string, attrs ...any) (context.Context, *Span) {
+	if exporter == nil {
+		return ctx, nil
+	}
+	s := &Span{name: name, start: time.Now(), attrs: make(map[string]any)}
+	rand.Read(s.id[:])
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		s.traceID, s.parent = parent.traceID, parent.id
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.SetAttr(fmt.Sprint(attrs[i]), attrs[i+1])
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SetAttr sets an attribute of s: a string, bool, int or float64, or
+// anything else as its fmt.Sprint.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+// End ends s, marking it failed with err if err isn't nil. The span is
+// exported by the next Flush.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.end, s.err = time.Now(), err
+	s.mu.Unlock()
+	mu.Lock()
+	ended = append(ended, s)
+	mu.Unlock()
+}
+
+// Flush exports the spans ended since the last Flush. It's called at the
+// end of each run rather than as spans end, so tracing costs a run one
+// request.
+func Flush(ctx context.Context) error {
+	if exporter == nil {
+		return nil
+	}
+	mu.Lock()
+	spans := ended
+	ended = nil
+	mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(exportRequest(exporter.Service, spans))
+	if err != nil {
+		return err
+	}
+	return post(ctx, *exporter, body)
+}
+
+// exportRequest is the OTLP ExportTraceServiceRequest for spans.
+func exportRequest(service string, spans []*Span) map[string]any {
+	var encoded []map[string]any
+	for _, s := range spans {
+		s.mu.Lock()
+		span := map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.id[:]),
+			"name":              s.name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        attributes(s.attrs),
+		}
+		if s.parent != [8]byte{} {
+			span["parentSpanId"] = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != nil {
+			span["status"] = map[string]any{"code": 2, "message": s.err.Error()} // STATUS_CODE_ERROR
+		}
+		s.mu.Unlock()
+		encoded = append(encoded, span)
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": attributes(map[string]any{"service.name": service})},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "github.com/jackdorland/www/internal/tracing"},
+				"spans": encoded,
+			}},
+		}},
+	}
+}
+
+// attributes encodes attrs as OTLP KeyValues.
+func attributes(attrs map[string]any) []map[string]any {
+	kvs := make([]map[string]any, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]any
+		switch v := v.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			// int64s are strings in OTLP's JSON
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		kvs = append(kvs, map[string]any{"key": k, "value": value})
+	}
+	return kvs
+}
+
+// post sends an export to e.
+func post(ctx context.Context, e Exporter, body []byte) error {
+	endpoint := strings.TrimSuffix(e.Endpoint, "/") + "/v1/traces"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("exporting spans: %w", err)
+	}
+	for k, v := range e.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return fmt.Errorf("exporting spans to %s: %w", e.Endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("exporting spans to %s: %s %s", e.Endpoint, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
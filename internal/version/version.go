@@ -0,0 +1,91 @@
+// Package version reports which build of calendar-setup is running.
+package version
+
+import (
+	"runtime/debug"
+	"strings"
+)
+
+// Set at build time with
+//
+//	-ldflags "-X github.com/jackdorland/www/internal/version.Version=v1.2.0
+//	          -X github.com/jackdorland/www/internal/version.Commit=$(git rev-parse HEAD)
+//	          -X github.com/jackdorland/www/internal/version.Date=$(date -u +%FT%TZ)"
+//
+// Anything left unset is taken from the build info Go embeds, when there is
+// some (go build in a git checkout, or go install of a tagged version).
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+// Info describes the running build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build's version information.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.GoVersion = bi.GoVersion
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		modified := false
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.Date == "" {
+					info.Date = s.Value
+				}
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if modified && Commit == "" {
+			info.Commit += "-dirty"
+		}
+	}
+	if info.Version == "" {
+		info.Version = "devel"
+	}
+	return info
+}
+
+// Short identifies the build in one word, like "v1.2.0+3bf0964": the
+// version, plus the abbreviated commit if known and the version (a
+// pseudo-version like v0.0.0-20240102150405-3bf0964a1b2c) doesn't already
+// name it.
+func (i Info) Short() string {
+	commit, dirty := strings.CutSuffix(i.Commit, "-dirty")
+	if commit == "" || len(commit) >= 12 && strings.Contains(i.Version, commit[:12]) {
+		return i.Version
+	}
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	if dirty {
+		commit += "-dirty"
+	}
+	return i.Version + "+" + commit
+}
+
+func (i Info) String() string {
+	s := "calendar-setup " + i.Version
+	if i.Commit != "" {
+		s += "\ncommit " + i.Commit
+	}
+	if i.Date != "" {
+		s += "\nbuilt  " + i.Date
+	}
+	return s + "\n" + i.GoVersion
+}